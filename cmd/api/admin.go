@@ -0,0 +1,375 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/validator"
+)
+
+// listUsersHandler lists and searches the user accounts
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.UserFilter
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Query = app.readString(qs, "q", "")
+	input.Activated = app.readBool(qs, "activated", v)
+	input.Permission = app.readString(qs, "permission", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	app.readPagination(qs, &input.Filters, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "-id", "name", "-name", "email", "-email", "created_at", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(input.UserFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showUserHandler returns a user account with its permissions
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeUserPermissions(w, r, user.ID)
+}
+
+func (app *application) grantUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	codes, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Code != "", "code", "must be provided")
+	v.Check(codes.Include(input.Code), "code", "is not a known permission")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Transaction(func(tx *sql.Tx) error {
+		err := app.models.Permissions.AddForUserTx(tx, user.ID, input.Code)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, data.AuditPermissionGrant, user.ID, map[string]any{"code": input.Code})
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserPermissions(w, r, user.ID)
+}
+
+func (app *application) revokeUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	codes, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(codes.Include(code), "code", "is not a known permission")
+	// Keep at least one admin able to undo mistakes
+	v.Check(user.ID != app.contextGetUser(r).ID || code != "admin:users", "code", "you cannot revoke your own admin:users permission")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Transaction(func(tx *sql.Tx) error {
+		err := app.models.Permissions.RemoveForUserTx(tx, user.ID, code)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, data.AuditPermissionRevoke, user.ID, map[string]any{"code": code})
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserPermissions(w, r, user.ID)
+}
+
+// updateUserActivationHandler deactivates or reactivates an account. A
+// deactivated user is also logged out everywhere and loses their API keys, and
+// can't log in again until reactivated.
+func (app *application) updateUserActivationHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Activated *bool `json:"activated"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Activated != nil, "activated", "must be provided")
+	if input.Activated != nil && !*input.Activated {
+		v.Check(user.ID != app.contextGetUser(r).ID, "activated", "you cannot deactivate your own account")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user.Activated = *input.Activated
+
+	err = app.models.Transaction(func(tx *sql.Tx) error {
+		err := app.models.Users.UpdateTx(tx, user)
+		if err != nil {
+			return err
+		}
+
+		action := data.AuditUserActivate
+		if !user.Activated {
+			action = data.AuditUserDeactivate
+
+			err = app.models.Tokens.DeleteSessionsForUserTx(tx, user.ID)
+			if err != nil {
+				return err
+			}
+
+			err = app.models.APIKeys.DeleteAllForUserTx(tx, user.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return app.audit(tx, r, action, user.ID, nil)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// logoutUserHandler revokes every session of a user. Their API keys are left
+// alone; deactivating the account revokes those too.
+func (app *application) logoutUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Transaction(func(tx *sql.Tx) error {
+		err := app.models.Tokens.DeleteSessionsForUserTx(tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, data.AuditUserLogout, user.ID, nil)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all sessions of the user were logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.AuditFilter
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.ActorID = int64(app.readInt(qs, "actor_id", 0, v))
+	input.TargetUserID = int64(app.readInt(qs, "target_user_id", 0, v))
+	input.Action = app.readString(qs, "action", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	app.readPagination(qs, &input.Filters, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "-id"}
+
+	if input.Action != "" {
+		v.Check(validator.PermittedValue(input.Action, data.AuditActions...), "action", "invalid action")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.AuditLog.GetAll(input.AuditFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"audit_log": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// writeUserPermissions sends the permission codes of a user
+func (app *application) writeUserPermissions(w http.ResponseWriter, r *http.Request, userID int64) {
+	permissions, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readUserParam loads the user named by the id parameter, sending the error
+// response itself when it can't
+func (app *application) readUserParam(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// audit records a change made by the user of the request to a user account,
+// noting the API key when the request was made with one. It is written in tx,
+// the transaction of the change itself.
+func (app *application) audit(tx *sql.Tx, r *http.Request, action string, targetUserID int64, details map[string]any) error {
+	actorID := app.contextGetUser(r).ID
+
+	if key := app.contextGetAPIKey(r); key != nil {
+		if details == nil {
+			details = map[string]any{}
+		}
+		details["api_key_id"] = key.ID
+	}
+
+	return app.models.AuditLog.Insert(tx, &data.AuditEntry{
+		ActorID:      &actorID,
+		Action:       action,
+		TargetUserID: &targetUserID,
+		Details:      details,
+		IP:           realIP(r),
+	})
+}
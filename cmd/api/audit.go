@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"qumran.jesarx.com/internal/audit"
+	"qumran.jesarx.com/internal/validator"
+)
+
+// auditAssetsHandler reports orphaned, missing and empty files. Fixing them,
+// and verifying the torrents against their PDFs, which reads every PDF and
+// takes far longer than a request may, is left to qumranctl audit.
+func (app *application) auditAssetsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	v := validator.New()
+
+	verifyTorrents, err := strconv.ParseBool(app.readString(qs, "torrents", "false"))
+	if err != nil {
+		v.AddError("torrents", "must be a boolean value")
+	} else if verifyTorrents {
+		v.AddError("torrents", "torrents can only be verified with qumranctl audit")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	books, err := app.models.Books.GetAllWithFiles()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	report, err := audit.Run(books, app.storage, audit.Options{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"audit": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -25,6 +25,7 @@ func (app *application) listAuthorsHandler(w http.ResponseWriter, r *http.Reques
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	app.readPagination(qs, &input.Filters, v)
 
 	input.Filters.Sort = app.readString(qs, "sort", "last_name")
 	input.Filters.SortSafelist = []string{"name", "-name", "last_name", "-last_name", "id", "-id", "book_count", "-book_count"}
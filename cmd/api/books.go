@@ -5,28 +5,50 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"path/filepath"
 
 	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/storage"
 	"qumran.jesarx.com/internal/validator"
 )
 
+type bookInput struct {
+	Title        string   `json:"title"`
+	ShortTitle   string   `json:"short_title"`
+	Tags         []string `json:"tags"`
+	Year         int32    `json:"year"`
+	AuthorID     int64    `json:"author_id"`
+	Author2ID    *int64   `json:"author2_id"`
+	PublisherID  int64    `json:"publisher_id"`
+	ISBN         string   `json:"isbn"`
+	Description  string   `json:"description"`
+	Pages        int32    `json:"pages"`
+	DirDwl       bool     `json:"dir_dwl"`
+	ExternalLink string   `json:"external_link"`
+}
+
+func (input bookInput) book(filename string) *data.Book {
+	return &data.Book{
+		Title:        input.Title,
+		ShortTitle:   input.ShortTitle,
+		Year:         input.Year,
+		Tags:         input.Tags,
+		AuthorID:     input.AuthorID,
+		Author2ID:    input.Author2ID,
+		PublisherID:  input.PublisherID,
+		Filename:     filename,
+		ISBN:         input.ISBN,
+		Description:  input.Description,
+		Pages:        input.Pages,
+		DirDwl:       input.DirDwl,
+		ExternalLink: input.ExternalLink,
+	}
+}
+
 // CREATE BOOK
+// Files are staged and processed by a background job; the response points to
+// the job so clients can follow the ingestion.
 func (app *application) createBookHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Title        string   `json:"title"`
-		ShortTitle   string   `json:"short_title"`
-		Tags         []string `json:"tags"`
-		Year         int32    `json:"year"`
-		AuthorID     int64    `json:"author_id"`
-		Author2ID    *int64   `json:"author2_id"`
-		PublisherID  int64    `json:"publisher_id"`
-		ISBN         string   `json:"isbn"`
-		Description  string   `json:"description"`
-		Pages        int32    `json:"pages"`
-		DirDwl       bool     `json:"dir_dwl"`
-		ExternalLink string   `json:"external_link"`
-	}
+	var input bookInput
 
 	// FILE UPLOAD
 
@@ -36,53 +58,107 @@ func (app *application) createBookHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	result, err := app.processFiles(w, r, "pdf", "image", input.ShortTitle, input.AuthorID, input.PublisherID)
+	// Validate what we can before queueing, the filename is only known once
+	// the files are processed
+	v := validator.New()
+
+	if data.ValidateBook(v, input.book("")); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	pdf, err := app.stageUpload(r, "pdf")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	baseFilename := result["filename"]
+	// Large PDFs are sent beforehand as a resumable upload
+	var claimed *claimedUpload
 
-	book := &data.Book{
-		Title:        input.Title,
-		ShortTitle:   input.ShortTitle,
-		Year:         input.Year,
-		Tags:         input.Tags,
-		AuthorID:     input.AuthorID,
-		Author2ID:    input.Author2ID,
-		PublisherID:  input.PublisherID,
-		Filename:     baseFilename,
-		ISBN:         input.ISBN,
-		Description:  input.Description,
-		Pages:        input.Pages,
-		DirDwl:       input.DirDwl,
-		ExternalLink: input.ExternalLink,
+	if uploadID := r.FormValue("pdf_upload_id"); uploadID != "" {
+		if pdf != "" {
+			app.removeStaged(pdf)
+			v.AddError("pdf_upload_id", "must not be sent together with a pdf file")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		claimed, err = app.claimUpload(r, uploadID)
+		if err != nil {
+			var invalidErr invalidUploadError
+			switch {
+			case errors.As(err, &invalidErr):
+				v.AddError("pdf_upload_id", invalidErr.Error())
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		pdf = claimed.name
 	}
 
-	v := validator.New()
+	// discardPDF removes the staged PDF when the book is not queued. A
+	// claimed upload is given back instead, so the client can retry with it.
+	discardPDF := func() {
+		if claimed != nil {
+			app.releaseUpload(claimed)
+			return
+		}
 
-	if data.ValidateBook(v, book); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
-		return
+		app.removeStaged(pdf)
 	}
 
-	err = app.models.Books.Insert(book)
+	// Point the client to the existing book instead of queueing a duplicate
+	if pdf != "" {
+		_, sourceHash, err := hashFile(app.stagedPath(pdf))
+		if err != nil {
+			discardPDF()
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		existing, err := app.models.BookFiles.GetBookByPDFHash(sourceHash)
+		switch {
+		case err == nil:
+			discardPDF()
+			app.duplicateBookResponse(w, r, existing)
+			return
+		case !errors.Is(err, data.ErrRecordNotFound):
+			discardPDF()
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	image, err := app.stageUpload(r, "image")
 	if err != nil {
+		discardPDF()
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	completeBook, err := app.models.Books.GetByID(book.ID)
+	user := app.contextGetUser(r)
+
+	job, err := app.enqueueJob(data.JobKindBookIngest, ingestPayload{Book: input, PDF: pdf, Image: image}, &user.ID)
 	if err != nil {
+		discardPDF()
+		app.removeStaged(image)
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	// The job owns the PDF now
+	if claimed != nil {
+		app.finishUpload(claimed)
+	}
+
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/books/%d", completeBook.ID))
+	headers.Set("Location", fmt.Sprintf("/v1/jobs/%d", job.ID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"book": completeBook}, headers)
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"job": job}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -96,6 +172,15 @@ func (app *application) showBookHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	format := r.URL.Query().Get("format")
+
+	v := validator.New()
+	v.Check(validator.PermittedValue(format, "", "json", "jsonld"), "format", "must be json or jsonld")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	book, err := app.models.Books.GetBySlug(slug)
 	if err != nil {
 		switch {
@@ -104,9 +189,30 @@ func (app *application) showBookHandler(w http.ResponseWriter, r *http.Request)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
+		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, nil)
+	book.Files, err = app.models.BookFiles.GetForBook(book.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.loadCovers(book)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.setMagnetURIs(book)
+
+	w.Header().Add("Vary", "Accept")
+
+	if wantsJSONLD(r, format) {
+		err = app.writeJSONLD(w, http.StatusOK, app.bookJSONLD(book))
+	} else {
+		err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, nil)
+	}
 	if err != nil {
 		app.logger.Error(err.Error())
 		http.Error(w, "The server encoutered a problem and could not process your request", http.StatusInternalServerError)
@@ -154,14 +260,6 @@ func (app *application) updateBookHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Process only image file - PDF is no longer modifiable
-	// Use existing filename from the book record
-	_, err = app.processFilesEdit(w, r, "image", book.Filename)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
 	// Update book fields if they are provided
 	if input.Title != nil {
 		book.Title = *input.Title
@@ -208,7 +306,22 @@ func (app *application) updateBookHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Books.Update(book)
+	workDir, err := os.MkdirTemp("", "qumran-upload-*")
+	if err != nil {
+		app.serverErrorResponse(w, r, fmt.Errorf("failed to create work directory: %w", err))
+		return
+	}
+	defer os.RemoveAll(workDir)
+
+	// Process only image file - PDF is no longer modifiable
+	// Use existing filename from the book record
+	cover, err := app.processCoverEdit(r, "image", workDir, book.Filename)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.updateBookCover(book, cover, workDir)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -225,6 +338,20 @@ func (app *application) updateBookHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	completeBook.Files, err = app.models.BookFiles.GetForBook(completeBook.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.loadCovers(completeBook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.setMagnetURIs(completeBook)
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"book": completeBook}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -251,33 +378,15 @@ func (app *application) deleteBookHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Define base paths for different file types
-	basePath := book.Filename
-	uploadDirs := map[string]string{
-		"pdf":              "./uploads/pdfs",
-		"cover":            "./uploads/covers",
-		"pdf_torrent":      "./uploads/torrents",
-		"pdf_torrentadded": "./uploads/torrentadded", // Fixed directory name
-	}
-
-	// Files to delete
-	filesToDelete := []string{
-		filepath.Join(uploadDirs["pdf"], basePath+".pdf"),
-		filepath.Join(uploadDirs["cover"], basePath+".jpg"),
-		filepath.Join(uploadDirs["pdf_torrent"], basePath+".pdf.torrent"),
-		filepath.Join(uploadDirs["pdf_torrentadded"], basePath+".pdf.torrent"),
-	}
-
-	// Delete associated files
-	for _, filePath := range filesToDelete {
-		if err := os.Remove(filePath); err != nil {
-			if !os.IsNotExist(err) {
-				app.logger.Error("Error deleting file %s: %v", filePath, err)
-			}
-		}
+	renditions, err := app.models.Covers.GetForBooks([]int64{book.ID})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	// Delete the book record from the database
+	// Delete the book record first, so a failed delete never leaves it
+	// pointing at removed files. Files that can't be removed afterwards are
+	// only logged.
 	err = app.models.Books.Delete(id)
 	if err != nil {
 		switch {
@@ -289,6 +398,16 @@ func (app *application) deleteBookHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	var files []bookFile
+	for _, kind := range storage.Kinds {
+		files = append(files, bookFile{kind: kind, name: storage.Filename(kind, book.Filename)})
+	}
+	for _, cover := range renditions[book.ID] {
+		files = append(files, bookFile{kind: storage.KindRendition, name: cover.Name})
+	}
+
+	app.removeFiles(files)
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "book successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -297,10 +416,9 @@ func (app *application) deleteBookHandler(w http.ResponseWriter, r *http.Request
 
 func (app *application) listBookHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title    string
-		AuthSlug string
-		PubSlug  string
-		Tags     []string
+		data.BookFilter
+		Facets     []string
+		FacetLimit int
 		data.Filters
 	}
 
@@ -309,29 +427,104 @@ func (app *application) listBookHandler(w http.ResponseWriter, r *http.Request)
 	qs := r.URL.Query()
 
 	input.Title = app.readString(qs, "title", "")
-	input.AuthSlug = app.readString(qs, "authslug", "")
-	input.PubSlug = app.readString(qs, "pubslug", "")
+	input.Query = app.readString(qs, "q", "")
 
+	// Every list filter takes several comma-separated values
+	input.AuthorSlugs = app.readCSV(qs, "authslug", []string{})
+	input.PublisherSlugs = app.readCSV(qs, "pubslug", []string{})
 	input.Tags = app.readCSV(qs, "tags", []string{})
+	input.TagMode = app.readString(qs, "tag_mode", data.TagModeAll)
+	input.Languages = app.readCSV(qs, "language", []string{})
+	input.Formats = app.readCSV(qs, "formats", []string{})
+	input.Years = app.readYearRanges(qs, "years", v)
+	input.HasTextLayer = app.readBool(qs, "has_text_layer", v)
+
+	input.Facets = app.readCSV(qs, "facets", []string{})
+	input.FacetLimit = app.readInt(qs, "facet_limit", 50, v)
+
+	v.Check(validator.PermittedValue(input.TagMode, data.TagModeAll, data.TagModeAny), "tag_mode", `must be "and" or "or"`)
+	v.Check(validator.Unique(input.Facets), "facets", "must not contain duplicate values")
+	for _, facet := range input.Facets {
+		v.Check(validator.PermittedValue(facet, data.FacetNames...), "facets", "invalid facet")
+	}
+	v.Check(input.FacetLimit > 0, "facet_limit", "must be greater than zero")
+	v.Check(input.FacetLimit <= 500, "facet_limit", "must be a maximum of 500")
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	app.readPagination(qs, &input.Filters, v)
 
-	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
-	input.Filters.SortSafelist = []string{"id", "title", "year", "tags", "-id", "-title", "-year", "-tags", "created_at", "-created_at", "random"}
+	// Full-text searches are sorted by relevance unless asked otherwise
+	defaultSort := "-created_at"
+	if input.Query != "" {
+		defaultSort = "relevance"
+	}
+
+	input.Filters.Sort = app.readString(qs, "sort", defaultSort)
+	input.Filters.SortSafelist = []string{"id", "title", "year", "tags", "-id", "-title", "-year", "-tags", "created_at", "-created_at", "random", "relevance"}
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	books, metadata, err := app.models.Books.GetAll(input.Title, input.AuthSlug, input.PubSlug, input.Tags, input.Filters)
+	books, metadata, err := app.models.Books.GetAll(input.BookFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.loadCovers(books...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headlines, err := app.models.Books.Headlines(input.Query, books)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"books": books, "metadata": metadata}, nil)
+	for _, book := range books {
+		book.Headline = headlines[book.ID]
+	}
+
+	app.setMagnetURIs(books...)
+
+	env := envelope{"books": books, "metadata": metadata}
+
+	if len(input.Facets) > 0 {
+		facets, err := app.models.Books.Facets(input.BookFilter, input.Facets, input.FacetLimit)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		env["facets"] = facets
+	}
+
+	// A search that found nothing may be misspelled
+	if len(books) == 0 && input.Filters.Page == 1 && input.Filters.Cursor == "" {
+		term := input.Query
+		if term == "" {
+			term = input.Title
+		}
+
+		if term != "" {
+			didYouMean, err := app.models.Search.DidYouMean(term)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			if didYouMean != "" {
+				env["did_you_mean"] = didYouMean
+			}
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/imaging"
+	"qumran.jesarx.com/internal/storage"
+	"qumran.jesarx.com/internal/validator"
+)
+
+// coverRenditions resizes a processed cover to every configured width and
+// format. The files are left in workDir for the caller to store.
+func (app *application) coverRenditions(coverPath string, workDir string, baseFileName string) ([]bookFile, []*data.Cover, error) {
+	renditions, err := imaging.Renditions(coverPath, workDir, baseFileName, app.config.covers.widths, app.config.covers.formats)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var files []bookFile
+	var covers []*data.Cover
+
+	for _, rendition := range renditions {
+		file := bookFile{kind: storage.KindRendition, name: filepath.Base(rendition.Path), path: rendition.Path}
+
+		file.size, file.sha256, err = hashFile(rendition.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		files = append(files, file)
+		covers = append(covers, &data.Cover{
+			Size:   rendition.Size,
+			Format: rendition.Format,
+			Width:  rendition.Width,
+			Height: rendition.Height,
+			Name:   file.name,
+			Bytes:  file.size,
+			SHA256: file.sha256,
+		})
+	}
+
+	return files, covers, nil
+}
+
+// updateBookCover saves the edited book with the new cover processed by
+// processCoverEdit, if any. The cover is stored first and the previous files
+// are put back if the update fails; the renditions that are no longer
+// produced are removed once it commits.
+func (app *application) updateBookCover(book *data.Book, cover *processedFiles, workDir string) error {
+	if cover == nil {
+		return app.models.Books.Update(book)
+	}
+
+	old, err := app.models.Covers.GetForBooks([]int64{book.ID})
+	if err != nil {
+		return err
+	}
+
+	// An uploaded cover replaces the one rendered from the PDF
+	book.CoverGenerated = false
+
+	book.Files = nil
+	for _, file := range cover.files {
+		if file.kind != storage.KindRendition {
+			book.Files = append(book.Files, file.record())
+		}
+	}
+
+	// Not nil, so the old renditions go even if there are no new ones
+	book.Covers = append([]*data.Cover{}, cover.covers...)
+
+	restore, err := app.replaceFiles(cover.files, workDir)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Books.Update(book)
+	if err != nil {
+		restore()
+		return err
+	}
+
+	current := map[string]bool{}
+	for _, file := range cover.files {
+		current[file.name] = true
+	}
+
+	var stale []bookFile
+	for _, c := range old[book.ID] {
+		if !current[c.Name] {
+			stale = append(stale, bookFile{kind: storage.KindRendition, name: c.Name})
+		}
+	}
+
+	app.removeFiles(stale)
+
+	return nil
+}
+
+// coverURL returns the URL of a cover rendition. It carries a version taken
+// from the file hash, so it changes whenever the cover does and can be cached
+// for good.
+func (app *application) coverURL(filename string, cover *data.Cover) string {
+	qs := url.Values{}
+	qs.Set("file", storage.Filename(storage.KindCover, filename))
+	qs.Set("size", strconv.Itoa(cover.Size))
+	qs.Set("format", cover.Format)
+	qs.Set("v", cover.SHA256[:min(12, len(cover.SHA256))])
+
+	return app.config.baseURL + "/v1/images?" + qs.Encode()
+}
+
+// loadCovers fills in the cover renditions of the books
+func (app *application) loadCovers(books ...*data.Book) error {
+	ids := make([]int64, 0, len(books))
+	for _, book := range books {
+		ids = append(ids, book.ID)
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	covers, err := app.models.Covers.GetForBooks(ids)
+	if err != nil {
+		return err
+	}
+
+	for _, book := range books {
+		book.Covers = covers[book.ID]
+		for _, cover := range book.Covers {
+			cover.URL = app.coverURL(book.Filename, cover)
+		}
+	}
+
+	return nil
+}
+
+// negotiateCoverFormat picks the rendition format from the format parameter,
+// or from the Accept header when it isn't set
+func negotiateCoverFormat(r *http.Request, format string) string {
+	if format != "" {
+		return format
+	}
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		params := strings.Split(accepted, ";")
+		if strings.TrimSpace(params[0]) != "image/webp" {
+			continue
+		}
+
+		// image/webp;q=0 means it is not acceptable
+		for _, param := range params[1:] {
+			if q, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+					return imaging.JPEG
+				}
+			}
+		}
+
+		return imaging.WebP
+	}
+
+	return imaging.JPEG
+}
+
+// pickCover returns the smallest rendition in the given format at least as
+// wide as size, or nil if the original is the best fit
+func pickCover(covers []*data.Cover, size int, format string) *data.Cover {
+	var widest *data.Cover
+
+	for _, cover := range covers {
+		if cover.Format != format {
+			continue
+		}
+
+		if cover.Size >= size {
+			return cover
+		}
+
+		widest = cover
+	}
+
+	// Nothing is wide enough. A rendition narrower than its nominal size is
+	// the whole image, otherwise the original is the better fit
+	if widest != nil && widest.Width < widest.Size {
+		return widest
+	}
+
+	return nil
+}
+
+// serveImages serves a cover, or the rendition that best fits the size and
+// format parameters.
+func (app *application) serveImages(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	rawName := qs.Get("file")
+	if rawName == "" {
+		app.errorResponse(w, r, http.StatusBadRequest, "file parameter is required")
+		return
+	}
+
+	fileName, err := safeFileName(rawName, []string{".jpg", ".jpeg", ".png", ".gif", ".webp"})
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "invalid file parameter")
+		return
+	}
+
+	size := app.readInt(qs, "size", 0, v)
+	format := app.readString(qs, "format", "")
+
+	v.Check(size >= 0, "size", "must not be negative")
+	v.Check(format == "" || validator.PermittedValue(format, imaging.JPEG, imaging.WebP), "format", "must be jpeg or webp")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Versioned URLs never change content, so they can be cached for good
+	if qs.Get("v") != "" {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+
+	if size == 0 && format == "" {
+		app.serveCover(w, r, storage.KindCover, fileName, "")
+		return
+	}
+
+	if format == "" {
+		w.Header().Add("Vary", "Accept")
+	}
+	format = negotiateCoverFormat(r, format)
+
+	covers, err := app.models.Covers.GetForFilename(strings.TrimSuffix(fileName, filepath.Ext(fileName)))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if size == 0 {
+		size = int(^uint(0) >> 1)
+	}
+
+	if cover := pickCover(covers, size, format); cover != nil {
+		app.serveCover(w, r, storage.KindRendition, cover.Name, cover.SHA256)
+		return
+	}
+
+	app.serveCover(w, r, storage.KindCover, fileName, "")
+}
+
+// serveCover writes an image inline, with its checksum as ETag. The checksum
+// is looked up when sha256 is empty.
+func (app *application) serveCover(w http.ResponseWriter, r *http.Request, kind storage.Kind, name string, sha256 string) {
+	file, fileInfo, err := app.storage.Get(kind, name)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	if sha256 == "" {
+		sha256 = app.fileDigest(r, kind, name, fileInfo.Size)
+	}
+	setDigestHeaders(w, sha256)
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".webp":
+		w.Header().Set("Content-Type", "image/webp")
+	case ".png":
+		w.Header().Set("Content-Type", "image/png")
+	case ".gif":
+		w.Header().Set("Content-Type", "image/gif")
+	default:
+		w.Header().Set("Content-Type", "image/jpeg")
+	}
+
+	http.ServeContent(w, r, name, fileInfo.ModTime, file)
+}
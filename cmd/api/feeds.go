@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/feeds"
+	"qumran.jesarx.com/internal/storage"
+)
+
+// feedSize is the number of books in the feeds of new books
+const feedSize = 50
+
+// booksFeedHandler serves the newest books as an Atom or RSS feed, optionally
+// scoped to an author, publisher or tag
+func (app *application) booksFeedHandler(rss bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		qs := r.URL.Query()
+
+		var filter data.BookFilter
+
+		id, title := "urn:pirateca:feed:books", "Pirateca: novedades"
+
+		switch {
+		case qs.Get("author") != "":
+			filter.AuthorSlugs = []string{qs.Get("author")}
+			id = "urn:pirateca:feed:author:" + qs.Get("author")
+		case qs.Get("publisher") != "":
+			filter.PublisherSlugs = []string{qs.Get("publisher")}
+			id = "urn:pirateca:feed:publisher:" + qs.Get("publisher")
+		case qs.Get("tag") != "":
+			filter.Tags = []string{qs.Get("tag")}
+			id = "urn:pirateca:feed:tag:" + url.PathEscape(qs.Get("tag"))
+			title = "Pirateca: " + qs.Get("tag")
+		}
+
+		books, _, err := app.models.Books.GetAll(filter, data.Filters{
+			Page:         1,
+			PageSize:     feedSize,
+			Sort:         "-created_at",
+			SortSafelist: []string{"-created_at"},
+			SkipCount:    true,
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if len(books) > 0 {
+			switch {
+			case len(filter.AuthorSlugs) > 0:
+				title = "Pirateca: " + authorOf(books[0], filter.AuthorSlugs[0])
+			case len(filter.PublisherSlugs) > 0:
+				title = "Pirateca: " + books[0].PublisherName
+			}
+		}
+
+		// The last change to a listed book dates the feed, so editing any of
+		// them invalidates If-Modified-Since too; the ETag also changes when
+		// a book leaves the feed
+		var updated time.Time
+		h := sha256.New()
+		fmt.Fprintf(h, "%s|%t|%s\n", id, rss, title)
+		for _, book := range books {
+			if book.UpdatedAt.After(updated) {
+				updated = book.UpdatedAt
+			}
+			fmt.Fprintf(h, "%d|%d|%s\n", book.ID, book.Version, strings.Join(book.Formats, ","))
+		}
+		if updated.IsZero() {
+			updated = time.Unix(0, 0)
+		}
+
+		etag := `W/"` + hex.EncodeToString(h.Sum(nil))[:32] + `"`
+
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", updated.UTC().Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", "public, max-age=300")
+
+		if notModified(r, etag, updated) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		ids := make([]int64, len(books))
+		for i, book := range books {
+			ids[i] = book.ID
+		}
+
+		files, err := app.models.BookFiles.GetForBooks(ids)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		feed := &feeds.Feed{
+			ID:      id,
+			Title:   title,
+			Self:    app.publicURL(r.URL.Path, qs),
+			Link:    app.publicURL("/v1/books", nil),
+			Updated: updated,
+		}
+
+		for _, book := range books {
+			feed.Items = append(feed.Items, app.feedItem(book, files[book.ID]))
+		}
+
+		var body []byte
+		contentType := feeds.TypeAtom
+
+		if rss {
+			body, err = feed.RSS()
+			contentType = feeds.TypeRSS
+		} else {
+			body, err = feed.Atom()
+		}
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}
+}
+
+// notModified reports whether the client's copy, named by If-None-Match or
+// else dated by If-Modified-Since, is current
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// feedItem describes a book in a feed. The PDF comes first among the
+// enclosures, RSS keeps only that one.
+func (app *application) feedItem(book *data.Book, files []*data.BookFile) feeds.Item {
+	item := feeds.Item{
+		ID:         fmt.Sprintf("urn:pirateca:book:%d", book.ID),
+		Title:      book.Title,
+		Link:       app.frontendURL(data.SitemapBooks, book.Slug),
+		Categories: book.Tags,
+		Published:  book.CreatedAt,
+		Authors:    []string{authorOf(book, book.AuthorSlug)},
+	}
+
+	if item.Link == "" {
+		item.Link = app.publicURL("/v1/books/"+book.Slug, nil)
+	}
+
+	if book.Author2Slug != nil {
+		item.Authors = append(item.Authors, authorOf(book, *book.Author2Slug))
+	}
+
+	item.Summary = fmt.Sprintf("%s, %s (%d)", strings.Join(item.Authors, " y "), book.PublisherName, book.Year)
+
+	// Feed readers download enclosures on their own, so books whose direct
+	// download was turned off (dir_dwl) have none
+	if !book.DirDwl {
+		return item
+	}
+
+	enclosures := []struct {
+		kind        storage.Kind
+		path        string
+		contentType string
+	}{
+		{storage.KindPDF, "/v1/pdfs", "application/pdf"},
+		{storage.KindEPUB, "/v1/epubs", "application/epub+zip"},
+	}
+
+	for _, e := range enclosures {
+		for _, file := range files {
+			if file.Kind != string(e.kind) {
+				continue
+			}
+
+			item.Enclosures = append(item.Enclosures, feeds.Enclosure{
+				URL:    app.publicURL(e.path, url.Values{"file": {file.Name}}),
+				Type:   e.contentType,
+				Length: file.Size,
+			})
+		}
+	}
+
+	return item
+}
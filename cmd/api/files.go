@@ -1,12 +1,17 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+
+	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/storage"
 )
 
 func safeFileName(name string, allowedExts []string) (string, error) {
@@ -28,7 +33,39 @@ func contentDisposition(fileName string) string {
 	return fmt.Sprintf(`attachment; filename="%s"`, safe)
 }
 
-func serveFile(app *application, w http.ResponseWriter, r *http.Request, dir string, allowedExts []string) {
+// fileDigest returns the SHA-256 recorded for a stored file at upload time, or
+// an empty string if there is none or the file changed since
+func (app *application) fileDigest(r *http.Request, kind storage.Kind, name string, size int64) string {
+	record, err := app.models.BookFiles.Get(string(kind), name)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.logError(r, err)
+		}
+		return ""
+	}
+
+	if record.Size != size {
+		return ""
+	}
+
+	return record.SHA256
+}
+
+// setDigestHeaders sends the checksum of a response as Repr-Digest, the older
+// Digest header and a strong ETag
+func setDigestHeaders(w http.ResponseWriter, sha256 string) {
+	digest, err := hex.DecodeString(sha256)
+	if err != nil || len(digest) == 0 {
+		return
+	}
+
+	b64 := base64.StdEncoding.EncodeToString(digest)
+	w.Header().Set("Repr-Digest", "sha-256=:"+b64+":")
+	w.Header().Set("Digest", "SHA-256="+b64)
+	w.Header().Set("ETag", `"`+sha256+`"`)
+}
+
+func serveFile(app *application, w http.ResponseWriter, r *http.Request, kind storage.Kind, allowedExts []string) {
 	rawName := r.URL.Query().Get("file")
 	if rawName == "" {
 		app.errorResponse(w, r, http.StatusBadRequest, "file parameter is required")
@@ -41,11 +78,9 @@ func serveFile(app *application, w http.ResponseWriter, r *http.Request, dir str
 		return
 	}
 
-	filePath := filepath.Join(dir, fileName)
-
-	file, err := os.Open(filePath)
+	file, fileInfo, err := app.storage.Get(kind, fileName)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, storage.ErrNotFound) {
 			app.notFoundResponse(w, r)
 		} else {
 			app.serverErrorResponse(w, r, err)
@@ -54,31 +89,23 @@ func serveFile(app *application, w http.ResponseWriter, r *http.Request, dir str
 	}
 	defer file.Close()
 
-	fileInfo, err := file.Stat()
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
+	setDigestHeaders(w, app.fileDigest(r, kind, fileName, fileInfo.Size))
 
 	w.Header().Set("Content-Disposition", contentDisposition(fileName))
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Length", strconv.FormatInt(fileInfo.Size(), 10))
-
-	http.ServeContent(w, r, fileName, fileInfo.ModTime(), file)
-}
+	w.Header().Set("Content-Length", strconv.FormatInt(fileInfo.Size, 10))
 
-func (app *application) serveImages(w http.ResponseWriter, r *http.Request) {
-	serveFile(app, w, r, "./uploads/covers", []string{".jpg", ".jpeg", ".png", ".gif", ".webp"})
+	http.ServeContent(w, r, fileName, fileInfo.ModTime, file)
 }
 
 func (app *application) servePdfs(w http.ResponseWriter, r *http.Request) {
-	serveFile(app, w, r, "./uploads/pdfs", []string{".pdf"})
+	serveFile(app, w, r, storage.KindPDF, []string{".pdf"})
 }
 
 func (app *application) serveEpubs(w http.ResponseWriter, r *http.Request) {
-	serveFile(app, w, r, "./uploads/epubs", []string{".epub"})
+	serveFile(app, w, r, storage.KindEPUB, []string{".epub"})
 }
 
 func (app *application) serveTorrents(w http.ResponseWriter, r *http.Request) {
-	serveFile(app, w, r, "./uploads/torrents", []string{".torrent"})
+	serveFile(app, w, r, storage.KindTorrent, []string{".torrent"})
 }
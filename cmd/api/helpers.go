@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -20,6 +22,10 @@ import (
 	"golang.org/x/text/transform"
 	"golang.org/x/text/unicode/norm"
 	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/metadata"
+	"qumran.jesarx.com/internal/storage"
+	"qumran.jesarx.com/internal/textract"
+	"qumran.jesarx.com/internal/torrent"
 	"qumran.jesarx.com/internal/validator"
 )
 
@@ -152,8 +158,8 @@ func (app *application) CleanString(str string) string {
 	return strings.ReplaceAll(result, " ", "_")
 }
 
-// sanitizeMetadataValue strips control characters to prevent argument injection
-// into external tools like exiftool.
+// sanitizeMetadataValue strips control characters, which would otherwise end up
+// in the PDF metadata or as arguments to exiftool.
 func sanitizeMetadataValue(s string) string {
 	return strings.Map(func(r rune) rune {
 		if r < 32 || r == 127 {
@@ -163,22 +169,37 @@ func sanitizeMetadataValue(s string) string {
 	}, s)
 }
 
-func (app *application) processFiles(w http.ResponseWriter, r *http.Request, pdfField string, imageField string, shortTitle string, authorID int64, publisherID int64) (map[string]string, error) {
-	// Define trackers for torrent files (can be modified as needed)
-	trackers := []string{
-		"udp://tracker.opentrackr.org:1337/announce",
-		"udp://open.demonii.com:1337/announce",
-		"udp://tracker.torrent.eu.org:451/announce",
-	}
+// bookFile is one stored file of a book. path is the local copy while the file
+// is being processed.
+type bookFile struct {
+	kind   storage.Kind
+	name   string
+	path   string
+	size   int64
+	sha256 string
+}
 
-	var fileData struct {
-		FileName     string
-		baseFilename string
-		ImagePath    string
-		PDFPath      string
-		PDFTorrPath  string
-	}
+// processedFiles holds the files processed for a new book. Nothing is stored
+// until storeFiles is called with them.
+type processedFiles struct {
+	filename string
+	infoHash string
+	hasPDF   bool
+	files    []bookFile
+	covers   []*data.Cover
+	// coverGenerated is set when the cover was rendered from the PDF
+	coverGenerated bool
+	// analysis is nil when there is no PDF or it could not be read
+	analysis *textract.Analysis
+	// body is the text of the PDF, for full-text search
+	body string
+}
 
+// processFiles cleans the metadata of the uploaded PDF and image, builds the
+// torrent and leaves everything in workDir. Without an image, the first page
+// of the PDF becomes the cover when a rasterizer is configured. It fails with
+// data.ErrDuplicateFilename if another book already owns the filename.
+func (app *application) processFiles(workDir string, pdfSrc string, imageSrc string, shortTitle string, authorID int64, publisherID int64) (*processedFiles, error) {
 	// Validate the author ID
 	if authorID < 1 {
 		return nil, errors.New("invalid author ID")
@@ -214,270 +235,428 @@ func (app *application) processFiles(w http.ResponseWriter, r *http.Request, pdf
 		baseFileName = fmt.Sprintf("%s-%s", app.CleanString(author.LastName), app.CleanString(shortTitle))
 	}
 
-	result := map[string]string{
-		"filename": baseFileName,
+	// Never overwrite the files of another book
+	err = app.checkFilename(baseFileName)
+	if err != nil {
+		return nil, err
 	}
 
-	// Define the target directories
-	pdfTargetDir := "./uploads/pdfs"
-	imageTargetDir := "./uploads/covers"
-	torrentTargetDir := "./uploads/torrents"
-	torrentAddedDir := "./uploads/torrentadded" // New directory for copied torrents
+	result := &processedFiles{filename: baseFileName}
 
-	// Create the directories if they don't exist
-	for _, dir := range []string{pdfTargetDir, imageTargetDir, torrentTargetDir, torrentAddedDir} {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
-		}
-	}
+	var renditions []bookFile
+	var pdfPath string
 
 	// PDF Processing (Optional)
-	pdfFile, pdfHeader, err := r.FormFile(pdfField)
-	if err == nil {
+	if pdfSrc != "" {
+		pdfFile, err := os.Open(pdfSrc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open PDF file: %w", err)
+		}
 		defer pdfFile.Close()
 
-		// Define PDF file details
-		pdfExtension := filepath.Ext(pdfHeader.Filename)
-		fileData.FileName = baseFileName + pdfExtension
-		fileData.PDFPath = filepath.Join(pdfTargetDir, fileData.FileName)
-		fileData.PDFTorrPath = filepath.Join(torrentTargetDir, baseFileName+".pdf.torrent")
+		pdfName := storage.Filename(storage.KindPDF, baseFileName)
+		torrentName := storage.Filename(storage.KindTorrent, baseFileName)
+		pdfPath = filepath.Join(workDir, pdfName)
+		torrentPath := filepath.Join(workDir, torrentName)
 
 		// Save the PDF file
-		pdfDst, err := os.Create(fileData.PDFPath)
+		err = saveFile(pdfFile, pdfPath)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create PDF file: %w", err)
+			return nil, fmt.Errorf("failed to save PDF file: %w", err)
 		}
-		defer pdfDst.Close()
 
-		_, err = io.Copy(pdfDst, pdfFile)
+		// Replace all metadata with the title, author and publisher
+		err = app.scrubber.ScrubPDF(pdfPath, metadata.PDFInfo{
+			Title:     sanitizeMetadataValue(shortTitle),
+			Author:    sanitizeMetadataValue(author.Name + " " + author.LastName),
+			Publisher: sanitizeMetadataValue(publisher.Name),
+		})
 		if err != nil {
-			return nil, fmt.Errorf("failed to save PDF file: %w", err)
+			return nil, fmt.Errorf("failed to scrub PDF metadata: %w", err)
 		}
 
-		// Run exiftool on the PDF file to remove all metadata
-		pdfExifCmd := exec.Command("exiftool",
-			"-overwrite_original",
-			"-all:all=", fileData.PDFPath)
-		pdfExifOutput, err := pdfExifCmd.CombinedOutput()
+		result.analysis, result.body = app.analyzePDF(pdfPath, baseFileName)
+
+		// Create torrent file for PDF
+		opts := app.config.torrent.BookOptions(app.config.baseURL, pdfName, shortTitle, author.Name, author.LastName)
+		pdfTorrent, err := app.createTorrent(pdfPath, torrentPath, opts)
 		if err != nil {
-			return nil, fmt.Errorf("failed to run exiftool on PDF: %w, output: %s", err, string(pdfExifOutput))
+			return nil, fmt.Errorf("failed to create PDF torrent: %w", err)
 		}
 
-		// Now add specific metadata to the PDF
-		safeTitle := sanitizeMetadataValue(shortTitle)
-		safeAuthor := sanitizeMetadataValue(author.Name + " " + author.LastName)
-		safePublisher := sanitizeMetadataValue(publisher.Name)
-		pdfMetadataCmd := exec.Command("exiftool",
-			"-overwrite_original",
-			"-charset", "exif=UTF8",
-			"-Title="+safeTitle,
-			"-Author="+safeAuthor,
-			"-Publisher="+safePublisher,
-			fileData.PDFPath)
-
-		pdfMetadataOutput, err := pdfMetadataCmd.CombinedOutput()
+		// The PDF and its torrent, plus the copy picked up by the torrent client
+		result.files = append(result.files,
+			bookFile{kind: storage.KindPDF, name: pdfName, path: pdfPath},
+			bookFile{kind: storage.KindTorrent, name: torrentName, path: torrentPath},
+			bookFile{kind: storage.KindTorrentAdded, name: torrentName, path: torrentPath},
+		)
+		result.infoHash = pdfTorrent.InfoHashHex()
+		result.hasPDF = true
+	}
+
+	// Render a cover from the first page when none was uploaded. A book
+	// without a cover is still better than no book, so failures are only
+	// logged.
+	if imageSrc == "" && pdfPath != "" && app.rasterizer != nil {
+		pagePath := filepath.Join(workDir, "page1.jpg")
+
+		err = app.rasterizer.FirstPage(pdfPath, pagePath)
 		if err != nil {
-			return nil, fmt.Errorf("failed to add metadata to PDF: %w, output: %s", err, string(pdfMetadataOutput))
+			app.logger.Error("failed to render cover from PDF", "filename", baseFileName, "error", err)
+		} else {
+			imageSrc = pagePath
+			result.coverGenerated = true
 		}
+	}
 
-		// Create torrent file for PDF using transmission-create
-		// Build the command with separate tracker arguments
-		args := []string{
-			"-o", fileData.PDFTorrPath,
-			"-c", sanitizeMetadataValue(fmt.Sprintf("%s by %s %s", shortTitle, author.Name, author.LastName)),
+	// Image Processing (Optional)
+	if imageSrc != "" {
+		imageFile, err := os.Open(imageSrc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open image file: %w", err)
 		}
+		defer imageFile.Close()
 
-		// Add each tracker individually
-		for _, tracker := range trackers {
-			args = append(args, "--tracker", tracker)
+		coverPath, err := app.processCover(imageFile, filepath.Ext(imageSrc), workDir, baseFileName)
+		if err != nil {
+			return nil, err
 		}
 
-		// Add the input file path as the last argument
-		args = append(args, fileData.PDFPath)
+		result.files = append(result.files, bookFile{kind: storage.KindCover, name: filepath.Base(coverPath), path: coverPath})
 
-		// Execute the command with all arguments
-		pdfTorrentCmd := exec.Command("transmission-create", args...)
-
-		pdfTorrentOutput, err := pdfTorrentCmd.CombinedOutput()
+		renditions, result.covers, err = app.coverRenditions(coverPath, workDir, baseFileName)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create PDF torrent: %w, output: %s", err, string(pdfTorrentOutput))
+			return nil, err
 		}
+	}
 
-		// Copy the torrent file to torrentadded directory
-		torrentAddedPath := filepath.Join(torrentAddedDir, baseFileName+".pdf.torrent")
-		err = copyFile(fileData.PDFTorrPath, torrentAddedPath)
+	for i := range result.files {
+		result.files[i].size, result.files[i].sha256, err = hashFile(result.files[i].path)
 		if err != nil {
-			return nil, fmt.Errorf("failed to copy torrent to torrentadded directory: %w", err)
+			return nil, err
 		}
+	}
 
-		// Add PDF-related files to result
-		result["pdf"] = fileData.PDFPath
-		result["pdf_torrent"] = fileData.PDFTorrPath
-		result["torrent_added"] = torrentAddedPath // Add the new path to the result
+	// The renditions come already hashed
+	result.files = append(result.files, renditions...)
+
+	return result, nil
+}
+
+// analyzePDF counts the pages of a PDF, detects its text layer and language
+// and extracts its text. The book can do without them, so failures are only
+// logged.
+func (app *application) analyzePDF(pdfPath string, filename string) (*textract.Analysis, string) {
+	analysis, err := textract.Analyze(app.extractor, pdfPath, app.config.text.samplePages)
+	if err != nil {
+		app.logger.Error("failed to analyze PDF", "filename", filename, "error", err)
 	}
 
-	// Image Processing (Optional)
-	imageFile, imageHeader, err := r.FormFile(imageField)
-	if err == nil {
-		defer imageFile.Close()
+	if analysis == nil || analysis.HasTextLayer == nil || !*analysis.HasTextLayer {
+		return analysis, ""
+	}
 
-		// Get the original image extension
-		origExt := strings.ToLower(filepath.Ext(imageHeader.Filename))
+	text, err := app.extractor.PDFText(pdfPath, 0)
+	if err != nil {
+		app.logger.Error("failed to extract PDF text", "filename", filename, "error", err)
+		return analysis, ""
+	}
 
-		// Define image file details - always using jpg as the final format
-		imageFileName := baseFileName + ".jpg"
-		fileData.ImagePath = filepath.Join(imageTargetDir, imageFileName)
+	return analysis, textract.CleanBody(text)
+}
 
-		// Create a temporary file for the original image if it's not already a JPG
-		var tempImagePath string
-		if origExt != ".jpg" && origExt != ".jpeg" {
-			tempFile, err := os.CreateTemp("", "image-*"+origExt)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create temp image file: %w", err)
-			}
-			tempImagePath = tempFile.Name()
-			defer os.Remove(tempImagePath) // Clean up the temp file when done
+// checkFilename returns data.ErrDuplicateFilename when a book record or a
+// stored PDF or cover already uses the filename
+func (app *application) checkFilename(filename string) error {
+	taken, err := app.models.Books.FilenameTaken(filename)
+	if err != nil {
+		return err
+	}
 
-			_, err = io.Copy(tempFile, imageFile)
-			if err != nil {
-				tempFile.Close()
-				return nil, fmt.Errorf("failed to save temp image file: %w", err)
-			}
-			tempFile.Close()
+	if taken {
+		return data.ErrDuplicateFilename
+	}
 
-			// Convert the image to JPG using ImageMagick
-			convertCmd := exec.Command("convert", tempImagePath, fileData.ImagePath)
-			convertOutput, err := convertCmd.CombinedOutput()
-			if err != nil {
-				return nil, fmt.Errorf("failed to convert image to JPG: %w, output: %s", err, string(convertOutput))
-			}
-		} else {
-			// Save the image file directly if it's already a JPG or JPEG
-			imageDst, err := os.Create(fileData.ImagePath)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create image file: %w", err)
-			}
-			defer imageDst.Close()
+	for _, kind := range []storage.Kind{storage.KindPDF, storage.KindCover} {
+		_, err := app.storage.Stat(kind, storage.Filename(kind, filename))
+		switch {
+		case err == nil:
+			return data.ErrDuplicateFilename
+		case !errors.Is(err, storage.ErrNotFound):
+			return err
+		}
+	}
+
+	return nil
+}
 
-			_, err = io.Copy(imageDst, imageFile)
+// storeFiles puts prepared files into the storage backend. If one of them
+// fails, the ones already stored are removed again.
+func (app *application) storeFiles(files []bookFile) error {
+	for i, file := range files {
+		err := app.putFile(file.kind, file.name, file.path)
+		if err != nil {
+			app.removeFiles(files[:i])
+			return fmt.Errorf("failed to store %s file: %w", file.kind, err)
+		}
+	}
+
+	return nil
+}
+
+// replaceFiles puts prepared files into the storage backend over the ones of
+// the same name, first copying those to workDir. The returned function undoes
+// it: it puts the copies back and removes the files that are new.
+func (app *application) replaceFiles(files []bookFile, workDir string) (func(), error) {
+	var previous, added []bookFile
+
+	restore := func() {
+		for _, file := range previous {
+			err := app.putFile(file.kind, file.name, file.path)
 			if err != nil {
-				return nil, fmt.Errorf("failed to save image file: %w", err)
+				app.logger.Error("error restoring file", "kind", file.kind, "name", file.name, "error", err)
 			}
 		}
 
-		// Run exiftool on the image file to remove all metadata
-		imageExifCmd := exec.Command("exiftool",
-			"-overwrite_original",
-			"-all:all=", fileData.ImagePath)
-		imageExifOutput, err := imageExifCmd.CombinedOutput()
+		app.removeFiles(added)
+	}
+
+	for i, file := range files {
+		backup := bookFile{kind: file.kind, name: file.name, path: filepath.Join(workDir, fmt.Sprintf("previous-%d", i))}
+
+		err := app.fetchFile(file.kind, file.name, backup.path)
+		switch {
+		case err == nil:
+			previous = append(previous, backup)
+		case errors.Is(err, storage.ErrNotFound):
+			added = append(added, file)
+		default:
+			restore()
+			return nil, fmt.Errorf("failed to keep the previous %s file: %w", file.kind, err)
+		}
+
+		err = app.putFile(file.kind, file.name, file.path)
 		if err != nil {
-			return nil, fmt.Errorf("failed to run exiftool on image: %w, output: %s", err, string(imageExifOutput))
+			restore()
+			return nil, fmt.Errorf("failed to store %s file: %w", file.kind, err)
 		}
+	}
+
+	return restore, nil
+}
 
-		// Add image-related file to result
-		result["image"] = fileData.ImagePath
+// removeFiles deletes stored files, logging the failures
+func (app *application) removeFiles(files []bookFile) {
+	for _, file := range files {
+		err := app.storage.Delete(file.kind, file.name)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			app.logger.Error("error deleting file", "kind", file.kind, "name", file.name, "error", err)
+		}
+	}
+}
+
+// processCoverEdit cleans the cover sent in imageField when editing a book
+// and renders its renditions, leaving everything in workDir. It returns nil
+// when no cover was sent. Nothing is stored until replaceFiles is called.
+func (app *application) processCoverEdit(r *http.Request, imageField string, workDir string, baseFileName string) (*processedFiles, error) {
+	imageFile, imageHeader, err := r.FormFile(imageField)
+	if err != nil {
+		return nil, nil
+	}
+	defer imageFile.Close()
+
+	coverPath, err := app.processCover(imageFile, filepath.Ext(imageHeader.Filename), workDir, baseFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	cover := bookFile{kind: storage.KindCover, name: filepath.Base(coverPath), path: coverPath}
+
+	cover.size, cover.sha256, err = hashFile(coverPath)
+	if err != nil {
+		return nil, err
+	}
+
+	renditions, covers, err := app.coverRenditions(coverPath, workDir, baseFileName)
+	if err != nil {
+		return nil, err
 	}
 
+	result := &processedFiles{filename: baseFileName, covers: covers}
+	result.files = append([]bookFile{cover}, renditions...)
+
 	return result, nil
 }
 
-func (app *application) processFilesEdit(w http.ResponseWriter, r *http.Request, imageField string, baseFileName string) (map[string]string, error) {
-	var fileData struct {
-		ImagePath string
+// processCover converts an image with the given original extension to JPG
+// inside workDir and strips its metadata. It returns the path of the cover.
+func (app *application) processCover(imageFile io.Reader, origExt string, workDir string, baseFileName string) (string, error) {
+	origExt = strings.ToLower(origExt)
+
+	// Define image file details - always using jpg as the final format
+	coverName := storage.Filename(storage.KindCover, baseFileName)
+	imagePath := filepath.Join(workDir, coverName)
+
+	if origExt != ".jpg" && origExt != ".jpeg" {
+		// Save the original image next to the target so convert can read it
+		origPath := filepath.Join(workDir, "original"+origExt)
+
+		err := saveFile(imageFile, origPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to save temp image file: %w", err)
+		}
+
+		// Convert the image to JPG using ImageMagick
+		convertCmd := exec.Command("convert", origPath, imagePath)
+		convertOutput, err := convertCmd.CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("failed to convert image to JPG: %w, output: %s", err, string(convertOutput))
+		}
+	} else {
+		// Save the image file directly if it's already a JPG or JPEG
+		err := saveFile(imageFile, imagePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to save image file: %w", err)
+		}
 	}
 
-	result := map[string]string{
-		"filename": baseFileName, // Use the existing filename
+	// Remove all metadata from the image
+	err := app.scrubber.ScrubImage(imagePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to scrub image metadata: %w", err)
 	}
 
-	// Define the target directory for images
-	imageTargetDir := "./uploads/covers"
+	return imagePath, nil
+}
 
-	// Create the directory if it doesn't exist
-	if err := os.MkdirAll(imageTargetDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create directory %s: %w", imageTargetDir, err)
+// createTorrent writes the .torrent for the file at path to torrentPath
+func (app *application) createTorrent(path string, torrentPath string, opts torrent.Options) (*torrent.Torrent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
+	defer file.Close()
 
-	// Image Processing (Optional)
-	imageFile, imageHeader, err := r.FormFile(imageField)
-	if err == nil {
-		defer imageFile.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
 
-		// Get the original image extension
-		origExt := strings.ToLower(filepath.Ext(imageHeader.Filename))
+	t, err := torrent.Create(file, fi.Size(), opts)
+	if err != nil {
+		return nil, err
+	}
 
-		// Define image file details - always using jpg as the final format
-		imageFileName := baseFileName + ".jpg"
-		fileData.ImagePath = filepath.Join(imageTargetDir, imageFileName)
+	err = os.WriteFile(torrentPath, t.Data, 0644)
+	if err != nil {
+		return nil, err
+	}
 
-		// Create a temporary file for the original image if it's not already a JPG
-		var tempImagePath string
-		if origExt != ".jpg" && origExt != ".jpeg" {
-			tempFile, err := os.CreateTemp("", "image-*"+origExt)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create temp image file: %w", err)
-			}
-			tempImagePath = tempFile.Name()
-			defer os.Remove(tempImagePath) // Clean up the temp file when done
+	return t, nil
+}
 
-			_, err = io.Copy(tempFile, imageFile)
-			if err != nil {
-				tempFile.Close()
-				return nil, fmt.Errorf("failed to save temp image file: %w", err)
-			}
-			tempFile.Close()
+// publicURL returns the address of an API path, absolute when the base URL is
+// configured
+func (app *application) publicURL(path string, qs url.Values) string {
+	if len(qs) > 0 {
+		path += "?" + qs.Encode()
+	}
+	return app.config.baseURL + path
+}
 
-			// Convert the image to JPG using ImageMagick
-			convertCmd := exec.Command("convert", tempImagePath, fileData.ImagePath)
-			convertOutput, err := convertCmd.CombinedOutput()
-			if err != nil {
-				return nil, fmt.Errorf("failed to convert image to JPG: %w, output: %s", err, string(convertOutput))
-			}
-		} else {
-			// Save the image file directly if it's already a JPG or JPEG
-			imageDst, err := os.Create(fileData.ImagePath)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create image file: %w", err)
-			}
-			defer imageDst.Close()
+// frontendURL returns the address of the frontend page of a book, author or
+// publisher (data.SitemapBooks, etc.), or an empty string when the frontend
+// base URL is not configured
+func (app *application) frontendURL(kind, slug string) string {
+	if app.config.frontend.baseURL == "" {
+		return ""
+	}
 
-			_, err = io.Copy(imageDst, imageFile)
-			if err != nil {
-				return nil, fmt.Errorf("failed to save image file: %w", err)
-			}
+	var path string
+	switch kind {
+	case data.SitemapBooks:
+		path = app.config.frontend.bookPath
+	case data.SitemapAuthors:
+		path = app.config.frontend.authorPath
+	case data.SitemapPublishers:
+		path = app.config.frontend.publisherPath
+	}
+
+	return app.config.frontend.baseURL + strings.ReplaceAll(path, "{slug}", url.PathEscape(slug))
+}
+
+// setMagnetURIs fills in the magnet link of every book with a known info hash
+func (app *application) setMagnetURIs(books ...*data.Book) {
+	for _, book := range books {
+		if book == nil || book.InfoHash == "" {
+			continue
 		}
 
-		// Run exiftool on the image file to remove all metadata
-		imageExifCmd := exec.Command("exiftool",
-			"-overwrite_original",
-			"-all:all=", fileData.ImagePath)
-		imageExifOutput, err := imageExifCmd.CombinedOutput()
-		if err != nil {
-			return nil, fmt.Errorf("failed to run exiftool on image: %w, output: %s", err, string(imageExifOutput))
+		name := storage.Filename(storage.KindPDF, book.Filename)
+
+		var webSeeds []string
+		if seed := torrent.WebSeed(app.config.baseURL, name); seed != "" {
+			webSeeds = append(webSeeds, seed)
 		}
 
-		// Add image-related file to result
-		result["image"] = fileData.ImagePath
+		book.MagnetURI = torrent.MagnetURI(book.InfoHash, name, app.config.torrent.Trackers, webSeeds)
 	}
+}
 
-	return result, nil
+// hashFile returns the size and hex encoded SHA-256 of a local file
+func hashFile(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return size, hex.EncodeToString(h.Sum(nil)), nil
 }
 
-// Helper function to copy a file from src to dst
-func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
+// record returns the database record of a processed file
+func (f bookFile) record() *data.BookFile {
+	return &data.BookFile{Kind: string(f.kind), Name: f.name, Size: f.size, SHA256: f.sha256}
+}
+
+// putFile copies a local file into the storage backend
+func (app *application) putFile(kind storage.Kind, name string, path string) error {
+	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
-	defer sourceFile.Close()
+	defer file.Close()
 
+	return app.storage.Put(kind, name, file)
+}
+
+// fetchFile copies an object from the storage backend to a local file
+func (app *application) fetchFile(kind storage.Kind, name string, path string) error {
+	obj, _, err := app.storage.Get(kind, name)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	return saveFile(obj, path)
+}
+
+// Helper function to write the contents of src to a new file at dst
+func saveFile(src io.Reader, dst string) error {
 	destFile, err := os.Create(dst)
 	if err != nil {
 		return err
 	}
 	defer destFile.Close()
 
-	_, err = io.Copy(destFile, sourceFile)
+	_, err = io.Copy(destFile, src)
 	if err != nil {
 		return err
 	}
@@ -505,6 +684,24 @@ func (app *application) readCSV(qs url.Values, key string, defaultValue []string
 	return strings.Split(csv, ",")
 }
 
+// readBool returns nil when the key is absent, so filters can tell "false"
+// from "any"
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+
+	return &b
+}
+
 func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
 	s := qs.Get(key)
 
@@ -521,6 +718,65 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readPagination reads the page options of a listing besides page and
+// page_size: cursor, which switches to cursor pagination even when empty, seed
+// and count=false
+func (app *application) readPagination(qs url.Values, f *data.Filters, v *validator.Validator) {
+	f.UseCursor = qs.Has("cursor")
+	f.Cursor = qs.Get("cursor")
+	f.Seed = int64(app.readInt(qs, "seed", 0, v))
+
+	if count := app.readBool(qs, "count", v); count != nil {
+		f.SkipCount = !*count
+	}
+}
+
+// readYearRanges reads a comma-separated list of years ("1967") and ranges
+// ("1950-1959"), either end of which may be left open ("-1900", "2000-")
+func (app *application) readYearRanges(qs url.Values, key string, v *validator.Validator) []data.YearRange {
+	csv := qs.Get(key)
+
+	if csv == "" {
+		return nil
+	}
+
+	ranges := []data.YearRange{}
+
+	// Open ends are bounded by years no book has
+	parse := func(s string, open int32) (int32, bool) {
+		if s == "" {
+			return open, true
+		}
+
+		year, err := strconv.ParseInt(s, 10, 32)
+		return int32(year), err == nil
+	}
+
+	for _, s := range strings.Split(csv, ",") {
+		s = strings.TrimSpace(s)
+
+		from, to, isRange := strings.Cut(s, "-")
+		if !isRange {
+			to = from
+		}
+
+		var r data.YearRange
+		var okFrom, okTo bool
+
+		r.From, okFrom = parse(from, 0)
+		r.To, okTo = parse(to, 9999)
+
+		if !okFrom || !okTo || s == "" || s == "-" || r.From > r.To {
+			v.AddError(key, "must be a list of years or year ranges")
+			return nil
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	return ranges
+}
+
 func (app *application) backgound(fn func()) {
 	app.wg.Add(1)
 	go func() {
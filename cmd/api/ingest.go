@@ -0,0 +1,335 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/ebook"
+	"qumran.jesarx.com/internal/storage"
+	"qumran.jesarx.com/internal/textract"
+	"qumran.jesarx.com/internal/validator"
+)
+
+// ingestPayload is the job payload of a book upload. Files are referenced by
+// their name inside the staging directory.
+type ingestPayload struct {
+	Book  bookInput `json:"book"`
+	PDF   string    `json:"pdf,omitempty"`
+	Image string    `json:"image,omitempty"`
+}
+
+// stageUpload saves the file sent in a multipart field to the staging
+// directory and returns its staged name, or an empty string when the field was
+// not sent.
+func (app *application) stageUpload(r *http.Request, field string) (string, error) {
+	file, header, err := r.FormFile(field)
+	if err != nil {
+		if errors.Is(err, http.ErrMissingFile) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer file.Close()
+
+	name, err := stagedName(filepath.Ext(header.Filename))
+	if err != nil {
+		return "", err
+	}
+
+	err = saveFile(file, app.stagedPath(name))
+	if err != nil {
+		os.Remove(app.stagedPath(name))
+		return "", fmt.Errorf("failed to stage %s file: %w", field, err)
+	}
+
+	return name, nil
+}
+
+// stagedName returns a new random name for a staged file
+func stagedName(ext string) (string, error) {
+	name, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+
+	return name + strings.ToLower(ext), nil
+}
+
+func randomHex(n int) (string, error) {
+	randomBytes := make([]byte, n)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(randomBytes), nil
+}
+
+func (app *application) stagedPath(name string) string {
+	if name == "" {
+		return ""
+	}
+
+	return filepath.Join(app.config.jobs.stagingDir, filepath.Base(name))
+}
+
+func (app *application) removeStaged(names ...string) {
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+
+		err := os.Remove(app.stagedPath(name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			app.logger.Error("failed to remove staged file", "name", name, "error", err)
+		}
+	}
+}
+
+func (app *application) ingestBookJob(job *data.Job, progress jobProgress) (any, error) {
+	var payload ingestPayload
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return nil, permanent(fmt.Errorf("invalid job payload: %w", err))
+	}
+
+	input := payload.Book
+
+	// Check the book before doing any work on its files
+	v := validator.New()
+
+	if data.ValidateBook(v, input.book("")); !v.Valid() {
+		return nil, permanent(fmt.Errorf("validation failed: %v", v.Errors))
+	}
+
+	// Refuse a PDF that was already uploaded for another book
+	var sourceHash string
+	if payload.PDF != "" {
+		_, sourceHash, err = hashFile(app.stagedPath(payload.PDF))
+		if err != nil {
+			return nil, err
+		}
+
+		existing, err := app.models.BookFiles.GetBookByPDFHash(sourceHash)
+		switch {
+		case err == nil:
+			return nil, permanent(fmt.Errorf("the PDF was already uploaded for book %s", existing.Slug))
+		case !errors.Is(err, data.ErrRecordNotFound):
+			return nil, err
+		}
+	}
+
+	workDir, err := os.MkdirTemp("", "qumran-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	progress(10, "processing files")
+
+	files, err := app.processFiles(workDir, app.stagedPath(payload.PDF), app.stagedPath(payload.Image), input.ShortTitle, input.AuthorID, input.PublisherID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, permanent(errors.New("author or publisher not found"))
+		case errors.Is(err, data.ErrDuplicateFilename):
+			return nil, permanent(errors.New("a book with the same author and short title already exists"))
+		default:
+			return nil, err
+		}
+	}
+
+	progress(80, "saving book")
+
+	book := input.book(files.filename)
+	book.InfoHash = files.infoHash
+
+	if files.hasPDF {
+		book.Formats = []string{data.FormatPDF}
+	}
+
+	book.Covers = files.covers
+	book.CoverGenerated = files.coverGenerated
+	book.Body = files.body
+
+	if files.analysis != nil {
+		book.PagesDetected = int32(files.analysis.Pages)
+		book.HasTextLayer = files.analysis.HasTextLayer
+		book.Language = files.analysis.Language
+
+		if book.Pages == 0 {
+			book.Pages = book.PagesDetected
+		}
+	}
+
+	for _, file := range files.files {
+		if file.kind == storage.KindRendition {
+			continue
+		}
+
+		record := file.record()
+		if file.kind == storage.KindPDF {
+			record.SourceSHA256 = sourceHash
+		}
+		book.Files = append(book.Files, record)
+	}
+
+	// The files are stored before the record so the transaction stays short,
+	// and removed again if the record can't be inserted
+	err = app.storeFiles(files.files)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Books.Insert(book)
+	if err != nil {
+		app.removeFiles(files.files)
+
+		switch {
+		case errors.Is(err, data.ErrDuplicateFilename):
+			return nil, permanent(errors.New("a book with the same author and short title already exists"))
+		case errors.Is(err, data.ErrDuplicateFile):
+			return nil, permanent(errors.New("the PDF was already uploaded for another book"))
+		default:
+			return nil, err
+		}
+	}
+
+	completeBook, err := app.models.Books.GetByID(book.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	app.cleanupIngestJob(job)
+
+	result := map[string]any{"book_id": completeBook.ID, "slug": completeBook.Slug}
+
+	// The EPUB is converted by its own job so a slow or failing conversion
+	// doesn't hold back the book
+	if app.converter != nil && files.hasPDF {
+		epubJob, err := app.enqueueJob(data.JobKindBookEPUB, epubPayload{BookID: completeBook.ID}, job.UserID)
+		if err != nil {
+			app.logger.Error("failed to schedule EPUB conversion", "book_id", completeBook.ID, "error", err)
+		} else {
+			result["epub_job_id"] = epubJob.ID
+		}
+	}
+
+	return result, nil
+}
+
+type epubPayload struct {
+	BookID int64 `json:"book_id"`
+}
+
+// epubBookJob converts the stored PDF of a book to EPUB, writing the book
+// metadata into it, and records the new format.
+func (app *application) epubBookJob(job *data.Job, progress jobProgress) (any, error) {
+	var payload epubPayload
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return nil, permanent(fmt.Errorf("invalid job payload: %w", err))
+	}
+
+	book, err := app.models.Books.GetByID(payload.BookID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, permanent(err)
+		}
+		return nil, err
+	}
+
+	workDir, err := os.MkdirTemp("", "qumran-epub-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	progress(10, "fetching pdf")
+
+	pdfName := storage.Filename(storage.KindPDF, book.Filename)
+	pdfPath := filepath.Join(workDir, pdfName)
+
+	err = app.fetchFile(storage.KindPDF, pdfName, pdfPath)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, permanent(fmt.Errorf("pdf %s is missing", pdfName))
+		}
+		return nil, err
+	}
+
+	progress(20, "converting to epub")
+
+	epubName := storage.Filename(storage.KindEPUB, book.Filename)
+	epubPath := filepath.Join(workDir, epubName)
+
+	err = app.converter.ToEPUB(pdfPath, epubPath, ebook.Metadata{
+		Title:     sanitizeMetadataValue(book.ShortTitle),
+		Authors:   sanitizeMetadataValue(strings.TrimSpace(book.AuthorName + " " + book.AuthorLastName)),
+		Publisher: sanitizeMetadataValue(book.PublisherName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	progress(90, "storing epub")
+
+	epub := bookFile{kind: storage.KindEPUB, name: epubName, path: epubPath}
+
+	epub.size, epub.sha256, err = hashFile(epubPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.putFile(epub.kind, epub.name, epub.path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.BookFiles.Upsert(book.ID, epub.record())
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Books.AddFormat(book.ID, data.FormatEPUB)
+	if err != nil {
+		return nil, err
+	}
+
+	// Scans without a text layer may still have text in the EPUB. An
+	// existing text from the PDF is kept.
+	text, err := textract.EPUBText(epubPath)
+	if err != nil {
+		app.logger.Error("failed to extract EPUB text", "book_id", book.ID, "error", err)
+	} else if body := textract.CleanBody(text); body != "" {
+		err = app.models.BookTexts.Set(book.ID, body, false)
+		if err != nil {
+			app.logger.Error("failed to store EPUB text", "book_id", book.ID, "error", err)
+		}
+	}
+
+	return map[string]any{"book_id": book.ID, "epub": epubName}, nil
+}
+
+// cleanupIngestJob removes the staged uploads of a book ingestion job
+func (app *application) cleanupIngestJob(job *data.Job) {
+	var payload ingestPayload
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return
+	}
+
+	app.removeStaged(payload.PDF, payload.Image)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"qumran.jesarx.com/internal/data"
+)
+
+// permanentError marks a job failure that retrying cannot fix, such as a
+// validation error.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+func permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// jobProgress reports how far a running job has got
+type jobProgress func(progress int, stage string)
+
+func (app *application) enqueueJob(kind string, payload any, userID *int64) (*data.Job, error) {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &data.Job{
+		Kind:        kind,
+		Payload:     js,
+		MaxAttempts: app.config.jobs.maxAttempts,
+		UserID:      userID,
+	}
+
+	err = app.models.Jobs.Insert(job)
+	if err != nil {
+		return nil, err
+	}
+
+	// Wake up an idle worker instead of waiting for the next poll
+	select {
+	case app.jobsReady <- struct{}{}:
+	default:
+	}
+
+	return job, nil
+}
+
+// startWorkers launches the job workers as background tasks, so the shutdown
+// logic waits for them to finish their current job. Another task requeues the
+// jobs whose lease ran out, at startup and then once per lease.
+func (app *application) startWorkers() {
+	app.requeueInterrupted()
+
+	app.backgound(func() {
+		ticker := time.NewTicker(app.config.jobs.lease)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.quit:
+				return
+			case <-ticker.C:
+			}
+
+			app.requeueInterrupted()
+		}
+	})
+
+	for i := 0; i < app.config.jobs.workers; i++ {
+		app.backgound(app.worker)
+	}
+
+	app.logger.Info("started job workers", "workers", app.config.jobs.workers)
+}
+
+func (app *application) requeueInterrupted() {
+	n, err := app.models.Jobs.RequeueInterrupted(app.config.jobs.lease)
+	if err != nil {
+		app.logger.Error("failed to requeue interrupted jobs", "error", err)
+	} else if n > 0 {
+		app.logger.Info("requeued interrupted jobs", "count", n)
+	}
+}
+
+func (app *application) worker() {
+	for {
+		select {
+		case <-app.quit:
+			return
+		default:
+		}
+
+		job, err := app.models.Jobs.Claim()
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				app.logger.Error("failed to claim job", "error", err)
+			}
+
+			select {
+			case <-app.quit:
+				return
+			case <-app.jobsReady:
+			case <-time.After(app.config.jobs.pollInterval):
+			}
+			continue
+		}
+
+		app.runJob(job)
+	}
+}
+
+// runJob executes a claimed job and records its outcome. If the lease ran out
+// meanwhile, the job may be running again elsewhere, so the outcome is dropped
+// rather than overwrite that attempt's.
+func (app *application) runJob(job *data.Job) {
+	logger := app.logger.With("job_id", job.ID, "kind", job.Kind, "attempt", job.Attempts)
+	logger.Info("running job")
+
+	progress := func(progress int, stage string) {
+		err := app.models.Jobs.UpdateProgress(job, progress, stage)
+		if err != nil && !errors.Is(err, data.ErrLeaseLost) {
+			logger.Error("failed to update job progress", "error", err)
+		}
+	}
+
+	// Renew the lease while the job runs, so no other process takes it over
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(app.config.jobs.lease / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			err := app.models.Jobs.Heartbeat(job)
+			switch {
+			case errors.Is(err, data.ErrLeaseLost):
+				logger.Warn("job lease lost")
+				return
+			case err != nil:
+				logger.Error("failed to renew job lease", "error", err)
+			}
+		}
+	}()
+
+	result, err := app.executeJob(job, progress)
+	close(done)
+	if err == nil {
+		err = app.models.Jobs.Complete(job, result)
+		switch {
+		case errors.Is(err, data.ErrLeaseLost):
+			logger.Warn("job succeeded after its lease was lost, dropping the result")
+		case err != nil:
+			logger.Error("failed to complete job", "error", err)
+		default:
+			logger.Info("job succeeded")
+		}
+		return
+	}
+
+	var permanentErr permanentError
+	if errors.As(err, &permanentErr) || job.Attempts >= job.MaxAttempts {
+		logger.Error("job failed", "error", err)
+
+		err := app.models.Jobs.Fail(job, err.Error())
+		switch {
+		case errors.Is(err, data.ErrLeaseLost):
+			logger.Warn("job failed after its lease was lost, dropping the failure")
+			return
+		case err != nil:
+			logger.Error("failed to mark job as failed", "error", err)
+		}
+
+		app.cleanupJob(job)
+		return
+	}
+
+	runAt := time.Now().Add(jobBackoff(job.Attempts))
+	logger.Warn("job failed, retrying", "error", err, "run_at", runAt)
+
+	err = app.models.Jobs.Retry(job, err.Error(), runAt)
+	switch {
+	case errors.Is(err, data.ErrLeaseLost):
+		logger.Warn("job lease was lost, leaving the retry to its new attempt")
+	case err != nil:
+		logger.Error("failed to reschedule job", "error", err)
+	}
+}
+
+// executeJob dispatches a job to its handler, turning panics into errors so a
+// bad job can't take down a worker.
+func (app *application) executeJob(job *data.Job, progress jobProgress) (result any, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("panic: %v", rec)
+		}
+	}()
+
+	switch job.Kind {
+	case data.JobKindBookIngest:
+		return app.ingestBookJob(job, progress)
+	case data.JobKindBookEPUB:
+		if app.converter == nil {
+			return nil, permanent(errors.New("EPUB conversion is disabled"))
+		}
+		return app.epubBookJob(job, progress)
+	default:
+		return nil, permanent(fmt.Errorf("unknown job kind %q", job.Kind))
+	}
+}
+
+// cleanupJob releases whatever a job left behind once it will not run again
+func (app *application) cleanupJob(job *data.Job) {
+	switch job.Kind {
+	case data.JobKindBookIngest:
+		app.cleanupIngestJob(job)
+	}
+}
+
+// jobBackoff returns the delay before the next attempt: 30s doubling on every
+// attempt, capped at one hour.
+func jobBackoff(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+
+	return min(delay, time.Hour)
+}
+
+func (app *application) showJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	job, err := app.models.Jobs.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Only the user who queued a job, or one allowed to read every job, can
+	// see it
+	user := app.contextGetUser(r)
+
+	if job.UserID == nil || *job.UserID != user.ID {
+		allowed, err := app.hasPermission(r, "jobs:read")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !allowed {
+			app.notFoundResponse(w, r)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"job": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/imaging"
+	"qumran.jesarx.com/internal/storage"
+)
+
+// wantsJSONLD reports whether the book is asked for as JSON-LD, with
+// format=jsonld or, when format isn't set, the Accept header
+func wantsJSONLD(r *http.Request, format string) bool {
+	if format != "" {
+		return format == "jsonld"
+	}
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		params := strings.Split(accepted, ";")
+		if strings.TrimSpace(params[0]) != "application/ld+json" {
+			continue
+		}
+
+		// application/ld+json;q=0 means it is not acceptable
+		for _, param := range params[1:] {
+			if q, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+					return false
+				}
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// writeJSONLD sends a schema.org document. Unlike writeJSON it is not wrapped
+// in an envelope, so search engines can read it as is.
+func (app *application) writeJSONLD(w http.ResponseWriter, status int, doc map[string]any) error {
+	js, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	js = append(js, '\n')
+
+	w.Header().Set("Content-Type", "application/ld+json")
+	w.WriteHeader(status)
+	w.Write(js)
+
+	return nil
+}
+
+// bookJSONLD describes a book as a schema.org Book, with its authors as
+// Person and its publisher as Organization. Pages are linked in the frontend
+// when its base URL is configured.
+func (app *application) bookJSONLD(book *data.Book) map[string]any {
+	authors := []map[string]any{app.personJSONLD(authorOf(book, book.AuthorSlug), book.AuthorSlug)}
+	if book.Author2Slug != nil {
+		authors = append(authors, app.personJSONLD(authorOf(book, *book.Author2Slug), *book.Author2Slug))
+	}
+
+	doc := map[string]any{
+		"@context":            "https://schema.org",
+		"@type":               "Book",
+		"name":                book.Title,
+		"bookFormat":          "https://schema.org/EBook",
+		"isAccessibleForFree": true,
+		"datePublished":       strconv.Itoa(int(book.Year)),
+		"dateModified":        book.UpdatedAt.UTC().Format(time.RFC3339),
+		"author":              authors,
+		"publisher":           app.organizationJSONLD(book.PublisherName, book.PublisherSlug),
+	}
+
+	if page := app.frontendURL(data.SitemapBooks, book.Slug); page != "" {
+		doc["@id"] = page
+		doc["url"] = page
+	}
+
+	if book.ShortTitle != "" && book.ShortTitle != book.Title {
+		doc["alternativeHeadline"] = book.ShortTitle
+	}
+	if book.Description != "" {
+		doc["description"] = book.Description
+	}
+	if book.ISBN != "" {
+		doc["isbn"] = book.ISBN
+	}
+	if book.Pages > 0 {
+		doc["numberOfPages"] = book.Pages
+	}
+	if book.Language != "" {
+		doc["inLanguage"] = book.Language
+	}
+	if len(book.Tags) > 0 {
+		doc["keywords"] = strings.Join(book.Tags, ", ")
+	}
+
+	var images []string
+	for _, cover := range book.Covers {
+		if cover.Format == imaging.JPEG {
+			images = append(images, cover.URL)
+		}
+	}
+	if len(images) > 0 {
+		doc["image"] = images
+	}
+
+	// The files are only offered when the book's direct download is on
+	// (dir_dwl)
+	var encodings []map[string]any
+	if book.DirDwl {
+		for _, file := range book.Files {
+			var path, contentType string
+			switch storage.Kind(file.Kind) {
+			case storage.KindPDF:
+				path, contentType = "/v1/pdfs", "application/pdf"
+			case storage.KindEPUB:
+				path, contentType = "/v1/epubs", "application/epub+zip"
+			default:
+				continue
+			}
+
+			encodings = append(encodings, map[string]any{
+				"@type":          "MediaObject",
+				"contentUrl":     app.publicURL(path, url.Values{"file": {file.Name}}),
+				"encodingFormat": contentType,
+				"contentSize":    strconv.FormatInt(file.Size, 10),
+			})
+		}
+	}
+
+	if len(encodings) > 0 {
+		doc["encoding"] = encodings
+	}
+
+	return doc
+}
+
+func (app *application) personJSONLD(name, slug string) map[string]any {
+	person := map[string]any{"@type": "Person", "name": name}
+	if page := app.frontendURL(data.SitemapAuthors, slug); page != "" {
+		person["url"] = page
+	}
+	return person
+}
+
+func (app *application) organizationJSONLD(name, slug string) map[string]any {
+	organization := map[string]any{"@type": "Organization", "name": name}
+	if page := app.frontendURL(data.SitemapPublishers, slug); page != "" {
+		organization["url"] = page
+	}
+	return organization
+}
@@ -9,12 +9,20 @@ import (
 	"log/slog"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/ebook"
+	"qumran.jesarx.com/internal/imaging"
 	"qumran.jesarx.com/internal/mailer"
+	"qumran.jesarx.com/internal/metadata"
+	"qumran.jesarx.com/internal/settings"
+	"qumran.jesarx.com/internal/storage"
+	"qumran.jesarx.com/internal/textract"
+	"qumran.jesarx.com/internal/torrent"
 
 	_ "github.com/lib/pq"
 	"github.com/spf13/viper"
@@ -46,14 +54,78 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	auth struct {
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+	}
+	baseURL  string
+	frontend struct {
+		baseURL       string
+		bookPath      string
+		authorPath    string
+		publisherPath string
+	}
+	torrent torrent.Config
+	epub    struct {
+		enabled     bool
+		convertPath string
+		metaPath    string
+		timeout     time.Duration
+	}
+	jobs struct {
+		workers      int
+		pollInterval time.Duration
+		maxAttempts  int
+		lease        time.Duration
+		stagingDir   string
+	}
+	storage struct {
+		driver string
+		root   string
+		s3     storage.S3Config
+	}
+	covers struct {
+		widths          []int
+		formats         []string
+		generate        bool
+		rasterizerPath  string
+		rasterizerWidth int
+	}
+	text struct {
+		pdftotextPath string
+		samplePages   int
+	}
+	metadata struct {
+		driver       string
+		exiftoolPath string
+		fallback     bool
+	}
+	uploads struct {
+		maxSize       int64
+		expiry        time.Duration
+		chunkTimeout  time.Duration
+		sweepInterval time.Duration
+	}
 }
 
 type application struct {
-	logger *slog.Logger
-	config config
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	logger  *slog.Logger
+	config  config
+	models  data.Models
+	mailer  mailer.Mailer
+	storage storage.Storage
+	// converter is nil when EPUB generation is disabled
+	converter ebook.Converter
+	scrubber  metadata.Scrubber
+	// rasterizer is nil when covers are not generated from PDFs
+	rasterizer imaging.Rasterizer
+	extractor  textract.Extractor
+	wg         sync.WaitGroup
+	// quit is closed on shutdown to stop the job workers
+	quit      chan struct{}
+	jobsReady chan struct{}
+	// uploadLocks holds a *sync.Mutex per resumable upload being written
+	uploadLocks sync.Map
 }
 
 func main() {
@@ -64,11 +136,32 @@ func main() {
 		panic(fmt.Errorf("yaml config file not found: %s ", err))
 	}
 
+	settings.SetDefaults()
+	viper.SetDefault("storage.staging", "./uploads/staging")
+	viper.SetDefault("covers.generate", true)
+	viper.SetDefault("covers.rasterizer_path", "pdftoppm")
+	viper.SetDefault("covers.rasterizer_width", 1200)
+	viper.SetDefault("metadata.driver", metadata.DriverGo)
+	viper.SetDefault("metadata.exiftool_path", "exiftool")
+	viper.SetDefault("metadata.fallback", true)
+	viper.SetDefault("epub.enabled", true)
+	viper.SetDefault("epub.convert_path", "ebook-convert")
+	viper.SetDefault("epub.meta_path", "ebook-meta")
+	viper.SetDefault("frontend.book_path", "/libros/{slug}")
+	viper.SetDefault("frontend.author_path", "/autores/{slug}")
+	viper.SetDefault("frontend.publisher_path", "/editoriales/{slug}")
+
 	var cfg config
 
 	// MAIN CONFIG
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
 	flag.StringVar(&cfg.env, "env", "development", "Enviroment (development|staging|production)")
+	flag.StringVar(&cfg.baseURL, "base-url", viper.GetString("api.base_url"), "Public base URL of the API (e.g. https://api.pirateca.com)")
+
+	flag.StringVar(&cfg.frontend.baseURL, "frontend-base-url", viper.GetString("frontend.base_url"), "Public base URL of the frontend, used in sitemaps and JSON-LD (e.g. https://pirateca.com)")
+	flag.StringVar(&cfg.frontend.bookPath, "frontend-book-path", viper.GetString("frontend.book_path"), "Path of a book page in the frontend; {slug} is replaced by the book's slug")
+	flag.StringVar(&cfg.frontend.authorPath, "frontend-author-path", viper.GetString("frontend.author_path"), "Path of an author page in the frontend")
+	flag.StringVar(&cfg.frontend.publisherPath, "frontend-publisher-path", viper.GetString("frontend.publisher_path"), "Path of a publisher page in the frontend")
 
 	flag.StringVar(&cfg.db.dsn, "db-dsn", viper.GetString("database.dsn"), "PostgreSQL DSN")
 
@@ -86,6 +179,71 @@ func main() {
 	flag.StringVar(&cfg.smtp.password, "smtp-password", viper.GetString("smtp.password"), "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", viper.GetString("smtp.sender"), "SMTP sender")
 
+	flag.StringVar(&cfg.storage.driver, "storage-driver", viper.GetString("storage.driver"), "Storage backend (local|s3)")
+	flag.StringVar(&cfg.storage.root, "storage-root", viper.GetString("storage.root"), "Root directory for the local storage backend")
+	flag.StringVar(&cfg.storage.s3.Endpoint, "s3-endpoint", viper.GetString("storage.s3.endpoint"), "S3 endpoint URL")
+	flag.StringVar(&cfg.storage.s3.Region, "s3-region", viper.GetString("storage.s3.region"), "S3 region")
+	flag.StringVar(&cfg.storage.s3.Bucket, "s3-bucket", viper.GetString("storage.s3.bucket"), "S3 bucket")
+	flag.StringVar(&cfg.storage.s3.AccessKey, "s3-access-key", viper.GetString("storage.s3.access_key"), "S3 access key")
+	flag.StringVar(&cfg.storage.s3.SecretKey, "s3-secret-key", viper.GetString("storage.s3.secret_key"), "S3 secret key")
+	flag.StringVar(&cfg.storage.s3.Prefix, "s3-prefix", viper.GetString("storage.s3.prefix"), "S3 key prefix")
+	flag.BoolVar(&cfg.storage.s3.PathStyle, "s3-path-style", viper.GetBool("storage.s3.path_style"), "Use path-style S3 addressing (MinIO)")
+
+	cfg.torrent.Trackers = viper.GetStringSlice("torrent.trackers")
+	flag.Func("torrent-trackers", "Torrent announce URLs (space separated)", func(val string) error {
+		cfg.torrent.Trackers = strings.Fields(val)
+		return nil
+	})
+	flag.Int64Var(&cfg.torrent.PieceLength, "torrent-piece-length", viper.GetInt64("torrent.piece_length"), "Torrent piece length in bytes (0 picks one from the file size)")
+	flag.BoolVar(&cfg.torrent.Hybrid, "torrent-hybrid", viper.GetBool("torrent.hybrid"), "Generate hybrid BitTorrent v1/v2 torrents")
+
+	cfg.covers.widths = viper.GetIntSlice("covers.widths")
+	flag.Func("cover-widths", "Widths of the cover renditions in pixels (space separated)", func(val string) error {
+		cfg.covers.widths = nil
+		for _, field := range strings.Fields(val) {
+			width, err := strconv.Atoi(field)
+			if err != nil || width < 1 {
+				return fmt.Errorf("invalid width %q", field)
+			}
+			cfg.covers.widths = append(cfg.covers.widths, width)
+		}
+		return nil
+	})
+	cfg.covers.formats = viper.GetStringSlice("covers.formats")
+	flag.Func("cover-formats", "Formats of the cover renditions, jpeg and/or webp (space separated)", func(val string) error {
+		cfg.covers.formats = strings.Fields(val)
+		return nil
+	})
+
+	flag.BoolVar(&cfg.covers.generate, "cover-generate", viper.GetBool("covers.generate"), "Render the first PDF page as cover when none is uploaded")
+	flag.StringVar(&cfg.covers.rasterizerPath, "cover-rasterizer-path", viper.GetString("covers.rasterizer_path"), "Path to poppler's pdftoppm")
+	flag.IntVar(&cfg.covers.rasterizerWidth, "cover-rasterizer-width", viper.GetInt("covers.rasterizer_width"), "Width in pixels of covers rendered from PDFs")
+
+	flag.StringVar(&cfg.text.pdftotextPath, "text-pdftotext-path", viper.GetString("text.pdftotext_path"), "Path to poppler's pdftotext")
+	flag.IntVar(&cfg.text.samplePages, "text-sample-pages", viper.GetInt("text.sample_pages"), "Pages read to detect the text layer and language of a PDF")
+
+	flag.StringVar(&cfg.metadata.driver, "metadata-driver", viper.GetString("metadata.driver"), "Metadata scrubber (go|exiftool)")
+	flag.StringVar(&cfg.metadata.exiftoolPath, "metadata-exiftool-path", viper.GetString("metadata.exiftool_path"), "Path to exiftool")
+	flag.BoolVar(&cfg.metadata.fallback, "metadata-fallback", viper.GetBool("metadata.fallback"), "Use exiftool for files the go driver cannot handle")
+
+	flag.BoolVar(&cfg.epub.enabled, "epub-enabled", viper.GetBool("epub.enabled"), "Convert uploaded PDFs to EPUB")
+	flag.StringVar(&cfg.epub.convertPath, "epub-convert-path", viper.GetString("epub.convert_path"), "Path to Calibre's ebook-convert")
+	flag.StringVar(&cfg.epub.metaPath, "epub-meta-path", viper.GetString("epub.meta_path"), "Path to Calibre's ebook-meta")
+	flag.DurationVar(&cfg.epub.timeout, "epub-timeout", 15*time.Minute, "Maximum time for a single EPUB conversion")
+
+	flag.IntVar(&cfg.jobs.workers, "jobs-workers", 2, "Number of background job workers")
+	flag.DurationVar(&cfg.jobs.pollInterval, "jobs-poll-interval", 5*time.Second, "Interval between job queue polls when idle")
+	flag.IntVar(&cfg.jobs.maxAttempts, "jobs-max-attempts", 5, "Maximum attempts before a job is marked as failed")
+	flag.DurationVar(&cfg.jobs.lease, "jobs-lease", 5*time.Minute, "Time after which a running job that stopped reporting is requeued")
+	flag.Int64Var(&cfg.uploads.maxSize, "uploads-max-size", 1<<30, "Maximum size in bytes of a resumable upload")
+	flag.DurationVar(&cfg.uploads.expiry, "uploads-expiry", 24*time.Hour, "Time an unfinished resumable upload is kept after its last chunk")
+	flag.DurationVar(&cfg.uploads.chunkTimeout, "uploads-chunk-timeout", 15*time.Minute, "Maximum time to receive a single upload chunk")
+	flag.DurationVar(&cfg.uploads.sweepInterval, "uploads-sweep-interval", 10*time.Minute, "Interval between removals of expired uploads")
+	flag.StringVar(&cfg.jobs.stagingDir, "staging-dir", viper.GetString("storage.staging"), "Local directory for uploads waiting to be processed")
+
+	flag.DurationVar(&cfg.auth.accessTTL, "auth-access-ttl", 15*time.Minute, "Lifetime of authentication tokens")
+	flag.DurationVar(&cfg.auth.refreshTTL, "auth-refresh-ttl", 30*24*time.Hour, "Lifetime of refresh tokens, renewed on every refresh")
+
 	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
 		cfg.cors.trustedOrigins = strings.Fields(val)
 		return nil
@@ -93,6 +251,28 @@ func main() {
 
 	flag.Parse()
 
+	cfg.baseURL = strings.TrimSuffix(cfg.baseURL, "/")
+	cfg.frontend.baseURL = strings.TrimSuffix(cfg.frontend.baseURL, "/")
+	cfg.torrent.CreatedBy = "Qumran/" + version
+
+	for _, format := range cfg.covers.formats {
+		if format != imaging.JPEG && format != imaging.WebP {
+			fmt.Fprintf(os.Stderr, "invalid cover format %q\n", format)
+			os.Exit(2)
+		}
+	}
+
+	if cfg.jobs.lease <= 0 {
+		fmt.Fprintln(os.Stderr, "jobs-lease must be positive")
+		os.Exit(2)
+	}
+
+	scrubber, err := metadata.New(cfg.metadata.driver, cfg.metadata.exiftoolPath, cfg.metadata.fallback)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
 	db, err := openDB(cfg)
@@ -105,6 +285,20 @@ func main() {
 
 	logger.Info("database connection pool stablished")
 
+	store, err := settings.OpenStorage(cfg.storage.driver, cfg.storage.root, cfg.storage.s3)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	logger.Info("storage backend ready", "driver", cfg.storage.driver)
+
+	err = os.MkdirAll(cfg.jobs.stagingDir, 0755)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
 	expvar.NewString("version").Set(version)
 
 	expvar.Publish("goroutines", expvar.Func(func() any {
@@ -120,12 +314,28 @@ func main() {
 	}))
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:    cfg,
+		logger:    logger,
+		models:    data.NewModels(db),
+		mailer:    mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage:   store,
+		scrubber:  scrubber,
+		extractor: textract.NewPdftotext(cfg.text.pdftotextPath, time.Minute),
+		quit:      make(chan struct{}),
+		jobsReady: make(chan struct{}, 1),
+	}
+
+	if cfg.epub.enabled {
+		app.converter = ebook.NewCalibre(cfg.epub.convertPath, cfg.epub.metaPath, cfg.epub.timeout)
 	}
 
+	if cfg.covers.generate {
+		app.rasterizer = imaging.NewPdftoppm(cfg.covers.rasterizerPath, cfg.covers.rasterizerWidth, time.Minute)
+	}
+
+	app.startWorkers()
+	app.startUploadSweeper()
+
 	err = app.serve()
 	if err != nil {
 		logger.Error(err.Error())
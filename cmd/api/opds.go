@@ -0,0 +1,418 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/imaging"
+	"qumran.jesarx.com/internal/opds"
+	"qumran.jesarx.com/internal/storage"
+	"qumran.jesarx.com/internal/validator"
+)
+
+// opdsVersion is one of the two flavors of the catalog: OPDS 1.2 Atom feeds
+// under /opds and OPDS 2.0 JSON under /opds/v2. Both have the same feeds.
+type opdsVersion struct {
+	prefix string
+	json   bool
+}
+
+var (
+	opdsAtom = opdsVersion{prefix: "/opds"}
+	opdsJSON = opdsVersion{prefix: "/opds/v2", json: true}
+)
+
+const (
+	opdsPageSize     = 25
+	opdsListPageSize = 100
+)
+
+func (ver opdsVersion) navigationType() string {
+	if ver.json {
+		return opds.TypeJSON
+	}
+	return opds.TypeNavigation
+}
+
+func (ver opdsVersion) acquisitionType() string {
+	if ver.json {
+		return opds.TypeJSON
+	}
+	return opds.TypeAcquisition
+}
+
+// opdsLinks returns the links every feed has: itself, the root and search
+func (app *application) opdsLinks(ver opdsVersion, r *http.Request, selfType string) []opds.Link {
+	links := []opds.Link{
+		{Rel: "self", Href: app.publicURL(r.URL.Path, r.URL.Query()), Type: selfType},
+		{Rel: "start", Href: app.publicURL(ver.prefix, nil), Type: ver.navigationType()},
+	}
+
+	if ver.json {
+		links = append(links, opds.Link{Rel: "search", Href: app.publicURL(ver.prefix+"/books", nil) + "{?q}", Type: opds.TypeJSON, Templated: true})
+	} else {
+		links = append(links, opds.Link{Rel: "search", Href: app.publicURL("/opds/search.xml", nil), Type: opds.TypeOpenSearch})
+	}
+
+	return links
+}
+
+// opdsPageLinks adds first, previous, next and last links to the pages of a
+// paged feed
+func (app *application) opdsPageLinks(r *http.Request, metadata data.Metadata, linkType string) []opds.Link {
+	if metadata.LastPage <= 1 {
+		return nil
+	}
+
+	page := func(rel string, n int) opds.Link {
+		qs := r.URL.Query()
+		qs.Set("page", strconv.Itoa(n))
+		return opds.Link{Rel: rel, Href: app.publicURL(r.URL.Path, qs), Type: linkType}
+	}
+
+	links := []opds.Link{page("first", 1)}
+	if metadata.CurrentPage > 1 {
+		links = append(links, page("previous", metadata.CurrentPage-1))
+	}
+	if metadata.CurrentPage < metadata.LastPage {
+		links = append(links, page("next", metadata.CurrentPage+1))
+	}
+	links = append(links, page("last", metadata.LastPage))
+
+	return links
+}
+
+func (app *application) writeOPDS(w http.ResponseWriter, r *http.Request, ver opdsVersion, feed *opds.Feed, contentType string) {
+	var body []byte
+	var err error
+
+	if ver.json {
+		body, err = feed.JSON()
+	} else {
+		body, err = feed.Atom()
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// readOPDSPage reads the page parameter of a paged feed
+func (app *application) readOPDSPage(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := validator.New()
+
+	page := app.readInt(r.URL.Query(), "page", 1, v)
+	v.Check(page > 0, "page", "must be greater than zero")
+	v.Check(page <= 10_000_000, "page", "must be a maximum of 10 million")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return 0, false
+	}
+
+	return page, true
+}
+
+func (app *application) opdsRootHandler(ver opdsVersion) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		feed := &opds.Feed{
+			ID:      "urn:pirateca:catalog",
+			Title:   opds.Name,
+			Updated: time.Now(),
+			Links:   app.opdsLinks(ver, r, ver.navigationType()),
+			Navigation: []opds.Navigation{
+				{ID: "urn:pirateca:new", Title: "Novedades", Href: app.publicURL(ver.prefix+"/new", nil), Type: ver.acquisitionType()},
+				{ID: "urn:pirateca:authors", Title: "Autores", Href: app.publicURL(ver.prefix+"/authors", nil), Type: ver.navigationType()},
+				{ID: "urn:pirateca:publishers", Title: "Editoriales", Href: app.publicURL(ver.prefix+"/publishers", nil), Type: ver.navigationType()},
+				{ID: "urn:pirateca:tags", Title: "Etiquetas", Href: app.publicURL(ver.prefix+"/tags", nil), Type: ver.navigationType()},
+			},
+		}
+
+		app.writeOPDS(w, r, ver, feed, ver.navigationType())
+	}
+}
+
+// opdsBooksHandler serves the acquisition feeds: the newest books, the books
+// of an author, publisher or tag, and search results
+func (app *application) opdsBooksHandler(ver opdsVersion) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, ok := app.readOPDSPage(w, r)
+		if !ok {
+			return
+		}
+
+		qs := r.URL.Query()
+
+		var filter data.BookFilter
+		filters := data.Filters{
+			Page:         page,
+			PageSize:     opdsPageSize,
+			Sort:         "-created_at",
+			SortSafelist: []string{"-created_at", "relevance"},
+		}
+
+		id, title := "urn:pirateca:new", "Novedades"
+
+		switch {
+		case qs.Get("q") != "":
+			filter.Query = qs.Get("q")
+			filters.Sort = "relevance"
+			id, title = "urn:pirateca:search", fmt.Sprintf("Resultados de «%s»", filter.Query)
+		case qs.Get("author") != "":
+			filter.AuthorSlugs = []string{qs.Get("author")}
+			id, title = "urn:pirateca:author:"+qs.Get("author"), "Autor"
+		case qs.Get("publisher") != "":
+			filter.PublisherSlugs = []string{qs.Get("publisher")}
+			id, title = "urn:pirateca:publisher:"+qs.Get("publisher"), "Editorial"
+		case qs.Get("tag") != "":
+			filter.Tags = []string{qs.Get("tag")}
+			id, title = "urn:pirateca:tag:"+url.PathEscape(qs.Get("tag")), qs.Get("tag")
+		}
+
+		books, metadata, err := app.models.Books.GetAll(filter, filters)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.loadCovers(books...)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Name author and publisher feeds after them
+		if len(books) > 0 {
+			switch {
+			case len(filter.AuthorSlugs) > 0:
+				title = authorOf(books[0], filter.AuthorSlugs[0])
+			case len(filter.PublisherSlugs) > 0:
+				title = books[0].PublisherName
+			}
+		}
+
+		feed := &opds.Feed{
+			ID:           id,
+			Title:        title,
+			Updated:      time.Now(),
+			Links:        append(app.opdsLinks(ver, r, ver.acquisitionType()), app.opdsPageLinks(r, metadata, ver.acquisitionType())...),
+			TotalResults: metadata.TotalRecords,
+			ItemsPerPage: opdsPageSize,
+			CurrentPage:  metadata.CurrentPage,
+		}
+
+		for i, book := range books {
+			if i == 0 || book.CreatedAt.After(feed.Updated) {
+				feed.Updated = book.CreatedAt
+			}
+			feed.Publications = append(feed.Publications, app.opdsPublication(ver, book))
+		}
+
+		app.writeOPDS(w, r, ver, feed, ver.acquisitionType())
+	}
+}
+
+// authorOf returns the full name of the author of book with slug
+func authorOf(book *data.Book, slug string) string {
+	if book.Author2Slug != nil && *book.Author2Slug == slug {
+		return strings.TrimSpace(deref(book.Author2Name) + " " + deref(book.Author2LastName))
+	}
+	return strings.TrimSpace(book.AuthorName + " " + book.AuthorLastName)
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func (app *application) opdsPublication(ver opdsVersion, book *data.Book) opds.Publication {
+	pub := opds.Publication{
+		ID:        fmt.Sprintf("urn:pirateca:book:%d", book.ID),
+		Title:     book.Title,
+		Publisher: book.PublisherName,
+		Language:  book.Language,
+		Year:      int(book.Year),
+		Subjects:  book.Tags,
+		Updated:   book.CreatedAt,
+	}
+
+	authorURL := func(slug string) string {
+		return app.publicURL(ver.prefix+"/books", url.Values{"author": {slug}})
+	}
+
+	pub.Authors = append(pub.Authors, opds.Author{Name: authorOf(book, book.AuthorSlug), Href: authorURL(book.AuthorSlug)})
+	if book.Author2Slug != nil {
+		pub.Authors = append(pub.Authors, opds.Author{Name: authorOf(book, *book.Author2Slug), Href: authorURL(*book.Author2Slug)})
+	}
+
+	// Books whose direct download was turned off (dir_dwl) are listed
+	// without acquisition links
+	if book.DirDwl {
+		for _, format := range book.Formats {
+			switch format {
+			case data.FormatPDF:
+				name := storage.Filename(storage.KindPDF, book.Filename)
+				pub.Acquisitions = append(pub.Acquisitions, opds.Link{Href: app.publicURL("/v1/pdfs", url.Values{"file": {name}}), Type: "application/pdf", Title: "PDF"})
+			case data.FormatEPUB:
+				name := storage.Filename(storage.KindEPUB, book.Filename)
+				pub.Acquisitions = append(pub.Acquisitions, opds.Link{Href: app.publicURL("/v1/epubs", url.Values{"file": {name}}), Type: "application/epub+zip", Title: "EPUB"})
+			}
+		}
+	}
+
+	// E-readers handle JPEG best; the largest rendition is the cover and the
+	// smallest the thumbnail
+	for _, cover := range book.Covers {
+		if cover.Format != imaging.JPEG {
+			continue
+		}
+
+		image := &opds.Image{Href: cover.URL, Type: "image/jpeg", Width: cover.Width, Height: cover.Height}
+
+		if pub.Cover == nil || cover.Width > pub.Cover.Width {
+			pub.Cover = image
+		}
+		if pub.Thumbnail == nil || cover.Width < pub.Thumbnail.Width {
+			pub.Thumbnail = image
+		}
+	}
+
+	return pub
+}
+
+func (app *application) opdsAuthorsHandler(ver opdsVersion) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, ok := app.readOPDSPage(w, r)
+		if !ok {
+			return
+		}
+
+		authors, metadata, err := app.models.Authors.GetAll("", "", data.Filters{
+			Page:         page,
+			PageSize:     opdsListPageSize,
+			Sort:         "last_name",
+			SortSafelist: []string{"last_name"},
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		feed := &opds.Feed{
+			ID:      "urn:pirateca:authors",
+			Title:   "Autores",
+			Updated: time.Now(),
+			Links:   append(app.opdsLinks(ver, r, ver.navigationType()), app.opdsPageLinks(r, metadata, ver.navigationType())...),
+		}
+
+		for _, author := range authors {
+			name := author.LastName
+			if author.Name != "" {
+				name += ", " + author.Name
+			}
+
+			feed.Navigation = append(feed.Navigation, opds.Navigation{
+				ID:    "urn:pirateca:author:" + author.Slug,
+				Title: name,
+				Href:  app.publicURL(ver.prefix+"/books", url.Values{"author": {author.Slug}}),
+				Type:  ver.acquisitionType(),
+				Count: int(author.Books),
+			})
+		}
+
+		app.writeOPDS(w, r, ver, feed, ver.navigationType())
+	}
+}
+
+func (app *application) opdsPublishersHandler(ver opdsVersion) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, ok := app.readOPDSPage(w, r)
+		if !ok {
+			return
+		}
+
+		publishers, metadata, err := app.models.Publishers.GetAll("", data.Filters{
+			Page:         page,
+			PageSize:     opdsListPageSize,
+			Sort:         "name",
+			SortSafelist: []string{"name"},
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		feed := &opds.Feed{
+			ID:      "urn:pirateca:publishers",
+			Title:   "Editoriales",
+			Updated: time.Now(),
+			Links:   append(app.opdsLinks(ver, r, ver.navigationType()), app.opdsPageLinks(r, metadata, ver.navigationType())...),
+		}
+
+		for _, publisher := range publishers {
+			feed.Navigation = append(feed.Navigation, opds.Navigation{
+				ID:    "urn:pirateca:publisher:" + publisher.Slug,
+				Title: publisher.Name,
+				Href:  app.publicURL(ver.prefix+"/books", url.Values{"publisher": {publisher.Slug}}),
+				Type:  ver.acquisitionType(),
+				Count: int(publisher.Books),
+			})
+		}
+
+		app.writeOPDS(w, r, ver, feed, ver.navigationType())
+	}
+}
+
+func (app *application) opdsTagsHandler(ver opdsVersion) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tags, err := app.models.Tags.GetAll()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		feed := &opds.Feed{
+			ID:      "urn:pirateca:tags",
+			Title:   "Etiquetas",
+			Updated: time.Now(),
+			Links:   app.opdsLinks(ver, r, ver.navigationType()),
+		}
+
+		for _, tag := range tags {
+			feed.Navigation = append(feed.Navigation, opds.Navigation{
+				ID:    "urn:pirateca:tag:" + url.PathEscape(tag.Name),
+				Title: tag.Name,
+				Href:  app.publicURL(ver.prefix+"/books", url.Values{"tag": {tag.Name}}),
+				Type:  ver.acquisitionType(),
+				Count: int(tag.Books),
+			})
+		}
+
+		app.writeOPDS(w, r, ver, feed, ver.navigationType())
+	}
+}
+
+// opdsSearchHandler serves the OpenSearch description that OPDS 1.2 clients
+// use to search the catalog
+func (app *application) opdsSearchHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := opds.OpenSearch(app.publicURL("/opds/books", nil) + "?q={searchTerms}")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", opds.TypeOpenSearch)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
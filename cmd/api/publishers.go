@@ -23,6 +23,7 @@ func (app *application) listPublishersHandler(w http.ResponseWriter, r *http.Req
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	app.readPagination(qs, &input.Filters, v)
 
 	input.Filters.Sort = app.readString(qs, "sort", "name")
 	input.Filters.SortSafelist = []string{"id", "name", "-id", "-name", "book_count", "-book_count"}
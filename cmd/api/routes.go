@@ -26,6 +26,14 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodPatch, "/v1/books/:id", app.requirePermission("books:write", app.updateBookHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/books/:id", app.requirePermission("books:write", app.deleteBookHandler))
 
+	router.HandlerFunc(http.MethodOptions, "/v1/uploads", app.uploadOptionsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/uploads", app.requirePermission("books:write", app.requireTusResumable(app.createUploadHandler)))
+	router.HandlerFunc(http.MethodHead, "/v1/uploads/:id", app.requirePermission("books:write", app.requireTusResumable(app.showUploadHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/uploads/:id", app.requirePermission("books:write", app.requireTusResumable(app.patchUploadHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/uploads/:id", app.requirePermission("books:write", app.requireTusResumable(app.deleteUploadHandler)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/jobs/:id", app.requirePermission("books:write", app.showJobHandler))
+
 	router.HandlerFunc(http.MethodPost, "/v1/authors", app.requirePermission("books:write", app.createAuthorHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/authors", app.listAuthorsHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/authors/:id", app.showAuthorHandler)
@@ -40,9 +48,53 @@ func (app *application) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodGet, "/v1/tags", app.listTagsHandler)
 
+	router.HandlerFunc(http.MethodGet, "/v1/search/suggest", app.suggestHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/feeds/books.atom", app.booksFeedHandler(false))
+	router.HandlerFunc(http.MethodGet, "/v1/feeds/books.rss", app.booksFeedHandler(true))
+
+	router.HandlerFunc(http.MethodGet, "/v1/sitemap.xml", app.sitemapIndexHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/sitemaps/:name", app.sitemapHandler)
+
+	router.HandlerFunc(http.MethodGet, "/opds", app.opdsRootHandler(opdsAtom))
+	router.HandlerFunc(http.MethodGet, "/opds/new", app.opdsBooksHandler(opdsAtom))
+	router.HandlerFunc(http.MethodGet, "/opds/books", app.opdsBooksHandler(opdsAtom))
+	router.HandlerFunc(http.MethodGet, "/opds/authors", app.opdsAuthorsHandler(opdsAtom))
+	router.HandlerFunc(http.MethodGet, "/opds/publishers", app.opdsPublishersHandler(opdsAtom))
+	router.HandlerFunc(http.MethodGet, "/opds/tags", app.opdsTagsHandler(opdsAtom))
+	router.HandlerFunc(http.MethodGet, "/opds/search.xml", app.opdsSearchHandler)
+
+	router.HandlerFunc(http.MethodGet, "/opds/v2", app.opdsRootHandler(opdsJSON))
+	router.HandlerFunc(http.MethodGet, "/opds/v2/new", app.opdsBooksHandler(opdsJSON))
+	router.HandlerFunc(http.MethodGet, "/opds/v2/books", app.opdsBooksHandler(opdsJSON))
+	router.HandlerFunc(http.MethodGet, "/opds/v2/authors", app.opdsAuthorsHandler(opdsJSON))
+	router.HandlerFunc(http.MethodGet, "/opds/v2/publishers", app.opdsPublishersHandler(opdsJSON))
+	router.HandlerFunc(http.MethodGet, "/opds/v2/tags", app.opdsTagsHandler(opdsJSON))
+
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireLogin(app.listSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions", app.requireLogin(app.deleteAllSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:id", app.requireLogin(app.deleteSessionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys", app.requireLogin(app.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/api-keys", app.requireLogin(app.requireActivatedUser(app.createAPIKeyHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/api-keys/:id", app.requireLogin(app.deleteAPIKeyHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireLogin(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/assets/audit", app.requirePermission("admin:audit", app.auditAssetsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission("admin:users", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("admin:users", app.showUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/activated", app.requirePermission("admin:users", app.updateUserActivationHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/sessions", app.requirePermission("admin:users", app.logoutUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/permissions", app.requirePermission("admin:users", app.listUserPermissionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission("admin:users", app.grantUserPermissionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requirePermission("admin:users", app.revokeUserPermissionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/audit-log", app.requirePermission("admin:users", app.listAuditLogHandler))
 
 	router.HandlerFunc(http.MethodGet, "/v1/metrics", app.requirePermission("books:write", expvar.Handler().ServeHTTP))
 
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"unicode/utf8"
+
+	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/validator"
+)
+
+func (app *application) suggestHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Query string
+		Types []string
+		Limit int
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Query = app.readString(qs, "q", "")
+	input.Types = app.readCSV(qs, "types", data.SuggestionTypes)
+	input.Limit = app.readInt(qs, "limit", 10, v)
+
+	length := utf8.RuneCountInString(input.Query)
+	v.Check(length >= 2, "q", "must be at least 2 characters long")
+	v.Check(length <= 100, "q", "must not be more than 100 characters long")
+	v.Check(input.Limit > 0, "limit", "must be greater than zero")
+	v.Check(input.Limit <= 20, "limit", "must be a maximum of 20")
+
+	for _, t := range input.Types {
+		v.Check(validator.PermittedValue(t, data.SuggestionTypes...), "types", "invalid suggestion type")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Search.Suggest(input.Query, input.Types, input.Limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
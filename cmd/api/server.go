@@ -41,6 +41,9 @@ func (app *application) serve() error {
 
 		app.logger.Info("completing backgound tasks", "addr", srv.Addr)
 
+		// Let the job workers finish their current job and exit
+		close(app.quit)
+
 		app.wg.Wait()
 		shutdownError <- nil
 	}()
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/sitemap"
+)
+
+// sitemapSize is the number of URLs in each sitemap of the index, below the
+// limit of 50,000
+const sitemapSize = 10000
+
+// sitemapIndexHandler lists the sitemaps of books, authors and publishers.
+// Sitemaps link to the frontend, so they are only served when its base URL is
+// configured.
+func (app *application) sitemapIndexHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.frontend.baseURL == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var urls []sitemap.URL
+
+	for _, kind := range data.SitemapKinds {
+		chunks, err := app.models.Sitemaps.Chunks(kind, sitemapSize)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for _, chunk := range chunks {
+			urls = append(urls, sitemap.URL{
+				Loc:     app.publicURL(fmt.Sprintf("/v1/sitemaps/%s-%d.xml", kind, chunk.Page), nil),
+				LastMod: chunk.Modified,
+			})
+		}
+	}
+
+	body, err := sitemap.Index(urls)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeSitemap(w, r, urls, body)
+}
+
+// sitemapHandler serves a page of a sitemap, named like books-2.xml
+func (app *application) sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.frontend.baseURL == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	params := httprouter.ParamsFromContext(r.Context())
+
+	name, ok := strings.CutSuffix(params.ByName("name"), ".xml")
+	kind, number, found := strings.Cut(name, "-")
+	page, err := strconv.Atoi(number)
+	if !ok || !found || err != nil || page < 1 || !slices.Contains(data.SitemapKinds, kind) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	entries, err := app.models.Sitemaps.Entries(kind, page, sitemapSize)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if len(entries) == 0 && page > 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	urls := make([]sitemap.URL, len(entries))
+	for i, entry := range entries {
+		urls[i] = sitemap.URL{Loc: app.frontendURL(kind, entry.Slug), LastMod: entry.Modified}
+	}
+
+	body, err := sitemap.Sitemap(urls)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeSitemap(w, r, urls, body)
+}
+
+// writeSitemap sends a sitemap dated by its newest URL, or 304 when the
+// client's copy is current
+func (app *application) writeSitemap(w http.ResponseWriter, r *http.Request, urls []sitemap.URL, body []byte) {
+	var modified time.Time
+	h := sha256.New()
+
+	for _, u := range urls {
+		if u.LastMod.After(modified) {
+			modified = u.LastMod
+		}
+		fmt.Fprintf(h, "%s|%d\n", u.Loc, u.LastMod.Unix())
+	}
+	if modified.IsZero() {
+		modified = time.Unix(0, 0)
+	}
+
+	etag := `W/"` + hex.EncodeToString(h.Sum(nil))[:32] + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", sitemap.Type)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
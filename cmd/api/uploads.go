@@ -0,0 +1,491 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"qumran.jesarx.com/internal/data"
+)
+
+// Resumable uploads follow the tus 1.0 protocol (https://tus.io/protocols/resumable-upload)
+// with the creation, termination and expiration extensions. A completed
+// upload can be used as the PDF of a new book.
+
+const tusVersion = "1.0.0"
+
+var uploadIDRX = regexp.MustCompile(`^[a-f0-9]{32}$`)
+
+// uploadPath returns where the data of an upload is kept while it is received
+func (app *application) uploadPath(id string) string {
+	return filepath.Join(app.config.jobs.stagingDir, "upload-"+id)
+}
+
+func (app *application) readUploadIDParam(r *http.Request) (string, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id := params.ByName("id")
+
+	if !uploadIDRX.MatchString(id) {
+		return "", errors.New("invalid upload id")
+	}
+
+	return id, nil
+}
+
+// lockUpload serialises the requests that modify an upload. It returns false
+// if another request holds the lock.
+func (app *application) lockUpload(id string) (func(), bool) {
+	v, _ := app.uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+
+	if !mu.TryLock() {
+		return nil, false
+	}
+
+	return mu.Unlock, true
+}
+
+// validUploadMetadata checks the Upload-Metadata header: comma separated
+// keys, each optionally followed by a space and a base64 encoded value
+func validUploadMetadata(header string) bool {
+	if header == "" {
+		return true
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" || strings.ContainsAny(key, " ,") {
+			return false
+		}
+
+		if _, err := base64.StdEncoding.DecodeString(value); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (app *application) setUploadHeaders(w http.ResponseWriter, upload *data.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+// getUpload fetches an upload of the current user, writing the error response
+// when it doesn't exist, belongs to someone else or has expired
+func (app *application) getUpload(w http.ResponseWriter, r *http.Request) (*data.Upload, bool) {
+	id, err := app.readUploadIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	upload, err := app.models.Uploads.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if upload.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	if upload.Expired() {
+		app.errorResponse(w, r, http.StatusGone, "the upload has expired")
+		return nil, false
+	}
+
+	return upload, true
+}
+
+// requireTusResumable rejects requests made with another version of the
+// protocol
+func (app *application) requireTusResumable(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+
+		if r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			app.errorResponse(w, r, http.StatusPreconditionFailed, "unsupported tus version")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func (app *application) uploadOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,termination,expiration")
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(app.config.uploads.maxSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) createUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		app.errorResponse(w, r, http.StatusBadRequest, "Upload-Defer-Length is not supported")
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 1 {
+		app.errorResponse(w, r, http.StatusBadRequest, "Upload-Length must be a positive integer")
+		return
+	}
+
+	if length > app.config.uploads.maxSize {
+		app.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("uploads must not be larger than %d bytes", app.config.uploads.maxSize))
+		return
+	}
+
+	metadata := r.Header.Get("Upload-Metadata")
+	if !validUploadMetadata(metadata) {
+		app.errorResponse(w, r, http.StatusBadRequest, "invalid Upload-Metadata header")
+		return
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	file, err := os.OpenFile(app.uploadPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	file.Close()
+
+	upload := &data.Upload{
+		ID:        id,
+		UserID:    app.contextGetUser(r).ID,
+		Length:    length,
+		Metadata:  metadata,
+		ExpiresAt: time.Now().Add(app.config.uploads.expiry),
+	}
+
+	err = app.models.Uploads.Insert(upload)
+	if err != nil {
+		os.Remove(app.uploadPath(id))
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.setUploadHeaders(w, upload)
+	w.Header().Set("Location", "/v1/uploads/"+id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (app *application) showUploadHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := app.getUpload(w, r)
+	if !ok {
+		return
+	}
+
+	app.setUploadHeaders(w, upload)
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		w.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (app *application) patchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		app.errorResponse(w, r, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream")
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		app.errorResponse(w, r, http.StatusBadRequest, "Upload-Offset must be a non-negative integer")
+		return
+	}
+
+	upload, ok := app.getUpload(w, r)
+	if !ok {
+		return
+	}
+
+	unlock, ok := app.lockUpload(upload.ID)
+	if !ok {
+		app.errorResponse(w, r, http.StatusLocked, "the upload is being written by another request")
+		return
+	}
+	defer unlock()
+
+	// Read the offset again now that no other request can change it
+	upload, err = app.models.Uploads.Get(upload.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if offset != upload.Offset {
+		app.errorResponse(w, r, http.StatusConflict, fmt.Sprintf("Upload-Offset must be %d", upload.Offset))
+		return
+	}
+
+	remaining := upload.Length - upload.Offset
+	if r.ContentLength > remaining {
+		app.errorResponse(w, r, http.StatusBadRequest, "the chunk is larger than the rest of the upload")
+		return
+	}
+
+	// Chunks can take much longer than the server-wide timeouts
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(app.config.uploads.chunkTimeout)
+
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	file, err := os.OpenFile(app.uploadPath(upload.ID), os.O_WRONLY, 0)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	// Drop whatever an interrupted request wrote past the recorded offset
+	err = file.Truncate(upload.Offset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	_, err = file.Seek(upload.Offset, io.SeekStart)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Keep what was received even if the connection drops, so the client can
+	// resume from there
+	n, copyErr := io.Copy(file, io.LimitReader(r.Body, remaining))
+
+	err = file.Sync()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	upload.Offset += n
+	upload.ExpiresAt = time.Now().Add(app.config.uploads.expiry)
+
+	err = app.models.Uploads.UpdateOffset(upload)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if copyErr != nil {
+		app.logger.Warn("upload chunk interrupted", "upload_id", upload.ID, "received", n, "error", copyErr)
+		app.errorResponse(w, r, http.StatusBadRequest, "the chunk could not be read completely")
+		return
+	}
+
+	app.setUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) deleteUploadHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := app.getUpload(w, r)
+	if !ok {
+		return
+	}
+
+	unlock, ok := app.lockUpload(upload.ID)
+	if !ok {
+		app.errorResponse(w, r, http.StatusLocked, "the upload is being written by another request")
+		return
+	}
+	defer unlock()
+
+	err := app.models.Uploads.Delete(upload.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.removeUploadData(upload.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) removeUploadData(id string) {
+	err := os.Remove(app.uploadPath(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		app.logger.Error("failed to remove upload data", "upload_id", id, "error", err)
+	}
+
+	app.uploadLocks.Delete(id)
+}
+
+// invalidUploadError is returned by claimUpload when the client referenced an
+// upload it can't use
+type invalidUploadError struct {
+	message string
+}
+
+func (e invalidUploadError) Error() string {
+	return e.message
+}
+
+// claimedUpload is a completed upload moved into the staging area. It stays
+// locked, and its record is kept, until finishUpload or releaseUpload is
+// called.
+type claimedUpload struct {
+	id     string
+	name   string
+	unlock func()
+}
+
+// claimUpload moves a completed upload of the current user into the staging
+// area as an uploaded file, under the returned staged name.
+func (app *application) claimUpload(r *http.Request, id string) (*claimedUpload, error) {
+	if !uploadIDRX.MatchString(id) {
+		return nil, invalidUploadError{"must be a valid upload id"}
+	}
+
+	upload, err := app.models.Uploads.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, invalidUploadError{"must refer to an existing upload"}
+		}
+		return nil, err
+	}
+
+	if upload.UserID != app.contextGetUser(r).ID || upload.Expired() {
+		return nil, invalidUploadError{"must refer to an existing upload"}
+	}
+
+	unlock, ok := app.lockUpload(upload.ID)
+	if !ok {
+		return nil, invalidUploadError{"upload is still being written"}
+	}
+
+	claimed, err := app.stageClaimedUpload(id)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	claimed.unlock = unlock
+
+	return claimed, nil
+}
+
+func (app *application) stageClaimedUpload(id string) (*claimedUpload, error) {
+	upload, err := app.models.Uploads.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, invalidUploadError{"must refer to an existing upload"}
+		}
+		return nil, err
+	}
+
+	if !upload.Complete() {
+		return nil, invalidUploadError{fmt.Sprintf("upload is not complete (%d of %d bytes received)", upload.Offset, upload.Length)}
+	}
+
+	name, err := stagedName(".pdf")
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(app.uploadPath(id), app.stagedPath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return &claimedUpload{id: id, name: name}, nil
+}
+
+// finishUpload deletes the record of a claimed upload once the job that owns
+// its file is queued
+func (app *application) finishUpload(claimed *claimedUpload) {
+	err := app.models.Uploads.Delete(claimed.id)
+	if err != nil {
+		app.logger.Error("failed to delete claimed upload", "upload_id", claimed.id, "error", err)
+	}
+
+	claimed.unlock()
+	app.uploadLocks.Delete(claimed.id)
+}
+
+// releaseUpload moves the file of a claimed upload back, so that the client
+// can use the upload again when its book could not be queued
+func (app *application) releaseUpload(claimed *claimedUpload) {
+	err := os.Rename(app.stagedPath(claimed.name), app.uploadPath(claimed.id))
+	if err != nil {
+		app.logger.Error("failed to release claimed upload", "upload_id", claimed.id, "error", err)
+	}
+
+	claimed.unlock()
+}
+
+// startUploadSweeper periodically removes expired uploads and their data
+func (app *application) startUploadSweeper() {
+	app.backgound(func() {
+		ticker := time.NewTicker(app.config.uploads.sweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.quit:
+				return
+			case <-ticker.C:
+			}
+
+			ids, err := app.models.Uploads.DeleteExpired()
+			if err != nil {
+				app.logger.Error("failed to delete expired uploads", "error", err)
+				continue
+			}
+
+			for _, id := range ids {
+				app.removeUploadData(id)
+			}
+
+			if len(ids) > 0 {
+				app.logger.Info("removed expired uploads", "count", len(ids))
+			}
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/storage"
+	"qumran.jesarx.com/internal/textract"
+)
+
+func analyzeBackfill(ctl *controller, args []string) error {
+	fs := flag.NewFlagSet("analyze-backfill", flag.ExitOnError)
+	missing := fs.Bool("missing", false, "Only process books without a detected page count")
+	slug := fs.String("slug", "", "Only process the book with this slug")
+	fs.Parse(args)
+
+	books, err := ctl.models.Books.GetAllWithFiles()
+	if err != nil {
+		return err
+	}
+
+	extractor := textract.NewPdftotext(ctl.config.text.pdftotextPath, time.Minute)
+
+	var processed, failed int
+
+	for _, book := range books {
+		if *slug != "" && book.Slug != *slug {
+			continue
+		}
+
+		if !slices.Contains(book.Formats, data.FormatPDF) {
+			continue
+		}
+
+		if *missing && book.PagesDetected > 0 {
+			continue
+		}
+
+		analysis, err := ctl.analyzeBook(book, extractor)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			failed++
+			ctl.logger.Error("failed to analyze PDF", "book", book.Slug, "error", err)
+			continue
+		}
+
+		processed++
+		ctl.logger.Info("analyzed PDF", "book", book.Slug, "pages", analysis.Pages, "language", analysis.Language)
+	}
+
+	ctl.logger.Info("analysis backfill finished", "processed", processed, "failed", failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d books could not be processed", failed)
+	}
+
+	return nil
+}
+
+// analyzeBook detects the page count, text layer and language of the stored
+// PDF of a book and records them
+func (ctl *controller) analyzeBook(book *data.Book, extractor textract.Extractor) (*textract.Analysis, error) {
+	workDir, err := os.MkdirTemp("", "qumranctl-analyze-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	pdfName := storage.Filename(storage.KindPDF, book.Filename)
+	pdfPath := filepath.Join(workDir, pdfName)
+
+	obj, _, err := ctl.storage.Get(storage.KindPDF, pdfName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = saveObject(obj, pdfPath)
+	obj.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	analysis, err := textract.Analyze(extractor, pdfPath, ctl.config.text.samplePages)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctl.models.Books.UpdateAnalysis(book.ID, int32(analysis.Pages), analysis.HasTextLayer, analysis.Language)
+	if err != nil {
+		return nil, err
+	}
+
+	if analysis.HasTextLayer != nil && *analysis.HasTextLayer {
+		text, err := extractor.PDFText(pdfPath, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		err = ctl.models.BookTexts.Set(book.ID, textract.CleanBody(text), true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return analysis, nil
+}
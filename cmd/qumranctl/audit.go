@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"qumran.jesarx.com/internal/audit"
+	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/storage"
+)
+
+func auditAssets(ctl *controller, args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	fix := fs.Bool("fix", false, "Quarantine orphaned files and regenerate broken torrents")
+	skipTorrents := fs.Bool("skip-torrents", false, "Don't check torrents against their PDFs")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	fs.Parse(args)
+
+	books, err := ctl.models.Books.GetAllWithFiles()
+	if err != nil {
+		return err
+	}
+
+	report, err := audit.Run(books, ctl.storage, audit.Options{VerifyTorrents: !*skipTorrents})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		for _, issue := range report.Issues {
+			ctl.logger.Warn(issue.Type, "kind", issue.Kind, "name", issue.Name, "book", issue.Slug, "detail", issue.Detail)
+		}
+		ctl.logger.Info("audit finished", "books", report.Books, "files", report.Files, "issues", len(report.Issues))
+	}
+
+	if len(report.Issues) == 0 {
+		return nil
+	}
+
+	if !*fix {
+		return fmt.Errorf("%d issues found", len(report.Issues))
+	}
+
+	unfixed := ctl.fixIssues(books, report.Issues)
+	if unfixed > 0 {
+		return fmt.Errorf("%d issues could not be fixed", unfixed)
+	}
+
+	return nil
+}
+
+// fixIssues quarantines orphans and regenerates the torrents of books whose
+// torrent is missing, empty or out of date. It returns how many issues are
+// left.
+func (ctl *controller) fixIssues(books []*data.Book, issues []*audit.Issue) int {
+	byID := map[int64]*data.Book{}
+	for _, book := range books {
+		byID[book.ID] = book
+	}
+
+	now := time.Now()
+	regenerated := map[int64]error{}
+	unfixed := 0
+
+	for _, issue := range issues {
+		switch {
+		case issue.Type == audit.Orphan:
+			name, err := audit.Quarantine(ctl.storage, issue.Kind, issue.Name, now)
+			if err != nil {
+				unfixed++
+				ctl.logger.Error("failed to quarantine file", "kind", issue.Kind, "name", issue.Name, "error", err)
+				continue
+			}
+			ctl.logger.Info("quarantined file", "kind", issue.Kind, "name", issue.Name, "quarantine", name)
+
+		case isTorrentIssue(issue):
+			book := byID[issue.BookID]
+
+			err, done := regenerated[book.ID]
+			if !done {
+				var infoHash string
+				infoHash, err = ctl.regenerateTorrent(book)
+				regenerated[book.ID] = err
+				if err == nil {
+					ctl.logger.Info("regenerated torrent", "book", book.Slug, "info_hash", infoHash)
+				}
+			}
+
+			if err != nil {
+				unfixed++
+				ctl.logger.Error("failed to regenerate torrent", "book", book.Slug, "error", err)
+			}
+
+		default:
+			unfixed++
+		}
+	}
+
+	return unfixed
+}
+
+func isTorrentIssue(issue *audit.Issue) bool {
+	if issue.Type == audit.TorrentMismatch {
+		return true
+	}
+
+	torrentKind := issue.Kind == storage.KindTorrent || issue.Kind == storage.KindTorrentAdded
+
+	return torrentKind && (issue.Type == audit.Missing || issue.Type == audit.Empty)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/imaging"
+	"qumran.jesarx.com/internal/storage"
+)
+
+func coversBackfill(ctl *controller, args []string) error {
+	fs := flag.NewFlagSet("covers-backfill", flag.ExitOnError)
+	missing := fs.Bool("missing", false, "Only process books without renditions")
+	slug := fs.String("slug", "", "Only process the book with this slug")
+	fs.Parse(args)
+
+	books, err := ctl.models.Books.GetAllWithFiles()
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int64, 0, len(books))
+	for _, book := range books {
+		ids = append(ids, book.ID)
+	}
+
+	existing, err := ctl.models.Covers.GetForBooks(ids)
+	if err != nil {
+		return err
+	}
+
+	var processed, failed int
+
+	for _, book := range books {
+		if *slug != "" && book.Slug != *slug {
+			continue
+		}
+
+		if *missing && len(existing[book.ID]) > 0 {
+			continue
+		}
+
+		err := ctl.regenerateRenditions(book)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			failed++
+			ctl.logger.Error("failed to create cover renditions", "book", book.Slug, "error", err)
+			continue
+		}
+
+		processed++
+		ctl.logger.Info("created cover renditions", "book", book.Slug)
+	}
+
+	ctl.logger.Info("cover backfill finished", "processed", processed, "failed", failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d books could not be processed", failed)
+	}
+
+	return nil
+}
+
+// regenerateRenditions resizes the stored cover of a book and records the
+// renditions. It returns storage.ErrNotFound if the book has no cover.
+func (ctl *controller) regenerateRenditions(book *data.Book) error {
+	workDir, err := os.MkdirTemp("", "qumranctl-covers-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	coverName := storage.Filename(storage.KindCover, book.Filename)
+	coverPath := filepath.Join(workDir, coverName)
+
+	obj, _, err := ctl.storage.Get(storage.KindCover, coverName)
+	if err != nil {
+		return err
+	}
+
+	err = saveObject(obj, coverPath)
+	obj.Close()
+	if err != nil {
+		return err
+	}
+
+	renditions, err := imaging.Renditions(coverPath, workDir, book.Filename, ctl.config.covers.widths, ctl.config.covers.formats)
+	if err != nil {
+		return err
+	}
+
+	var covers []*data.Cover
+
+	for _, rendition := range renditions {
+		name := filepath.Base(rendition.Path)
+
+		file, err := os.Open(rendition.Path)
+		if err != nil {
+			return err
+		}
+
+		err = ctl.storage.Put(storage.KindRendition, name, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+
+		record, err := ctl.hashStoredFile(storage.KindRendition, name)
+		if err != nil {
+			return err
+		}
+
+		covers = append(covers, &data.Cover{
+			Size:   rendition.Size,
+			Format: rendition.Format,
+			Width:  rendition.Width,
+			Height: rendition.Height,
+			Name:   name,
+			Bytes:  record.Size,
+			SHA256: record.SHA256,
+		})
+	}
+
+	return ctl.models.Covers.Replace(book.ID, covers)
+}
+
+func saveObject(obj storage.Object, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.ReadFrom(obj)
+	return err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/storage"
+)
+
+func filesBackfill(ctl *controller, args []string) error {
+	fs := flag.NewFlagSet("files-backfill", flag.ExitOnError)
+	missing := fs.Bool("missing", false, "Only hash files without a stored checksum")
+	slug := fs.String("slug", "", "Only process the book with this slug")
+	fs.Parse(args)
+
+	books, err := ctl.models.Books.GetAllWithFiles()
+	if err != nil {
+		return err
+	}
+
+	var processed, failed int
+
+	for _, book := range books {
+		if *slug != "" && book.Slug != *slug {
+			continue
+		}
+
+		known := map[string]bool{}
+		if *missing {
+			files, err := ctl.models.BookFiles.GetForBook(book.ID)
+			if err != nil {
+				return err
+			}
+			for _, file := range files {
+				known[file.Kind] = true
+			}
+		}
+
+		for _, kind := range storage.Kinds {
+			if known[string(kind)] {
+				continue
+			}
+
+			file, err := ctl.hashStoredFile(kind, storage.Filename(kind, book.Filename))
+			if err != nil {
+				if errors.Is(err, storage.ErrNotFound) {
+					continue
+				}
+				failed++
+				ctl.logger.Error("failed to hash file", "book", book.Slug, "kind", kind, "error", err)
+				continue
+			}
+
+			err = ctl.models.BookFiles.Upsert(book.ID, file)
+			if err != nil {
+				failed++
+				ctl.logger.Error("failed to record file", "book", book.Slug, "kind", kind, "error", err)
+				continue
+			}
+
+			processed++
+		}
+	}
+
+	ctl.logger.Info("file backfill finished", "processed", processed, "failed", failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be recorded", failed)
+	}
+
+	return nil
+}
+
+func (ctl *controller) hashStoredFile(kind storage.Kind, name string) (*data.BookFile, error) {
+	obj, _, err := ctl.storage.Get(kind, name)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	h := sha256.New()
+
+	size, err := io.Copy(h, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.BookFile{Kind: string(kind), Name: name, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/settings"
+	"qumran.jesarx.com/internal/storage"
+	"qumran.jesarx.com/internal/torrent"
+
+	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+const version = "1.0.0"
+
+type config struct {
+	dsn     string
+	baseURL string
+	torrent torrent.Config
+	covers  struct {
+		widths  []int
+		formats []string
+	}
+	text struct {
+		pdftotextPath string
+		samplePages   int
+	}
+	storage struct {
+		driver string
+		root   string
+		s3     storage.S3Config
+	}
+}
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctl *controller, args []string) error
+}
+
+var commands = []command{
+	{"torrents-backfill", "regenerate the torrent of every book and store its info hash", torrentsBackfill},
+	{"files-backfill", "record the size and SHA-256 of every stored book file", filesBackfill},
+	{"covers-backfill", "create the cover renditions of every book", coversBackfill},
+	{"analyze-backfill", "detect the page count, text layer and language of every PDF and index its text", analyzeBackfill},
+	{"audit", "cross-check the books against the stored files", auditAssets},
+}
+
+type controller struct {
+	logger  *slog.Logger
+	config  config
+	models  data.Models
+	storage storage.Storage
+}
+
+func main() {
+	viper.SetConfigFile("config.yaml")
+	err := viper.ReadInConfig()
+	if err != nil {
+		panic(fmt.Errorf("yaml config file not found: %s ", err))
+	}
+
+	settings.SetDefaults()
+
+	var cfg config
+
+	flag.StringVar(&cfg.dsn, "db-dsn", viper.GetString("database.dsn"), "PostgreSQL DSN")
+	flag.StringVar(&cfg.baseURL, "base-url", viper.GetString("api.base_url"), "Public base URL of the API")
+	flag.StringVar(&cfg.storage.driver, "storage-driver", viper.GetString("storage.driver"), "Storage backend (local|s3)")
+	flag.StringVar(&cfg.storage.root, "storage-root", viper.GetString("storage.root"), "Root directory for the local storage backend")
+
+	cfg.storage.s3 = storage.S3Config{
+		Endpoint:  viper.GetString("storage.s3.endpoint"),
+		Region:    viper.GetString("storage.s3.region"),
+		Bucket:    viper.GetString("storage.s3.bucket"),
+		AccessKey: viper.GetString("storage.s3.access_key"),
+		SecretKey: viper.GetString("storage.s3.secret_key"),
+		Prefix:    viper.GetString("storage.s3.prefix"),
+		PathStyle: viper.GetBool("storage.s3.path_style"),
+	}
+
+	cfg.covers.widths = viper.GetIntSlice("covers.widths")
+	cfg.covers.formats = viper.GetStringSlice("covers.formats")
+
+	cfg.text.pdftotextPath = viper.GetString("text.pdftotext_path")
+	cfg.text.samplePages = viper.GetInt("text.sample_pages")
+
+	cfg.torrent = torrent.Config{
+		Trackers:    viper.GetStringSlice("torrent.trackers"),
+		PieceLength: viper.GetInt64("torrent.piece_length"),
+		Hybrid:      viper.GetBool("torrent.hybrid"),
+		CreatedBy:   "Qumran/" + version,
+	}
+
+	flag.Usage = usage
+	flag.Parse()
+
+	cfg.baseURL = strings.TrimSuffix(cfg.baseURL, "/")
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == flag.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	db, err := openDB(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	store, err := settings.OpenStorage(cfg.storage.driver, cfg.storage.root, cfg.storage.s3)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	ctl := &controller{
+		logger:  logger,
+		config:  cfg,
+		models:  data.NewModels(db),
+		storage: store,
+	}
+
+	err = cmd.run(ctl, flag.Args()[1:])
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: qumranctl [flags] <command> [command flags]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func openDB(cfg config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", cfg.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
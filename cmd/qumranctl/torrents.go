@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+
+	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/storage"
+	"qumran.jesarx.com/internal/torrent"
+)
+
+func torrentsBackfill(ctl *controller, args []string) error {
+	fs := flag.NewFlagSet("torrents-backfill", flag.ExitOnError)
+	missing := fs.Bool("missing", false, "Only process books without a stored info hash")
+	slug := fs.String("slug", "", "Only process the book with this slug")
+	dryRun := fs.Bool("dry-run", false, "List the books that would be processed without writing anything")
+	fs.Parse(args)
+
+	books, err := ctl.models.Books.GetAllWithFiles()
+	if err != nil {
+		return err
+	}
+
+	var processed, failed int
+
+	for _, book := range books {
+		if *slug != "" && book.Slug != *slug {
+			continue
+		}
+
+		if *missing && book.InfoHash != "" {
+			continue
+		}
+
+		if *dryRun {
+			ctl.logger.Info("would regenerate torrent", "book", book.Slug)
+			continue
+		}
+
+		infoHash, err := ctl.regenerateTorrent(book)
+		if err != nil {
+			failed++
+			ctl.logger.Error("failed to regenerate torrent", "book", book.Slug, "error", err)
+			continue
+		}
+
+		processed++
+		ctl.logger.Info("regenerated torrent", "book", book.Slug, "info_hash", infoHash)
+	}
+
+	ctl.logger.Info("torrent backfill finished", "processed", processed, "failed", failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d torrents could not be regenerated", failed)
+	}
+
+	return nil
+}
+
+// regenerateTorrent rebuilds the torrent of a book from its stored PDF, writes
+// it to both torrent locations and records the new info hash.
+func (ctl *controller) regenerateTorrent(book *data.Book) (string, error) {
+	pdfName := storage.Filename(storage.KindPDF, book.Filename)
+
+	pdf, info, err := ctl.storage.Get(storage.KindPDF, pdfName)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return "", fmt.Errorf("pdf %s is missing", pdfName)
+		}
+		return "", err
+	}
+	defer pdf.Close()
+
+	opts := ctl.config.torrent.BookOptions(ctl.config.baseURL, pdfName, book.ShortTitle, book.AuthorName, book.AuthorLastName)
+
+	t, err := torrent.Create(pdf, info.Size, opts)
+	if err != nil {
+		return "", err
+	}
+
+	torrentName := storage.Filename(storage.KindTorrent, book.Filename)
+	sum := sha256.Sum256(t.Data)
+
+	for _, kind := range []storage.Kind{storage.KindTorrent, storage.KindTorrentAdded} {
+		err = ctl.storage.Put(kind, torrentName, bytes.NewReader(t.Data))
+		if err != nil {
+			return "", err
+		}
+
+		err = ctl.models.BookFiles.Upsert(book.ID, &data.BookFile{
+			Kind:   string(kind),
+			Name:   torrentName,
+			Size:   int64(len(t.Data)),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = ctl.models.Books.UpdateInfoHash(book.ID, t.InfoHashHex())
+	if err != nil {
+		return "", err
+	}
+
+	return t.InfoHashHex(), nil
+}
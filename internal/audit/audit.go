@@ -0,0 +1,251 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"qumran.jesarx.com/internal/data"
+	"qumran.jesarx.com/internal/storage"
+	"qumran.jesarx.com/internal/torrent"
+)
+
+// Issue types
+const (
+	Orphan          = "orphan"
+	Missing         = "missing"
+	Empty           = "empty"
+	TorrentMismatch = "torrent_mismatch"
+)
+
+type Issue struct {
+	Type   string       `json:"type"`
+	Kind   storage.Kind `json:"kind"`
+	Name   string       `json:"name"`
+	BookID int64        `json:"book_id,omitempty"`
+	Slug   string       `json:"slug,omitempty"`
+	Detail string       `json:"detail,omitempty"`
+}
+
+type Report struct {
+	Books  int      `json:"books"`
+	Files  int      `json:"files"`
+	Issues []*Issue `json:"issues"`
+}
+
+type Options struct {
+	// VerifyTorrents reads every PDF to check it against the piece hashes of
+	// its torrent, which is slow on large libraries
+	VerifyTorrents bool
+}
+
+// Run cross-checks the books against the files in store. Books must carry
+// their filename, slug and formats, as returned by BookModel.GetAllWithFiles.
+func Run(books []*data.Book, store storage.Storage, opts Options) (*Report, error) {
+	report := &Report{Books: len(books), Issues: []*Issue{}}
+
+	files := map[storage.Kind]map[string]*storage.Info{}
+
+	for _, kind := range storage.Kinds {
+		infos, err := store.List(kind)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", kind, err)
+		}
+
+		files[kind] = map[string]*storage.Info{}
+		for _, info := range infos {
+			files[kind][info.Name] = info
+		}
+
+		report.Files += len(infos)
+	}
+
+	owners := map[storage.Kind]map[string]*data.Book{}
+	for _, kind := range storage.Kinds {
+		owners[kind] = map[string]*data.Book{}
+	}
+
+	for _, book := range books {
+		for _, kind := range storage.Kinds {
+			owners[kind][storage.Filename(kind, book.Filename)] = book
+		}
+
+		for _, kind := range expectedKinds(book) {
+			name := storage.Filename(kind, book.Filename)
+			if _, ok := files[kind][name]; !ok {
+				report.add(Missing, kind, name, book, "")
+			}
+		}
+	}
+
+	for _, kind := range storage.Kinds {
+		names := make([]string, 0, len(files[kind]))
+		for name := range files[kind] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			book := owners[kind][name]
+
+			if book == nil {
+				report.add(Orphan, kind, name, nil, "")
+				continue
+			}
+
+			if files[kind][name].Size == 0 {
+				report.add(Empty, kind, name, book, "")
+			}
+		}
+	}
+
+	// Renditions are owned by the book whose filename prefixes them
+	filenames := map[string]*data.Book{}
+	for _, book := range books {
+		filenames[book.Filename] = book
+	}
+
+	renditions, err := store.List(storage.KindRendition)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", storage.KindRendition, err)
+	}
+
+	report.Files += len(renditions)
+
+	for _, info := range renditions {
+		book := filenames[renditionBase(info.Name)]
+
+		switch {
+		case book == nil:
+			report.add(Orphan, storage.KindRendition, info.Name, nil, "")
+		case info.Size == 0:
+			report.add(Empty, storage.KindRendition, info.Name, book, "")
+		}
+	}
+
+	if opts.VerifyTorrents {
+		for _, book := range books {
+			pdf := files[storage.KindPDF][storage.Filename(storage.KindPDF, book.Filename)]
+			torrentName := storage.Filename(storage.KindTorrent, book.Filename)
+			torrentInfo := files[storage.KindTorrent][torrentName]
+
+			if pdf == nil || pdf.Size == 0 || torrentInfo == nil || torrentInfo.Size == 0 {
+				continue
+			}
+
+			err := verifyTorrent(store, book)
+			if err != nil {
+				if !errors.Is(err, torrent.ErrMismatch) && !errors.Is(err, torrent.ErrInvalidMetainfo) && !errors.Is(err, torrent.ErrInvalidBencode) {
+					return nil, fmt.Errorf("failed to verify torrent of %s: %w", book.Slug, err)
+				}
+				report.add(TorrentMismatch, storage.KindTorrent, torrentName, book, err.Error())
+			}
+		}
+	}
+
+	return report, nil
+}
+
+func (r *Report) add(typ string, kind storage.Kind, name string, book *data.Book, detail string) {
+	issue := &Issue{Type: typ, Kind: kind, Name: name, Detail: detail}
+	if book != nil {
+		issue.BookID = book.ID
+		issue.Slug = book.Slug
+	}
+
+	r.Issues = append(r.Issues, issue)
+}
+
+// renditionBase returns the book filename of a rendition name, as built by
+// imaging.Name, or an empty string if the name doesn't have that form
+func renditionBase(name string) string {
+	ext := path.Ext(name)
+	if ext != ".jpg" && ext != ".webp" {
+		return ""
+	}
+
+	stem := strings.TrimSuffix(name, ext)
+
+	i := strings.LastIndex(stem, "-")
+	if i < 0 {
+		return ""
+	}
+
+	if width, err := strconv.Atoi(stem[i+1:]); err != nil || width < 1 {
+		return ""
+	}
+
+	return stem[:i]
+}
+
+// expectedKinds lists the files a book must have. Covers are optional.
+func expectedKinds(book *data.Book) []storage.Kind {
+	var kinds []storage.Kind
+
+	if slices.Contains(book.Formats, data.FormatPDF) {
+		kinds = append(kinds, storage.KindPDF, storage.KindTorrent, storage.KindTorrentAdded)
+	}
+
+	if slices.Contains(book.Formats, data.FormatEPUB) {
+		kinds = append(kinds, storage.KindEPUB)
+	}
+
+	return kinds
+}
+
+func verifyTorrent(store storage.Storage, book *data.Book) error {
+	obj, _, err := store.Get(storage.KindTorrent, storage.Filename(storage.KindTorrent, book.Filename))
+	if err != nil {
+		return err
+	}
+
+	raw, err := io.ReadAll(obj)
+	obj.Close()
+	if err != nil {
+		return err
+	}
+
+	meta, err := torrent.Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	pdf, info, err := store.Get(storage.KindPDF, storage.Filename(storage.KindPDF, book.Filename))
+	if err != nil {
+		return err
+	}
+	defer pdf.Close()
+
+	return meta.Verify(pdf, info.Size)
+}
+
+// Quarantine moves a file into the quarantine area, prefixed with the time
+// and its original kind so it can be restored by hand. It returns the
+// quarantined name.
+func Quarantine(store storage.Storage, kind storage.Kind, name string, now time.Time) (string, error) {
+	obj, _, err := store.Get(kind, name)
+	if err != nil {
+		return "", err
+	}
+	defer obj.Close()
+
+	quarantined := fmt.Sprintf("%s-%s-%s", now.UTC().Format("20060102T150405Z"), kind, name)
+
+	err = store.Put(storage.KindQuarantine, quarantined, obj)
+	if err != nil {
+		return "", err
+	}
+
+	err = store.Delete(kind, name)
+	if err != nil {
+		return "", err
+	}
+
+	return quarantined, nil
+}
@@ -0,0 +1,211 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"qumran.jesarx.com/internal/validator"
+)
+
+// APIKeyPrefix starts every API key, which tells them apart from
+// authentication tokens in the Authorization header
+const APIKeyPrefix = "pirateca_"
+
+// APIKey lets scripts act as a user with a subset of their permissions. The
+// key itself is only known when it is created; Prefix identifies it later.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Name        string      `json:"name"`
+	Plaintext   string      `json:"key,omitempty"`
+	Prefix      string      `json:"prefix"`
+	Hash        []byte      `json:"-"`
+	UserID      int64       `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	Expiry      *time.Time  `json:"expiry"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(key.Permissions) >= 1, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+// IsAPIKey reports whether the credential of a request is an API key rather
+// than an authentication token
+func IsAPIKey(plaintext string) bool {
+	return strings.HasPrefix(plaintext, APIKeyPrefix)
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(IsAPIKey(plaintext), "key", "must start with "+APIKeyPrefix)
+	v.Check(len(plaintext) == len(APIKeyPrefix)+32, "key", "must be 41 bytes long")
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// Insert generates the key and stores its hash
+func (m APIKeyModel) Insert(key *APIKey) error {
+	randomBytes := make([]byte, 20)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	key.Plaintext = APIKeyPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	key.Prefix = key.Plaintext[:len(APIKeyPrefix)+6]
+
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	query := `
+    INSERT INTO api_keys (user_id, name, prefix, hash, permissions, expiry)
+    VALUES ($1, $2, $3, $4, $5, $6)
+    RETURNING id, created_at
+  `
+
+	args := []any{key.UserID, key.Name, key.Prefix, key.Hash, pq.Array(key.Permissions), key.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+// GetForKey returns the unexpired API key with the given plaintext
+func (m APIKeyModel) GetForKey(plaintext string) (*APIKey, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `
+    SELECT id, created_at, user_id, name, prefix, permissions, expiry, last_used_at
+    FROM api_keys
+    WHERE hash = $1 AND (expiry IS NULL OR expiry > NOW())
+  `
+
+	var key APIKey
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(&key.ID, &key.CreatedAt, &key.UserID, &key.Name, &key.Prefix, pq.Array(&key.Permissions), &key.Expiry, &key.LastUsedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &key, nil
+}
+
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+    SELECT id, created_at, user_id, name, prefix, permissions, expiry, last_used_at
+    FROM api_keys
+    WHERE user_id = $1
+    ORDER BY id
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(&key.ID, &key.CreatedAt, &key.UserID, &key.Name, &key.Prefix, pq.Array(&key.Permissions), &key.Expiry, &key.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Touch records that an API key was used. It writes at most once a minute
+// per key.
+func (m APIKeyModel) Touch(id int64) error {
+	query := `
+    UPDATE api_keys
+    SET last_used_at = NOW()
+    WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+func (m APIKeyModel) DeleteForUser(id, userID int64) error {
+	query := `
+    DELETE FROM api_keys
+    WHERE id = $1 AND user_id = $2
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteAllForUserTx revokes every API key of a user as part of a transaction
+func (m APIKeyModel) DeleteAllForUserTx(tx *sql.Tx, userID int64) error {
+	query := `
+    DELETE FROM api_keys
+    WHERE user_id = $1
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, userID)
+	return err
+}
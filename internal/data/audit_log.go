@@ -0,0 +1,131 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditPermissionGrant  = "permission.grant"
+	AuditPermissionRevoke = "permission.revoke"
+	AuditUserActivate     = "user.activate"
+	AuditUserDeactivate   = "user.deactivate"
+	AuditUserLogout       = "user.logout"
+)
+
+var AuditActions = []string{AuditPermissionGrant, AuditPermissionRevoke, AuditUserActivate, AuditUserDeactivate, AuditUserLogout}
+
+// AuditEntry records a change an admin made to a user account. ActorID and
+// TargetUserID are nil once the user is deleted.
+type AuditEntry struct {
+	ID           int64          `json:"id"`
+	CreatedAt    time.Time      `json:"created_at"`
+	ActorID      *int64         `json:"actor_id"`
+	Action       string         `json:"action"`
+	TargetUserID *int64         `json:"target_user_id"`
+	Details      map[string]any `json:"details,omitempty"`
+	IP           string         `json:"ip"`
+}
+
+// AuditFilter narrows the audit log to an actor, a target user or an action
+type AuditFilter struct {
+	ActorID      int64
+	TargetUserID int64
+	Action       string
+}
+
+type AuditLogModel struct {
+	DB *sql.DB
+}
+
+// Insert records an entry inside the transaction of the change it describes,
+// so that neither is kept without the other
+func (m AuditLogModel) Insert(tx *sql.Tx, entry *AuditEntry) error {
+	if entry.Details == nil {
+		entry.Details = map[string]any{}
+	}
+
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return err
+	}
+
+	query := `
+    INSERT INTO audit_log (actor_id, action, target_user_id, details, ip)
+    VALUES ($1, $2, $3, $4, $5)
+    RETURNING id, created_at
+  `
+
+	args := []any{entry.ActorID, entry.Action, entry.TargetUserID, details, entry.IP}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// GetAll lists the audit log, newest first unless sorted by id
+func (m AuditLogModel) GetAll(filter AuditFilter, filters Filters) ([]*AuditEntry, Metadata, error) {
+	keys := map[string]sortKey{
+		"id": {expr: "l.id", typ: "bigint"},
+	}
+
+	key := keys[filters.sortColumn()]
+
+	query := fmt.Sprintf(`
+    SELECT %s, l.id, l.created_at, l.actor_id, l.action, l.target_user_id, l.details, l.ip,
+           (%s)::text AS sort_key, l.id AS sort_id
+    FROM audit_log l
+    WHERE ($1::bigint = 0 OR l.actor_id = $1)
+    AND ($2::bigint = 0 OR l.target_user_id = $2)
+    AND ($3 = '' OR l.action = $3)
+`, filters.countColumn(), key.expr)
+
+	order := fmt.Sprintf("ORDER BY %s %s", key.expr, filters.sortDirection())
+
+	query, args := filters.paginate(query, order, key, []any{filter.ActorID, filter.TargetUserID, filter.Action})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*AuditEntry{}
+	pageKeys := []pageKey{}
+
+	for rows.Next() {
+		var entry AuditEntry
+		var details []byte
+		var pk pageKey
+
+		err := rows.Scan(&totalRecords, &entry.ID, &entry.CreatedAt, &entry.ActorID, &entry.Action, &entry.TargetUserID, &details, &entry.IP, &pk.key, &pk.id)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		err = json.Unmarshal(details, &entry.Details)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+		pageKeys = append(pageKeys, pk)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	entries, metadata := pageOf(filters, entries, pageKeys, totalRecords)
+
+	return entries, metadata, nil
+}
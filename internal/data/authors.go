@@ -46,13 +46,22 @@ func (m AuthorModel) Update(author *Author) error {
     UPDATE authors 
     SET name = $1,
         last_name = $2,
+        updated_at = NOW(),
         slug = NULL  -- Setting slug to NULL forces PostgreSQL to regenerate it
     WHERE id = $3
     RETURNING slug
   `
 	args := []any{author.Name, author.LastName, author.ID}
 	err := m.DB.QueryRow(query, args...).Scan(&author.Slug)
-	return err
+	if err != nil {
+		return err
+	}
+
+	// The author's name is part of the search document of their books
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return refreshSearch(ctx, m.DB, "b.auth_id = $1 OR b.auth2_id = $1", author.ID)
 }
 
 func (m AuthorModel) Delete(id int64) error {
@@ -152,12 +161,24 @@ func (m AuthorModel) Get(id int64, filters Filters) (*Author, []*Book, Metadata,
 }
 
 func (m AuthorModel) GetAll(name string, last_name string, filters Filters) ([]*Author, Metadata, error) {
-	query := fmt.Sprintf(`
-    SELECT count(*) OVER(), a.id, a.name, a.last_name, a.slug, 
-           COUNT(DISTINCT 
+	bookCount := `COUNT(DISTINCT 
                CASE WHEN b.auth_id = a.id OR b.auth2_id = a.id 
                THEN b.id END
-           ) as book_count
+           )`
+
+	keys := map[string]sortKey{
+		"id":         {expr: "a.id", typ: "bigint"},
+		"name":       {expr: "coalesce(a.name, '')", typ: "text"},
+		"last_name":  {expr: "a.last_name", typ: "text"},
+		"book_count": {expr: bookCount, typ: "bigint"},
+	}
+
+	key := keys[filters.sortColumn()]
+
+	query := fmt.Sprintf(`
+    SELECT %s, a.id, a.name, a.last_name, a.slug, 
+           %s as book_count,
+           (%s)::text AS sort_key, a.id AS sort_id
     FROM authors a
     LEFT JOIN books b ON (a.id = b.auth_id OR a.id = b.auth2_id)
     WHERE (
@@ -168,15 +189,15 @@ func (m AuthorModel) GetAll(name string, last_name string, filters Filters) ([]*
     )
     AND (to_tsvector('simple', unaccent(a.last_name)) @@ plainto_tsquery('simple', unaccent($2)) OR $2 = '')
     GROUP BY a.id, a.name, a.last_name
-    ORDER by %s %s, a.last_name ASC
-    LIMIT $3 OFFSET $4
-`, filters.sortColumn(), filters.sortDirection())
+`, filters.countColumn(), bookCount, key.expr)
+
+	order := fmt.Sprintf("ORDER by %s %s, a.last_name ASC", filters.sortColumn(), filters.sortDirection())
+
+	query, args := filters.paginate(query, order, key, []any{name, last_name})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{name, last_name, filters.limit(), filters.offset()}
-
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
@@ -185,24 +206,26 @@ func (m AuthorModel) GetAll(name string, last_name string, filters Filters) ([]*
 
 	totalRecords := 0
 	authors := []*Author{}
+	pageKeys := []pageKey{}
 
 	for rows.Next() {
 		var author Author
+		var pk pageKey
 
-		err := rows.Scan(&totalRecords, &author.ID, &author.Name, &author.LastName, &author.Slug, &author.Books)
+		err := rows.Scan(&totalRecords, &author.ID, &author.Name, &author.LastName, &author.Slug, &author.Books, &pk.key, &pk.id)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
 		authors = append(authors, &author)
-
+		pageKeys = append(pageKeys, pk)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	authors, metadata := pageOf(filters, authors, pageKeys, totalRecords)
 
 	return authors, metadata, nil
 }
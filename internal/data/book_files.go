@@ -0,0 +1,194 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrDuplicateFile = errors.New("duplicate file")
+
+// BookFile describes one stored file of a book. Kind is the storage kind the
+// file lives in.
+type BookFile struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// SourceSHA256 is the hash of the PDF as uploaded, before its metadata
+	// was rewritten. It is what duplicate uploads are checked against.
+	SourceSHA256 string `json:"-"`
+}
+
+type BookFileModel struct {
+	DB *sql.DB
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func upsertBookFile(ctx context.Context, db execer, bookID int64, file *BookFile) error {
+	query := `
+    INSERT INTO book_files (book_id, kind, name, size, sha256, source_sha256)
+    VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+    ON CONFLICT (book_id, kind) DO UPDATE
+    SET name = EXCLUDED.name, size = EXCLUDED.size, sha256 = EXCLUDED.sha256,
+        source_sha256 = COALESCE(EXCLUDED.source_sha256, book_files.source_sha256)
+  `
+
+	_, err := db.ExecContext(ctx, query, bookID, file.Kind, file.Name, file.Size, file.SHA256, file.SourceSHA256)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "book_files_pdf_source_idx"`:
+			return ErrDuplicateFile
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Upsert records a file of a book, replacing the previous file of that kind
+func (m BookFileModel) Upsert(bookID int64, file *BookFile) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return upsertBookFile(ctx, m.DB, bookID, file)
+}
+
+func (m BookFileModel) GetForBook(bookID int64) ([]*BookFile, error) {
+	query := `
+    SELECT kind, name, size, sha256, COALESCE(source_sha256, '')
+    FROM book_files
+    WHERE book_id = $1
+    ORDER BY kind
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []*BookFile{}
+
+	for rows.Next() {
+		var file BookFile
+
+		err := rows.Scan(&file.Kind, &file.Name, &file.Size, &file.SHA256, &file.SourceSHA256)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, &file)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// GetForBooks returns the files of each of the books, by book ID
+func (m BookFileModel) GetForBooks(ids []int64) (map[int64][]*BookFile, error) {
+	query := `
+    SELECT book_id, kind, name, size, sha256, COALESCE(source_sha256, '')
+    FROM book_files
+    WHERE book_id = ANY($1)
+    ORDER BY book_id, kind
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := map[int64][]*BookFile{}
+
+	for rows.Next() {
+		var bookID int64
+		var file BookFile
+
+		err := rows.Scan(&bookID, &file.Kind, &file.Name, &file.Size, &file.SHA256, &file.SourceSHA256)
+		if err != nil {
+			return nil, err
+		}
+
+		files[bookID] = append(files[bookID], &file)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// Get returns the file stored under the given kind and name
+func (m BookFileModel) Get(kind string, name string) (*BookFile, error) {
+	query := `
+    SELECT kind, name, size, sha256, COALESCE(source_sha256, '')
+    FROM book_files
+    WHERE kind = $1 AND name = $2
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var file BookFile
+
+	err := m.DB.QueryRowContext(ctx, query, kind, name).Scan(&file.Kind, &file.Name, &file.Size, &file.SHA256, &file.SourceSHA256)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &file, nil
+}
+
+// GetBookByPDFHash finds the book whose PDF has the given SHA-256, either as
+// uploaded or as stored. Only the ID, title and slug of the book are set.
+func (m BookFileModel) GetBookByPDFHash(sha256 string) (*Book, error) {
+	query := `
+    SELECT b.id, b.title, b.slug
+    FROM book_files f
+    JOIN books b ON b.id = f.book_id
+    WHERE f.kind = 'pdfs' AND (f.source_sha256 = $1 OR f.sha256 = $1)
+    LIMIT 1
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var book Book
+
+	err := m.DB.QueryRowContext(ctx, query, sha256).Scan(&book.ID, &book.Title, &book.Slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &book, nil
+}
@@ -0,0 +1,123 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// searchConfig is the text search configuration of the search documents and
+// queries: Spanish stemming that ignores accents
+const searchConfig = "spanish_unaccent"
+
+// refreshSearch recomputes the search document of the books matching where:
+// title A, authors B, description C and the extracted text D.
+func refreshSearch(ctx context.Context, db execer, where string, args ...any) error {
+	query := fmt.Sprintf(`
+    UPDATE books b
+    SET search_document =
+      setweight(to_tsvector('%[1]s', b.title), 'A') ||
+      setweight(to_tsvector('%[1]s', concat_ws(' ',
+        (SELECT concat_ws(' ', a.name, a.last_name) FROM authors a WHERE a.id = b.auth_id),
+        (SELECT concat_ws(' ', a.name, a.last_name) FROM authors a WHERE a.id = b.auth2_id))), 'B') ||
+      setweight(to_tsvector('%[1]s', coalesce(b.description, '')), 'C') ||
+      setweight(to_tsvector('%[1]s', coalesce((SELECT t.body FROM book_texts t WHERE t.book_id = b.id), '')), 'D')
+    WHERE %[2]s
+  `, searchConfig, where)
+
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+type BookTextModel struct {
+	DB *sql.DB
+}
+
+// Set stores the text extracted from a book and updates its search document.
+// Unless replace is set, an existing non-empty text is kept.
+func (m BookTextModel) Set(bookID int64, body string, replace bool) error {
+	query := `
+    INSERT INTO book_texts (book_id, body)
+    VALUES ($1, $2)
+    ON CONFLICT (book_id) DO UPDATE
+    SET body = EXCLUDED.body, updated_at = NOW()
+    WHERE book_texts.body = '' OR $3
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query, bookID, body, replace)
+	if err != nil {
+		return err
+	}
+
+	err = refreshSearch(ctx, tx, "b.id = $1", bookID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Headlines returns a snippet of the description and text of each book with
+// the terms of the search query q marked with <mark>. The rest of the text is
+// HTML-escaped.
+func (b BookModel) Headlines(q string, books []*Book) (map[int64]string, error) {
+	headlines := map[int64]string{}
+
+	if q == "" || len(books) == 0 {
+		return headlines, nil
+	}
+
+	ids := make([]int64, len(books))
+	for i, book := range books {
+		ids[i] = book.ID
+	}
+
+	query := fmt.Sprintf(`
+    SELECT b.id, ts_headline('%[1]s',
+      replace(replace(replace(concat_ws(' ', b.description, left(t.body, 100000)), '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+      websearch_to_tsquery('%[1]s', $2),
+      'StartSel=<mark>, StopSel=</mark>, MinWords=15, MaxWords=35, MaxFragments=2, FragmentDelimiter=" … "')
+    FROM books b
+    LEFT JOIN book_texts t ON t.book_id = b.id
+    WHERE b.id = ANY($1)
+  `, searchConfig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := b.DB.QueryContext(ctx, query, pq.Array(ids), q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var headline string
+
+		err := rows.Scan(&id, &headline)
+		if err != nil {
+			return nil, err
+		}
+
+		headlines[id] = headline
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return headlines, nil
+}
@@ -11,32 +11,53 @@ import (
 	"qumran.jesarx.com/internal/validator"
 )
 
+var ErrDuplicateFilename = errors.New("duplicate filename")
+
+const (
+	FormatPDF  = "pdf"
+	FormatEPUB = "epub"
+)
+
 type Book struct {
-	ID              int64     `json:"id"`
-	CreatedAt       time.Time `json:"-"`
-	Year            int32     `json:"year,omitempty"`
-	Title           string    `json:"title,omitempty"`
-	ShortTitle      string    `json:"short_title,omitempty"`
-	Tags            []string  `json:"tags,omitempty"`
-	AuthorID        int64     `json:"author_id,omitempty"`
-	AuthorName      string    `json:"author_name,omitempty"`
-	AuthorLastName  string    `json:"author_last_name,omitempty"`
-	AuthorSlug      string    `json:"author_slug,omitempty"`
-	Author2ID       *int64    `json:"author2_id,omitempty"`
-	Author2Name     *string   `json:"author2_name,omitempty"`
-	Author2LastName *string   `json:"author2_last_name,omitempty"`
-	Author2Slug     *string   `json:"author2_slug,omitempty"`
-	PublisherID     int64     `json:"publisher_id,omitempty"`
-	PublisherName   string    `json:"publisher_name,omitempty"`
-	PublisherSlug   string    `json:"publisher_slug,omitempty"`
-	DirDwl          bool      `json:"dir_dwl,omitempty"`
-	Slug            string    `json:"slug,omitempty"`
-	Version         int32     `json:"version"`
-	Filename        string    `json:"filename,omitempty"`
-	ISBN            string    `json:"isbn,omitempty"`
-	Description     string    `json:"description,omitempty"`
-	Pages           int32     `json:"pages,omitempty"`
-	ExternalLink    string    `json:"external_link,omitempty"`
+	ID              int64       `json:"id"`
+	CreatedAt       time.Time   `json:"-"`
+	UpdatedAt       time.Time   `json:"-"`
+	Year            int32       `json:"year,omitempty"`
+	Title           string      `json:"title,omitempty"`
+	ShortTitle      string      `json:"short_title,omitempty"`
+	Tags            []string    `json:"tags,omitempty"`
+	AuthorID        int64       `json:"author_id,omitempty"`
+	AuthorName      string      `json:"author_name,omitempty"`
+	AuthorLastName  string      `json:"author_last_name,omitempty"`
+	AuthorSlug      string      `json:"author_slug,omitempty"`
+	Author2ID       *int64      `json:"author2_id,omitempty"`
+	Author2Name     *string     `json:"author2_name,omitempty"`
+	Author2LastName *string     `json:"author2_last_name,omitempty"`
+	Author2Slug     *string     `json:"author2_slug,omitempty"`
+	PublisherID     int64       `json:"publisher_id,omitempty"`
+	PublisherName   string      `json:"publisher_name,omitempty"`
+	PublisherSlug   string      `json:"publisher_slug,omitempty"`
+	DirDwl          bool        `json:"dir_dwl,omitempty"`
+	Slug            string      `json:"slug,omitempty"`
+	Version         int32       `json:"version"`
+	Filename        string      `json:"filename,omitempty"`
+	ISBN            string      `json:"isbn,omitempty"`
+	Description     string      `json:"description,omitempty"`
+	Pages           int32       `json:"pages,omitempty"`
+	ExternalLink    string      `json:"external_link,omitempty"`
+	InfoHash        string      `json:"info_hash,omitempty"`
+	MagnetURI       string      `json:"magnet_uri,omitempty"`
+	Formats         []string    `json:"formats,omitempty"`
+	Files           []*BookFile `json:"files,omitempty"`
+	Covers          []*Cover    `json:"covers,omitempty"`
+	CoverGenerated  bool        `json:"cover_generated,omitempty"`
+	PagesDetected   int32       `json:"pages_detected,omitempty"`
+	HasTextLayer    *bool       `json:"has_text_layer,omitempty"`
+	Language        string      `json:"language,omitempty"`
+	// Headline is the snippet of a full-text search hit
+	Headline string `json:"headline,omitempty"`
+	// Body is the text extracted from the book's files, stored on insert
+	Body string `json:"-"`
 }
 
 func ValidateBook(v *validator.Validator, book *Book) {
@@ -61,16 +82,82 @@ type BookModel struct {
 	DB *sql.DB
 }
 
+// Insert inserts the book with its Files and Covers in one transaction. The
+// files themselves must already be stored.
 func (b BookModel) Insert(book *Book) error {
 	query := `
-    INSERT INTO books (title, short_title, year, tags, auth_id, auth2_id, pub_id, filename, isbn, description, pages, external_link)
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+    INSERT INTO books (title, short_title, year, tags, auth_id, auth2_id, pub_id, filename, isbn, description, pages, external_link, info_hash, formats, cover_generated, pages_detected, has_text_layer, language)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13, ''), $14, $15, $16, $17, $18)
     RETURNING id, created_at
   `
 
-	args := []any{book.Title, book.ShortTitle, book.Year, pq.Array(book.Tags), book.AuthorID, book.Author2ID, book.PublisherID, book.Filename, book.ISBN, book.Description, book.Pages, book.ExternalLink}
+	if book.Formats == nil {
+		book.Formats = []string{}
+	}
 
-	return b.DB.QueryRow(query, args...).Scan(&book.ID, &book.CreatedAt)
+	args := []any{book.Title, book.ShortTitle, book.Year, pq.Array(book.Tags), book.AuthorID, book.Author2ID, book.PublisherID, book.Filename, book.ISBN, book.Description, book.Pages, book.ExternalLink, book.InfoHash, pq.Array(book.Formats), book.CoverGenerated, book.PagesDetected, book.HasTextLayer, book.Language}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&book.ID, &book.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "books_filename_key"`:
+			return ErrDuplicateFilename
+		default:
+			return err
+		}
+	}
+
+	for _, file := range book.Files {
+		err = upsertBookFile(ctx, tx, book.ID, file)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertCovers(ctx, tx, book.ID, book.Covers)
+	if err != nil {
+		return err
+	}
+
+	if book.Body != "" {
+		_, err = tx.ExecContext(ctx, `INSERT INTO book_texts (book_id, body) VALUES ($1, $2)`, book.ID, book.Body)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = refreshSearch(ctx, tx, "b.id = $1", book.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// FilenameTaken reports whether a book already uses the given filename
+func (b BookModel) FilenameTaken(filename string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM books WHERE filename = $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var taken bool
+
+	err := b.DB.QueryRowContext(ctx, query, filename).Scan(&taken)
+	if err != nil {
+		return false, err
+	}
+
+	return taken, nil
 }
 
 func (b BookModel) GetByID(id int64) (*Book, error) {
@@ -93,7 +180,13 @@ func (b BookModel) GetByID(id int64) (*Book, error) {
       p.name AS publisher_name,
       b.version,
       b.slug,
-      b.filename
+      b.filename,
+      COALESCE(b.info_hash, ''),
+      b.formats,
+      b.cover_generated,
+      b.pages_detected,
+      b.has_text_layer,
+      b.language
     FROM 
       books b
     JOIN 
@@ -107,7 +200,7 @@ func (b BookModel) GetByID(id int64) (*Book, error) {
 	var book Book
 
 	err := b.DB.QueryRow(query, id).Scan(
-		&book.ID, &book.CreatedAt, &book.Title, &book.ShortTitle, &book.Year, pq.Array(&book.Tags), &book.AuthorID, &book.AuthorName, &book.AuthorLastName, &book.PublisherID, &book.PublisherName, &book.Version, &book.Slug, &book.Filename,
+		&book.ID, &book.CreatedAt, &book.Title, &book.ShortTitle, &book.Year, pq.Array(&book.Tags), &book.AuthorID, &book.AuthorName, &book.AuthorLastName, &book.PublisherID, &book.PublisherName, &book.Version, &book.Slug, &book.Filename, &book.InfoHash, pq.Array(&book.Formats), &book.CoverGenerated, &book.PagesDetected, &book.HasTextLayer, &book.Language,
 	)
 	if err != nil {
 		switch {
@@ -130,6 +223,7 @@ func (b BookModel) GetBySlug(slug string) (*Book, error) {
     SELECT 
   b.id, 
   b.created_at, 
+  b.updated_at, 
   b.title, 
   b.short_title, 
   b.year, 
@@ -152,7 +246,13 @@ func (b BookModel) GetBySlug(slug string) (*Book, error) {
   b.pages,
   b.isbn,
   b.external_link,
-  b.dir_dwl
+  b.dir_dwl,
+  COALESCE(b.info_hash, ''),
+  b.formats,
+  b.cover_generated,
+  b.pages_detected,
+  b.has_text_layer,
+  b.language
 FROM 
   books b
 JOIN 
@@ -167,9 +267,9 @@ WHERE
 
 	var book Book
 	err := b.DB.QueryRow(query, slug).Scan(
-		&book.ID, &book.CreatedAt, &book.Title, &book.ShortTitle, &book.Year, pq.Array(&book.Tags),
+		&book.ID, &book.CreatedAt, &book.UpdatedAt, &book.Title, &book.ShortTitle, &book.Year, pq.Array(&book.Tags),
 		&book.AuthorID, &book.AuthorName, &book.AuthorLastName, &book.AuthorSlug, &book.Author2ID, &book.Author2Name, &book.Author2LastName, &book.Author2Slug, &book.PublisherID,
-		&book.PublisherName, &book.PublisherSlug, &book.Version, &book.Slug, &book.Filename, &book.Description, &book.Pages, &book.ISBN, &book.ExternalLink, &book.DirDwl,
+		&book.PublisherName, &book.PublisherSlug, &book.Version, &book.Slug, &book.Filename, &book.Description, &book.Pages, &book.ISBN, &book.ExternalLink, &book.DirDwl, &book.InfoHash, pq.Array(&book.Formats), &book.CoverGenerated, &book.PagesDetected, &book.HasTextLayer, &book.Language,
 	)
 	if err != nil {
 		switch {
@@ -183,6 +283,8 @@ WHERE
 	return &book, nil
 }
 
+// Update updates the book in one transaction. The Files of the book are
+// recorded, and its Covers replace the current renditions unless they are nil.
 func (b BookModel) Update(book *Book) error {
 	query := `
     UPDATE books
@@ -200,8 +302,10 @@ func (b BookModel) Update(book *Book) error {
         pages = $11,
         dir_dwl = $12,
         external_link = $13,
+        cover_generated = $14,
+        updated_at = NOW(),
         version = version + 1
-    WHERE id = $14 AND version = $15
+    WHERE id = $15 AND version = $16
     RETURNING version
   `
 	args := []any{
@@ -218,10 +322,21 @@ func (b BookModel) Update(book *Book) error {
 		book.Pages,
 		book.DirDwl,
 		book.ExternalLink,
+		book.CoverGenerated,
 		book.ID,
 		book.Version,
 	}
-	err := b.DB.QueryRow(query, args...).Scan(&book.Version)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&book.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -230,9 +345,147 @@ func (b BookModel) Update(book *Book) error {
 			return err
 		}
 	}
+
+	for _, file := range book.Files {
+		err = upsertBookFile(ctx, tx, book.ID, file)
+		if err != nil {
+			return err
+		}
+	}
+
+	if book.Covers != nil {
+		err = replaceCovers(ctx, tx, book.ID, book.Covers)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = refreshSearch(ctx, tx, "b.id = $1", book.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateAnalysis records what was detected from the book's PDF. The page count
+// only replaces the typed one when it is missing.
+func (b BookModel) UpdateAnalysis(id int64, pages int32, hasTextLayer *bool, language string) error {
+	query := `
+    UPDATE books
+    SET pages_detected = $1,
+        pages = CASE WHEN pages = 0 THEN $1 ELSE pages END,
+        has_text_layer = $2,
+        language = $3,
+        updated_at = NOW()
+    WHERE id = $4
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := b.DB.ExecContext(ctx, query, pages, hasTextLayer, language, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
 	return nil
 }
 
+func (b BookModel) UpdateInfoHash(id int64, infoHash string) error {
+	query := `
+    UPDATE books
+    SET info_hash = $1, updated_at = NOW()
+    WHERE id = $2
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := b.DB.ExecContext(ctx, query, infoHash, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// AddFormat records that the book is available in the given format
+func (b BookModel) AddFormat(id int64, format string) error {
+	query := `
+    UPDATE books
+    SET formats = array_append(formats, $1), updated_at = NOW()
+    WHERE id = $2 AND NOT ($1 = ANY(formats))
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := b.DB.ExecContext(ctx, query, format, id)
+	return err
+}
+
+// GetAllWithFiles returns every book that has uploaded files, with the fields
+// needed to regenerate or check its assets.
+func (b BookModel) GetAllWithFiles() ([]*Book, error) {
+	query := `
+    SELECT b.id, b.title, b.short_title, b.filename, COALESCE(b.info_hash, ''), b.slug, b.formats, b.pages_detected,
+           COALESCE(a.name, ''), a.last_name, p.name
+    FROM books b
+    JOIN authors a ON b.auth_id = a.id
+    JOIN publishers p ON b.pub_id = p.id
+    WHERE b.filename IS NOT NULL AND b.filename <> ''
+    ORDER BY b.id
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := b.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := []*Book{}
+
+	for rows.Next() {
+		var book Book
+
+		err := rows.Scan(&book.ID, &book.Title, &book.ShortTitle, &book.Filename, &book.InfoHash, &book.Slug, pq.Array(&book.Formats), &book.PagesDetected,
+			&book.AuthorName, &book.AuthorLastName, &book.PublisherName)
+		if err != nil {
+			return nil, err
+		}
+
+		books = append(books, &book)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return books, nil
+}
+
 func (b BookModel) Delete(id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
@@ -259,20 +512,138 @@ func (b BookModel) Delete(id int64) error {
 	return nil
 }
 
-func (b BookModel) GetAll(title string, authslug string, pubslug string, tags []string, filters Filters) ([]*Book, Metadata, error) {
+// Tag modes of BookFilter: books with all of the tags or with any of them
+const (
+	TagModeAll = "and"
+	TagModeAny = "or"
+)
+
+// YearRange is an inclusive range of publication years
+type YearRange struct {
+	From int32
+	To   int32
+}
+
+// BookFilter selects the books listed by GetAll and counted by Facets. Empty
+// fields don't filter; a book matches a list when it matches any of its
+// values, except for Tags in TagModeAll.
+type BookFilter struct {
+	// Title searches the title only
+	Title string
+	// Query is a full-text search over the title, authors, description and
+	// text of the books, in web search syntax
+	Query          string
+	Tags           []string
+	TagMode        string
+	AuthorSlugs    []string
+	PublisherSlugs []string
+	Languages      []string
+	Formats        []string
+	Years          []YearRange
+	HasTextLayer   *bool
+}
+
+// bookFilterArgs is the number of query arguments taken by BookFilter.clause
+const bookFilterArgs = 11
+
+// clause returns the FROM and WHERE clauses selecting the books of the filter,
+// with their arguments as $1 to $11. The search query is $8.
+func (f BookFilter) clause() (string, []any) {
+	clause := fmt.Sprintf(`
+    FROM 
+        books b
+    JOIN 
+        authors a ON b.auth_id = a.id
+    LEFT JOIN
+        authors a2 ON b.auth2_id = a2.id
+    JOIN 
+        publishers p ON b.pub_id = p.id
+    WHERE 
+        (to_tsvector('spanish', unaccent(b.title)) @@ plainto_tsquery('spanish', unaccent($1)) OR $1 = '') 
+        AND (cardinality($2::text[]) = 0 OR (NOT $3 AND b.tags @> $2) OR ($3 AND b.tags && $2))
+        AND (cardinality($4::text[]) = 0 OR a.slug = ANY($4) OR a2.slug = ANY($4))
+        AND (cardinality($5::text[]) = 0 OR p.slug = ANY($5))
+        AND (cardinality($6::text[]) = 0 OR b.language = ANY($6))
+        AND ($7::boolean IS NULL OR b.has_text_layer = $7)
+        AND ($8 = '' OR b.search_document @@ websearch_to_tsquery('%s', $8))
+        AND (cardinality($9::text[]) = 0 OR b.formats && $9)
+        AND (cardinality($10::integer[]) = 0 OR EXISTS (
+            SELECT 1 FROM unnest($10::integer[], $11::integer[]) AS r(year_from, year_to)
+            WHERE b.year BETWEEN r.year_from AND r.year_to))
+`, searchConfig)
+
+	yearsFrom := make([]int64, len(f.Years))
+	yearsTo := make([]int64, len(f.Years))
+	for i, r := range f.Years {
+		yearsFrom[i], yearsTo[i] = int64(r.From), int64(r.To)
+	}
+
+	args := []any{
+		f.Title,
+		pq.Array(nonNil(f.Tags)),
+		f.TagMode == TagModeAny,
+		pq.Array(nonNil(f.AuthorSlugs)),
+		pq.Array(nonNil(f.PublisherSlugs)),
+		pq.Array(nonNil(f.Languages)),
+		f.HasTextLayer,
+		f.Query,
+		pq.Array(nonNil(f.Formats)),
+		pq.Array(yearsFrom),
+		pq.Array(yearsTo),
+	}
+
+	return clause, args
+}
+
+// nonNil makes nil slices encode as empty arrays instead of NULL
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// GetAll lists the books matching filter. Sort "relevance" orders by how well
+// books match the filter's search query.
+func (b BookModel) GetAll(filter BookFilter, filters Filters) ([]*Book, Metadata, error) {
+	filters = filters.withSeed()
+
+	keys := map[string]sortKey{
+		"id":         {expr: "b.id", typ: "bigint"},
+		"title":      {expr: "b.title", typ: "text"},
+		"year":       {expr: "b.year", typ: "integer"},
+		"tags":       {expr: "b.tags", typ: "text[]"},
+		"created_at": {expr: "b.created_at", typ: "timestamptz"},
+		"random":     {expr: filters.randomKey("b.id"), typ: "text"},
+		"relevance":  {expr: "b.created_at", typ: "timestamptz", desc: true},
+	}
+
 	var orderClause string
 	if filters.Sort == "random" {
-		orderClause = "ORDER BY random()"
+		orderClause = fmt.Sprintf("ORDER BY %s", keys["random"].expr)
+	} else if filters.Sort == "relevance" {
+		if filter.Query == "" {
+			orderClause = "ORDER BY b.created_at DESC, b.title ASC"
+		} else {
+			rank := fmt.Sprintf("ts_rank(b.search_document, websearch_to_tsquery('%s', $8))", searchConfig)
+			keys["relevance"] = sortKey{expr: rank, typ: "real", desc: true}
+			orderClause = fmt.Sprintf("ORDER BY %s DESC, b.id DESC", rank)
+		}
 	} else {
 		orderClause = fmt.Sprintf("ORDER BY %s %s, b.title ASC",
 			filters.sortColumn(), filters.sortDirection())
 	}
 
+	key := keys[filters.sortColumn()]
+
+	clause, args := filter.clause()
+
 	query := fmt.Sprintf(`
     SELECT 
-        count(*) OVER(),
+        %s,
         b.id, 
         b.created_at, 
+        b.updated_at,
         b.title, 
         b.short_title, 
         b.auth_id, 
@@ -291,29 +662,23 @@ func (b BookModel) GetAll(title string, authslug string, pubslug string, tags []
         a2.slug AS author2_slug,
         p.name AS publisher_name,
         p.slug AS publisher_slug,
-        b.dir_dwl
-    FROM 
-        books b
-    JOIN 
-        authors a ON b.auth_id = a.id
-    LEFT JOIN
-        authors a2 ON b.auth2_id = a2.id
-    JOIN 
-        publishers p ON b.pub_id = p.id
-    WHERE 
-        (to_tsvector('spanish', unaccent(b.title)) @@ plainto_tsquery('spanish', unaccent($1)) OR $1 = '') 
-        AND (b.tags @> $2 OR $2 = '{}')
-        AND ($5 = '' OR a.slug = $5 OR a2.slug = $5)
-        AND ($6 = '' OR p.slug = $6)
+        b.dir_dwl,
+        COALESCE(b.info_hash, ''),
+        b.formats,
+        b.cover_generated,
+        b.pages_detected,
+        b.has_text_layer,
+        b.language,
+        (%s)::text AS sort_key,
+        b.id AS sort_id
     %s
-    LIMIT $3 OFFSET $4
-`, orderClause)
+`, filters.countColumn(), key.expr, clause)
+
+	query, args = filters.paginate(query, orderClause, key, args)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{title, pq.Array(tags), filters.limit(), filters.offset(), authslug, pubslug}
-
 	rows, err := b.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
@@ -323,14 +688,17 @@ func (b BookModel) GetAll(title string, authslug string, pubslug string, tags []
 
 	totalRecords := 0
 	books := []*Book{}
+	pageKeys := []pageKey{}
 
 	for rows.Next() {
 		var book Book
+		var pk pageKey
 
 		err := rows.Scan(
 			&totalRecords,
 			&book.ID,
 			&book.CreatedAt,
+			&book.UpdatedAt,
 			&book.Title,
 			&book.ShortTitle,
 			&book.AuthorID,
@@ -349,20 +717,28 @@ func (b BookModel) GetAll(title string, authslug string, pubslug string, tags []
 			&book.Author2Slug,
 			&book.PublisherName,
 			&book.PublisherSlug,
-			&book.DirDwl)
+			&book.DirDwl,
+			&book.InfoHash,
+			pq.Array(&book.Formats),
+			&book.CoverGenerated,
+			&book.PagesDetected,
+			&book.HasTextLayer,
+			&book.Language,
+			&pk.key,
+			&pk.id)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
 		books = append(books, &book)
-
+		pageKeys = append(pageKeys, pk)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	books, metadata := pageOf(filters, books, pageKeys, totalRecords)
 
 	return books, metadata, nil
 }
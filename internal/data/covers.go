@@ -0,0 +1,152 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Cover is a resized rendition of a book cover. Size is the nominal width it
+// is requested by, Width and Height its real dimensions.
+type Cover struct {
+	Size   int    `json:"size"`
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	URL    string `json:"url"`
+	Name   string `json:"-"`
+	Bytes  int64  `json:"-"`
+	SHA256 string `json:"-"`
+}
+
+type CoverModel struct {
+	DB *sql.DB
+}
+
+const coverColumns = `size, format, name, width, height, bytes, sha256`
+
+func insertCovers(ctx context.Context, db execer, bookID int64, covers []*Cover) error {
+	query := `
+    INSERT INTO book_covers (book_id, size, format, name, width, height, bytes, sha256)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+  `
+
+	for _, cover := range covers {
+		_, err := db.ExecContext(ctx, query, bookID, cover.Size, cover.Format, cover.Name, cover.Width, cover.Height, cover.Bytes, cover.SHA256)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Replace swaps the renditions of a book for new ones
+func (m CoverModel) Replace(bookID int64, covers []*Cover) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replaceCovers(ctx, tx, bookID, covers)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceCovers(ctx context.Context, db execer, bookID int64, covers []*Cover) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM book_covers WHERE book_id = $1`, bookID)
+	if err != nil {
+		return err
+	}
+
+	return insertCovers(ctx, db, bookID, covers)
+}
+
+// GetForBooks returns the renditions of several books, keyed by book ID and
+// sorted by size
+func (m CoverModel) GetForBooks(bookIDs []int64) (map[int64][]*Cover, error) {
+	query := `
+    SELECT book_id, ` + coverColumns + `
+    FROM book_covers
+    WHERE book_id = ANY($1)
+    ORDER BY book_id, size, format
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(bookIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	covers := map[int64][]*Cover{}
+
+	for rows.Next() {
+		var bookID int64
+		var cover Cover
+
+		err := rows.Scan(&bookID, &cover.Size, &cover.Format, &cover.Name, &cover.Width, &cover.Height, &cover.Bytes, &cover.SHA256)
+		if err != nil {
+			return nil, err
+		}
+
+		covers[bookID] = append(covers[bookID], &cover)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return covers, nil
+}
+
+// GetForFilename returns the renditions of the book with the given filename,
+// sorted by size
+func (m CoverModel) GetForFilename(filename string) ([]*Cover, error) {
+	query := `
+    SELECT ` + coverColumns + `
+    FROM book_covers c
+    JOIN books b ON b.id = c.book_id
+    WHERE b.filename = $1
+    ORDER BY size, format
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	covers := []*Cover{}
+
+	for rows.Next() {
+		var cover Cover
+
+		err := rows.Scan(&cover.Size, &cover.Format, &cover.Name, &cover.Width, &cover.Height, &cover.Bytes, &cover.SHA256)
+		if err != nil {
+			return nil, err
+		}
+
+		covers = append(covers, &cover)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return covers, nil
+}
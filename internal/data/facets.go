@@ -0,0 +1,122 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Facets of the book list
+const (
+	FacetTag       = "tags"
+	FacetDecade    = "decade"
+	FacetPublisher = "publisher"
+	FacetAuthor    = "author"
+	FacetLanguage  = "language"
+	FacetFormat    = "format"
+)
+
+var FacetNames = []string{FacetTag, FacetDecade, FacetPublisher, FacetAuthor, FacetLanguage, FacetFormat}
+
+// FacetValue is a value of a facet and the number of books that have it.
+// Value is what the matching filter takes: the slug of authors and
+// publishers, whose names are in Label, and the first year of decades.
+type FacetValue struct {
+	Value string `json:"value"`
+	Label string `json:"label,omitempty"`
+	Count int    `json:"count"`
+}
+
+// Facets counts the values of the named facets among the books matching
+// filter. Each facet lists up to limit values, the most frequent first.
+func (b BookModel) Facets(filter BookFilter, names []string, limit int) (map[string][]*FacetValue, error) {
+	facets := map[string][]*FacetValue{}
+
+	if len(names) == 0 {
+		return facets, nil
+	}
+
+	for _, name := range names {
+		facets[name] = []*FacetValue{}
+	}
+
+	clause, args := filter.clause()
+
+	query := fmt.Sprintf(`
+    WITH filtered AS (
+        SELECT b.tags, b.year, b.pub_id, b.auth_id, b.auth2_id, b.language, b.formats
+        %[1]s
+    ),
+    counts AS (
+        SELECT '%[2]s' AS facet, t.tag AS value, '' AS label, count(*) AS count
+        FROM filtered f, unnest(f.tags) AS t(tag)
+        WHERE '%[2]s' = ANY($%[8]d::text[])
+        GROUP BY t.tag
+        UNION ALL
+        SELECT '%[3]s', ((f.year / 10) * 10)::text, '', count(*)
+        FROM filtered f
+        WHERE '%[3]s' = ANY($%[8]d::text[])
+        GROUP BY f.year / 10
+        UNION ALL
+        SELECT '%[4]s', p.slug, p.name, count(*)
+        FROM filtered f
+        JOIN publishers p ON p.id = f.pub_id
+        WHERE '%[4]s' = ANY($%[8]d::text[])
+        GROUP BY p.slug, p.name
+        UNION ALL
+        SELECT '%[5]s', a.slug, concat_ws(' ', a.name, a.last_name), count(*)
+        FROM filtered f
+        JOIN authors a ON a.id = f.auth_id OR a.id = f.auth2_id
+        WHERE '%[5]s' = ANY($%[8]d::text[])
+        GROUP BY a.slug, a.name, a.last_name
+        UNION ALL
+        SELECT '%[6]s', f.language, '', count(*)
+        FROM filtered f
+        WHERE '%[6]s' = ANY($%[8]d::text[]) AND f.language <> ''
+        GROUP BY f.language
+        UNION ALL
+        SELECT '%[7]s', fm.format, '', count(*)
+        FROM filtered f, unnest(f.formats) AS fm(format)
+        WHERE '%[7]s' = ANY($%[8]d::text[])
+        GROUP BY fm.format
+    )
+    SELECT facet, value, label, count
+    FROM (
+        SELECT *, row_number() OVER (PARTITION BY facet ORDER BY count DESC, value ASC) AS n
+        FROM counts
+    ) ranked
+    WHERE n <= $%[9]d
+    ORDER BY facet, n
+`, clause, FacetTag, FacetDecade, FacetPublisher, FacetAuthor, FacetLanguage, FacetFormat, bookFilterArgs+1, bookFilterArgs+2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args = append(args, pq.Array(names), limit)
+
+	rows, err := b.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var value FacetValue
+
+		err := rows.Scan(&name, &value.Value, &value.Label, &value.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		facets[name] = append(facets[name], &value)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return facets, nil
+}
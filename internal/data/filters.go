@@ -1,7 +1,12 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"math"
+	"math/rand/v2"
+	"slices"
 	"strings"
 
 	"qumran.jesarx.com/internal/validator"
@@ -12,14 +17,25 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	// UseCursor pages by Cursor instead of Page. An empty Cursor is the first
+	// page.
+	UseCursor bool
+	Cursor    string
+	// Seed orders sort "random" the same way on every page
+	Seed int64
+	// SkipCount leaves the total number of records out of the metadata
+	SkipCount bool
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
+	Seed         int64  `json:"seed,omitempty"`
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
@@ -68,4 +84,187 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize <= 100, "page_size", "must be a mazimum of 100")
 
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.Check(f.Seed >= 0, "seed", "must not be negative")
+
+	if f.UseCursor && f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			v.AddError("cursor", "is invalid")
+		} else {
+			v.Check(c.Sort == f.Sort, "cursor", "belongs to another sort")
+		}
+	}
+}
+
+// cursor points at the last row of a page, or the first one when Prev is set,
+// for the next request to continue from. It is handed out base64-encoded.
+type cursor struct {
+	Sort string `json:"s"`
+	Seed int64  `json:"r,omitempty"`
+	Key  string `json:"k"`
+	ID   int64  `json:"i"`
+	Prev bool   `json:"p,omitempty"`
+}
+
+func encodeCursor(c cursor) string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+
+	err = json.Unmarshal(js, &c)
+	return c, err
+}
+
+// cursor returns the decoded Cursor, or the zero cursor on the first page
+func (f Filters) cursor() cursor {
+	if !f.UseCursor || f.Cursor == "" {
+		return cursor{}
+	}
+
+	c, _ := decodeCursor(f.Cursor)
+	return c
+}
+
+// withSeed fills Seed for sort "random", from the cursor when there is one
+func (f Filters) withSeed() Filters {
+	if f.Sort != "random" {
+		return f
+	}
+
+	if c := f.cursor(); c.Seed != 0 {
+		f.Seed = c.Seed
+	}
+
+	if f.Seed == 0 {
+		f.Seed = rand.Int64N(math.MaxInt32) + 1
+	}
+
+	return f
+}
+
+// randomKey is the sort key of sort "random": a hash of the row id salted
+// with the seed
+func (f Filters) randomKey(id string) string {
+	return fmt.Sprintf("md5(%s::text || '%d')", id, f.Seed)
+}
+
+// countColumn is the SQL of the total number of records of a listing
+func (f Filters) countColumn() string {
+	if f.SkipCount {
+		return "0"
+	}
+	return "count(*) OVER()"
+}
+
+// sortKey is a column that a listing can be paged through with cursors: its
+// SQL, its type and whether it always sorts descending.
+type sortKey struct {
+	expr string
+	typ  string
+	desc bool
+}
+
+// paginate returns the query of the requested page. base selects every row of
+// the listing, ending with the sort key as text in sort_key and the unique id
+// in sort_id; order is the ORDER BY clause of numbered pages. Cursor pages are
+// ordered by the key and the id, and fetch one row more to tell if there are
+// more.
+func (f Filters) paginate(base string, order string, key sortKey, args []any) (string, []any) {
+	if !f.UseCursor {
+		query := fmt.Sprintf("%s %s LIMIT $%d OFFSET $%d", base, order, len(args)+1, len(args)+2)
+		return query, append(args, f.limit(), f.offset())
+	}
+
+	c := f.cursor()
+
+	asc := f.sortDirection() == "ASC" && !key.desc
+	// Pages before the cursor are read backwards and reversed afterwards
+	if c.Prev {
+		asc = !asc
+	}
+
+	dir, op := "ASC", ">"
+	if !asc {
+		dir, op = "DESC", "<"
+	}
+
+	where := "TRUE"
+	if f.Cursor != "" {
+		where = fmt.Sprintf("(page.sort_key::%[1]s, page.sort_id) %[2]s ($%[3]d::%[1]s, $%[4]d)", key.typ, op, len(args)+1, len(args)+2)
+		args = append(args, c.Key, c.ID)
+	}
+
+	query := fmt.Sprintf(`
+    SELECT * FROM (%s) page
+    WHERE %s
+    ORDER BY page.sort_key::%s %s, page.sort_id %s
+    LIMIT $%d
+`, base, where, key.typ, dir, dir, len(args)+1)
+
+	return query, append(args, f.limit()+1)
+}
+
+// pageKey is the sort_key and sort_id of a row
+type pageKey struct {
+	key string
+	id  int64
+}
+
+// pageOf trims and orders the rows of a page fetched with a query from
+// paginate and returns them with the metadata of the page. keys are those of
+// items.
+func pageOf[T any](f Filters, items []T, keys []pageKey, totalRecords int) ([]T, Metadata) {
+	if !f.UseCursor {
+		metadata := calculateMetadata(totalRecords, f.Page, f.PageSize)
+		if f.SkipCount {
+			metadata = Metadata{CurrentPage: f.Page, PageSize: f.PageSize, FirstPage: 1}
+		}
+		metadata.Seed = f.Seed
+		return items, metadata
+	}
+
+	c := f.cursor()
+
+	more := len(items) > f.PageSize
+	if more {
+		items, keys = items[:f.PageSize], keys[:f.PageSize]
+	}
+
+	if c.Prev {
+		slices.Reverse(items)
+		slices.Reverse(keys)
+	}
+
+	// Going forward there is a page before unless this is the first one;
+	// going back there is always one after
+	hasNext, hasPrev := more, f.Cursor != ""
+	if c.Prev {
+		hasNext, hasPrev = true, more
+	}
+
+	metadata := Metadata{PageSize: f.PageSize, Seed: f.Seed}
+	if !f.SkipCount {
+		metadata.TotalRecords = totalRecords
+	}
+
+	if len(keys) > 0 {
+		if hasNext {
+			last := keys[len(keys)-1]
+			metadata.NextCursor = encodeCursor(cursor{Sort: f.Sort, Seed: f.Seed, Key: last.key, ID: last.id})
+		}
+		if hasPrev {
+			first := keys[0]
+			metadata.PrevCursor = encodeCursor(cursor{Sort: f.Sort, Seed: f.Seed, Key: first.key, ID: first.id, Prev: true})
+		}
+	}
+
+	return items, metadata
 }
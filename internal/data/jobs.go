@@ -0,0 +1,248 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobRetrying  = "retrying"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// ErrLeaseLost is returned when a job is no longer held by the worker updating
+// it.
+var ErrLeaseLost = errors.New("job lease lost")
+
+const (
+	JobKindBookIngest = "book_ingest"
+	JobKindBookEPUB   = "book_epub"
+)
+
+type Job struct {
+	ID          int64           `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"-"`
+	Status      string          `json:"status"`
+	Stage       string          `json:"stage,omitempty"`
+	Progress    int             `json:"progress"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	UserID      *int64          `json:"user_id,omitempty"`
+}
+
+type JobModel struct {
+	DB *sql.DB
+}
+
+const jobColumns = `id, created_at, updated_at, kind, payload, status, stage, progress, attempts, max_attempts, run_at, last_error, result, user_id`
+
+func scanJob(row interface{ Scan(...any) error }) (*Job, error) {
+	var job Job
+	var result []byte
+
+	err := row.Scan(&job.ID, &job.CreatedAt, &job.UpdatedAt, &job.Kind, &job.Payload, &job.Status, &job.Stage,
+		&job.Progress, &job.Attempts, &job.MaxAttempts, &job.RunAt, &job.LastError, &result, &job.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if result != nil {
+		job.Result = result
+	}
+
+	return &job, nil
+}
+
+func (m JobModel) Insert(job *Job) error {
+	query := `
+    INSERT INTO jobs (kind, payload, max_attempts, user_id)
+    VALUES ($1, $2, $3, $4)
+    RETURNING ` + jobColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	inserted, err := scanJob(m.DB.QueryRowContext(ctx, query, job.Kind, []byte(job.Payload), job.MaxAttempts, job.UserID))
+	if err != nil {
+		return err
+	}
+
+	*job = *inserted
+
+	return nil
+}
+
+func (m JobModel) Get(id int64) (*Job, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanJob(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// Claim marks the next runnable job as running and returns it. Concurrent
+// workers skip rows locked by each other, so every job is claimed once.
+func (m JobModel) Claim() (*Job, error) {
+	query := `
+    UPDATE jobs
+    SET status = 'running', attempts = attempts + 1, updated_at = NOW()
+    WHERE id = (
+        SELECT id FROM jobs
+        WHERE status IN ('queued', 'retrying') AND run_at <= NOW()
+        ORDER BY run_at, id
+        FOR UPDATE SKIP LOCKED
+        LIMIT 1
+    )
+    RETURNING ` + jobColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanJob(m.DB.QueryRowContext(ctx, query))
+}
+
+// The updates of a running job only apply while the caller still holds it:
+// the job must still be running the attempt the caller claimed. Once its lease
+// runs out it may have been requeued, or claimed again by another worker, and
+// they fail with ErrLeaseLost.
+
+func (m JobModel) UpdateProgress(job *Job, progress int, stage string) error {
+	query := `
+    UPDATE jobs
+    SET progress = $1, stage = $2, updated_at = NOW()
+    WHERE id = $3 AND status = 'running' AND attempts = $4
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, progress, stage, job.ID, job.Attempts)
+	return checkLease(result, err)
+}
+
+func (m JobModel) Complete(job *Job, result any) error {
+	js, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	query := `
+    UPDATE jobs
+    SET status = 'succeeded', progress = 100, stage = 'done', last_error = '', result = $1, updated_at = NOW()
+    WHERE id = $2 AND status = 'running' AND attempts = $3
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, js, job.ID, job.Attempts)
+	return checkLease(res, err)
+}
+
+// Retry puts a failed job back in the queue to run again at runAt.
+func (m JobModel) Retry(job *Job, lastError string, runAt time.Time) error {
+	query := `
+    UPDATE jobs
+    SET status = 'retrying', last_error = $1, run_at = $2, updated_at = NOW()
+    WHERE id = $3 AND status = 'running' AND attempts = $4
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, lastError, runAt, job.ID, job.Attempts)
+	return checkLease(result, err)
+}
+
+func (m JobModel) Fail(job *Job, lastError string) error {
+	query := `
+    UPDATE jobs
+    SET status = 'failed', last_error = $1, updated_at = NOW()
+    WHERE id = $2 AND status = 'running' AND attempts = $3
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, lastError, job.ID, job.Attempts)
+	return checkLease(result, err)
+}
+
+// Heartbeat records that a running job is still being worked on, renewing
+// its lease
+func (m JobModel) Heartbeat(job *Job) error {
+	query := `
+    UPDATE jobs
+    SET updated_at = NOW()
+    WHERE id = $1 AND status = 'running' AND attempts = $2
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, job.ID, job.Attempts)
+	return checkLease(result, err)
+}
+
+// checkLease turns an update of a running job that matched no row into
+// ErrLeaseLost
+func checkLease(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrLeaseLost
+	}
+
+	return nil
+}
+
+// RequeueInterrupted returns to the queue the running jobs that have not been
+// updated for longer than lease. Those were left behind by a process that died
+// without draining its workers; jobs other processes are still working on
+// keep being renewed by Heartbeat.
+func (m JobModel) RequeueInterrupted(lease time.Duration) (int64, error) {
+	query := `
+    UPDATE jobs
+    SET status = 'retrying', last_error = 'interrupted', run_at = NOW(), updated_at = NOW()
+    WHERE status = 'running' AND updated_at < NOW() - $1 * INTERVAL '1 second'
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, lease.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
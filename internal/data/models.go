@@ -1,8 +1,10 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 var (
@@ -11,23 +13,64 @@ var (
 )
 
 type Models struct {
+	APIKeys     APIKeyModel
+	AuditLog    AuditLogModel
 	Books       BookModel
+	BookFiles   BookFileModel
+	BookTexts   BookTextModel
+	Covers      CoverModel
 	Authors     AuthorModel
 	Publishers  PublisherModel
+	Search      SearchModel
+	Sitemaps    SitemapModel
 	Tags        TagModel
+	Jobs        JobModel
 	Permissions PermissionModel
 	Tokens      TokenModel
+	Uploads     UploadModel
 	Users       UserModel
+
+	db *sql.DB
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
+		APIKeys:     APIKeyModel{DB: db},
+		AuditLog:    AuditLogModel{DB: db},
 		Books:       BookModel{DB: db},
+		BookFiles:   BookFileModel{DB: db},
+		BookTexts:   BookTextModel{DB: db},
+		Covers:      CoverModel{DB: db},
 		Authors:     AuthorModel{DB: db},
 		Publishers:  PublisherModel{DB: db},
+		Search:      SearchModel{DB: db},
+		Sitemaps:    SitemapModel{DB: db},
 		Tags:        TagModel{DB: db},
+		Jobs:        JobModel{DB: db},
 		Permissions: PermissionModel{DB: db},
 		Tokens:      TokenModel{DB: db},
+		Uploads:     UploadModel{DB: db},
 		Users:       UserModel{DB: db},
+		db:          db,
+	}
+}
+
+// Transaction runs fn inside a database transaction and commits it when fn
+// succeeds, so that the model calls fn makes with tx take effect together
+func (m Models) Transaction(fn func(tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
 	}
+
+	return tx.Commit()
 }
@@ -61,14 +61,78 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 }
 
 func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return addPermissionsForUser(ctx, m.DB, userID, codes)
+}
+
+// AddForUserTx grants permissions as part of a transaction
+func (m PermissionModel) AddForUserTx(tx *sql.Tx, userID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return addPermissionsForUser(ctx, tx, userID, codes)
+}
+
+func addPermissionsForUser(ctx context.Context, db execer, userID int64, codes []string) error {
 	query := `
     INSERT INTO users_permissions
     SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+    ON CONFLICT DO NOTHING
+  `
+
+	_, err := db.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+// RemoveForUserTx revokes permissions as part of a transaction
+func (m PermissionModel) RemoveForUserTx(tx *sql.Tx, userID int64, codes ...string) error {
+	query := `
+    DELETE FROM users_permissions
+    WHERE user_id = $1
+    AND permission_id IN (SELECT id FROM permissions WHERE code = ANY($2))
   `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	_, err := tx.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
+
+// GetAll returns every permission code that can be granted
+func (m PermissionModel) GetAll() (Permissions, error) {
+	query := `
+    SELECT code
+    FROM permissions
+    ORDER BY code
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
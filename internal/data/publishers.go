@@ -44,6 +44,7 @@ func (m PublisherModel) Update(publisher *Publisher) error {
 	query := `
     UPDATE publishers 
     SET name = $1, 
+        updated_at = NOW(),
         slug = NULL  -- Setting slug to NULL forces PostgreSQL to regenerate it
     WHERE id = $2
     RETURNING slug
@@ -150,8 +151,17 @@ func (m PublisherModel) Get(id int64, filters Filters) (*Publisher, []*Book, Met
 }
 
 func (m PublisherModel) GetAll(name string, filters Filters) ([]*Publisher, Metadata, error) {
+	keys := map[string]sortKey{
+		"id":         {expr: "p.id", typ: "bigint"},
+		"name":       {expr: "p.name", typ: "text"},
+		"book_count": {expr: "COUNT(b.id)", typ: "bigint"},
+	}
+
+	key := keys[filters.sortColumn()]
+
 	query := fmt.Sprintf(`
-    SELECT count(*) OVER(), p.id, p.name, p.slug, COUNT(b.id) as book_count
+    SELECT %s, p.id, p.name, p.slug, COUNT(b.id) as book_count,
+           (%s)::text AS sort_key, p.id AS sort_id
     FROM publishers p
     LEFT JOIN books b ON p.id = b.pub_id
     WHERE (
@@ -159,15 +169,15 @@ func (m PublisherModel) GetAll(name string, filters Filters) ([]*Publisher, Meta
         OR $1 = ''
     )
     GROUP BY p.id, p.name
-    ORDER by %s %s, p.name ASC
-    LIMIT $2 OFFSET $3
-`, filters.sortColumn(), filters.sortDirection())
+`, filters.countColumn(), key.expr)
+
+	order := fmt.Sprintf("ORDER by %s %s, p.name ASC", filters.sortColumn(), filters.sortDirection())
+
+	query, args := filters.paginate(query, order, key, []any{name})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{name, filters.limit(), filters.offset()}
-
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
@@ -176,24 +186,26 @@ func (m PublisherModel) GetAll(name string, filters Filters) ([]*Publisher, Meta
 
 	totalRecords := 0
 	publishers := []*Publisher{}
+	pageKeys := []pageKey{}
 
 	for rows.Next() {
 		var publisher Publisher
+		var pk pageKey
 
-		err := rows.Scan(&totalRecords, &publisher.ID, &publisher.Name, &publisher.Slug, &publisher.Books)
+		err := rows.Scan(&totalRecords, &publisher.ID, &publisher.Name, &publisher.Slug, &publisher.Books, &pk.key, &pk.id)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
 		publishers = append(publishers, &publisher)
-
+		pageKeys = append(pageKeys, pk)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	publishers, metadata := pageOf(filters, publishers, pageKeys, totalRecords)
 
 	return publishers, metadata, nil
 }
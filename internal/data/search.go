@@ -0,0 +1,130 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Kinds of search suggestions
+const (
+	SuggestionBook      = "book"
+	SuggestionAuthor    = "author"
+	SuggestionPublisher = "publisher"
+	SuggestionTag       = "tag"
+)
+
+var SuggestionTypes = []string{SuggestionBook, SuggestionAuthor, SuggestionPublisher, SuggestionTag}
+
+// suggestThreshold is the least word similarity of a suggestion. It is low
+// enough for "borjes" to find "Borges".
+const suggestThreshold = 0.3
+
+// Suggestion is a book, author, publisher or tag whose name resembles a
+// search. Tags have no ID or slug; their label is the tag.
+type Suggestion struct {
+	Type  string  `json:"type"`
+	ID    int64   `json:"id,omitempty"`
+	Label string  `json:"label"`
+	Slug  string  `json:"slug,omitempty"`
+	Score float64 `json:"score"`
+}
+
+type SearchModel struct {
+	DB *sql.DB
+}
+
+// Suggest returns up to limit suggestions of the given types for q, best
+// first. Names are compared by trigram word similarity without accents, so
+// prefixes and misspellings match too.
+func (m SearchModel) Suggest(q string, types []string, limit int) ([]*Suggestion, error) {
+	query := `
+    (SELECT 'book' AS type, b.id, b.title AS label, b.slug, word_similarity(f_unaccent($1), f_unaccent(b.title)) AS score
+     FROM books b
+     WHERE 'book' = ANY($3) AND f_unaccent($1) <% f_unaccent(b.title)
+     ORDER BY score DESC
+     LIMIT $2)
+    UNION ALL
+    (SELECT 'author', a.id, concat_ws(' ', a.name, a.last_name), a.slug,
+            word_similarity(f_unaccent($1), f_unaccent(coalesce(a.name, '') || ' ' || a.last_name)) AS score
+     FROM authors a
+     WHERE 'author' = ANY($3) AND f_unaccent($1) <% f_unaccent(coalesce(a.name, '') || ' ' || a.last_name)
+     ORDER BY score DESC
+     LIMIT $2)
+    UNION ALL
+    (SELECT 'publisher', p.id, p.name, p.slug, word_similarity(f_unaccent($1), f_unaccent(p.name)) AS score
+     FROM publishers p
+     WHERE 'publisher' = ANY($3) AND f_unaccent($1) <% f_unaccent(p.name)
+     ORDER BY score DESC
+     LIMIT $2)
+    UNION ALL
+    (SELECT 'tag', 0, t.tag, '', word_similarity(f_unaccent($1), f_unaccent(t.tag)) AS score
+     FROM (SELECT DISTINCT unnest(tags) AS tag FROM books) t
+     WHERE 'tag' = ANY($3) AND f_unaccent($1) <% f_unaccent(t.tag)
+     ORDER BY score DESC
+     LIMIT $2)
+    ORDER BY score DESC, label ASC
+    LIMIT $2
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// The threshold of the <% operator is a setting, changed only for this
+	// transaction
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`, fmt.Sprint(suggestThreshold))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, query, q, limit, pq.Array(types))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*Suggestion{}
+
+	for rows.Next() {
+		var s Suggestion
+
+		err := rows.Scan(&s.Type, &s.ID, &s.Label, &s.Slug, &s.Score)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, tx.Commit()
+}
+
+// DidYouMean returns the book title or author name closest to q, or an empty
+// string when nothing is close enough or q already names it.
+func (m SearchModel) DidYouMean(q string) (string, error) {
+	suggestions, err := m.Suggest(q, []string{SuggestionBook, SuggestionAuthor}, 1)
+	if err != nil || len(suggestions) == 0 {
+		return "", err
+	}
+
+	label := suggestions[0].Label
+	if strings.EqualFold(label, strings.TrimSpace(q)) {
+		return "", nil
+	}
+
+	return label, nil
+}
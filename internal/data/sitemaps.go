@@ -0,0 +1,142 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Sitemaps of the catalog
+const (
+	SitemapBooks      = "books"
+	SitemapAuthors    = "authors"
+	SitemapPublishers = "publishers"
+)
+
+var SitemapKinds = []string{SitemapBooks, SitemapAuthors, SitemapPublishers}
+
+// sitemapSources list the pages of each sitemap. Authors and publishers only
+// have a page when they have books, and it changes with them.
+var sitemapSources = map[string]string{
+	SitemapBooks: `
+    SELECT id, slug, updated_at
+    FROM books
+    WHERE slug IS NOT NULL`,
+	SitemapAuthors: `
+    SELECT a.id, a.slug, GREATEST(a.updated_at, max(b.updated_at)) AS updated_at
+    FROM authors a
+    JOIN books b ON b.auth_id = a.id OR b.auth2_id = a.id
+    WHERE a.slug IS NOT NULL
+    GROUP BY a.id`,
+	SitemapPublishers: `
+    SELECT p.id, p.slug, GREATEST(p.updated_at, max(b.updated_at)) AS updated_at
+    FROM publishers p
+    JOIN books b ON b.pub_id = p.id
+    WHERE p.slug IS NOT NULL
+    GROUP BY p.id`,
+}
+
+type SitemapEntry struct {
+	Slug     string
+	Modified time.Time
+}
+
+// SitemapChunk is one page of a sitemap, dated by its newest entry
+type SitemapChunk struct {
+	Page     int
+	Modified time.Time
+}
+
+type SitemapModel struct {
+	DB *sql.DB
+}
+
+// Chunks splits a sitemap in pages of size entries
+func (m SitemapModel) Chunks(kind string, size int) ([]SitemapChunk, error) {
+	source, ok := sitemapSources[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown sitemap %q", kind)
+	}
+
+	query := fmt.Sprintf(`
+    SELECT page, max(updated_at)
+    FROM (
+      SELECT (row_number() OVER (ORDER BY id) - 1) / $1 + 1 AS page, updated_at
+      FROM (%s) s
+    ) c
+    GROUP BY page
+    ORDER BY page
+  `, source)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, size)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chunks := []SitemapChunk{}
+
+	for rows.Next() {
+		var chunk SitemapChunk
+
+		err := rows.Scan(&chunk.Page, &chunk.Modified)
+		if err != nil {
+			return nil, err
+		}
+
+		chunks = append(chunks, chunk)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return chunks, nil
+}
+
+// Entries returns a page of a sitemap, numbered from 1 as in Chunks
+func (m SitemapModel) Entries(kind string, page, size int) ([]SitemapEntry, error) {
+	source, ok := sitemapSources[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown sitemap %q", kind)
+	}
+
+	query := fmt.Sprintf(`
+    SELECT slug, updated_at
+    FROM (%s) s
+    ORDER BY id
+    LIMIT $1 OFFSET $2
+  `, source)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, size, (page-1)*size)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []SitemapEntry{}
+
+	for rows.Next() {
+		var entry SitemapEntry
+
+		err := rows.Scan(&entry.Slug, &entry.Modified)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
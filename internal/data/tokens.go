@@ -6,22 +6,59 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"qumran.jesarx.com/internal/validator"
 )
 
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
 )
 
+// sessionScopes are the scopes of the tokens of a login session
+var sessionScopes = []string{ScopeAuthentication, ScopeRefresh}
+
+// ErrTokenReused is returned when a refresh token that was already exchanged
+// is presented again
+var ErrTokenReused = errors.New("refresh token reused")
+
+// ErrInactiveUser is returned when a refresh token of a deactivated user is
+// exchanged
+var ErrInactiveUser = errors.New("inactive user")
+
 type Token struct {
+	ID        int64     `json:"-"`
 	Plaintext string    `json:"token"`
 	Hash      []byte    `json:"-"`
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	// FamilyID groups the authentication and refresh tokens issued from one
+	// login. It is assigned on insert when zero.
+	FamilyID int64 `json:"-"`
+	// IP and UserAgent are those of the client that logged in, for the
+	// session list
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+// Session is a login as listed to its user, who can revoke it by ID. It
+// spans every token refreshed from that login.
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	// Current is set on the session of the token making the request
+	Current bool `json:"current"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -55,6 +92,28 @@ type TokenModel struct {
 	DB *sql.DB
 }
 
+// rowQuerier is implemented by both *sql.DB and *sql.Tx
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func insertToken(ctx context.Context, db rowQuerier, token *Token) error {
+	query := `
+    INSERT INTO tokens (hash, user_id, expiry, scope, ip, user_agent, family_id)
+    VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7, nextval('token_families_seq')))
+    RETURNING id, family_id
+  `
+
+	var familyID *int64
+	if token.FamilyID != 0 {
+		familyID = &token.FamilyID
+	}
+
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.IP, token.UserAgent, familyID}
+
+	return db.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.FamilyID)
+}
+
 func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
@@ -66,29 +125,309 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 }
 
 func (m TokenModel) Insert(token *Token) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertToken(ctx, m.DB, token)
+}
+
+// issueSession creates an authentication token and the refresh token that
+// renews it, in the given family or a new one when familyID is zero
+func issueSession(ctx context.Context, db rowQuerier, userID, familyID int64, accessTTL, refreshTTL time.Duration, ip, userAgent string) (access, refresh *Token, err error) {
+	refresh, err = generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, err = generateToken(userID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range []*Token{refresh, access} {
+		token.IP = ip
+		token.UserAgent = clientString(userAgent)
+		token.FamilyID = familyID
+
+		err = insertToken(ctx, db, token)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		familyID = token.FamilyID
+	}
+
+	return access, refresh, nil
+}
+
+// NewSession logs a client in with a short-lived authentication token and a
+// refresh token to renew it
+func (m TokenModel) NewSession(userID int64, accessTTL, refreshTTL time.Duration, ip, userAgent string) (access, refresh *Token, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	access, refresh, err = issueSession(ctx, tx, userID, 0, accessTTL, refreshTTL, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, tx.Commit()
+}
+
+// Rotate exchanges a refresh token for a new authentication and refresh token
+// of the same family. A refresh token only works once: presenting it again
+// means it was copied, so the whole family is revoked and ErrTokenReused
+// returned. The tokens of a user who is not activated return ErrInactiveUser.
+func (m TokenModel) Rotate(refreshPlaintext string, accessTTL, refreshTTL time.Duration, ip, userAgent string) (access, refresh *Token, err error) {
+	tokenHash := sha256.Sum256([]byte(refreshPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	// The row lock makes a concurrent exchange of the same token wait and
+	// then see it as used
 	query := `
-    INSERT INTO tokens (hash, user_id, expiry, scope)
-    VALUES ($1, $2, $3, $4)
+    SELECT tokens.id, tokens.user_id, tokens.family_id, tokens.used_at, users.activated
+    FROM tokens
+    INNER JOIN users ON users.id = tokens.user_id
+    WHERE tokens.hash = $1 AND tokens.scope = $2 AND tokens.expiry > NOW()
+    FOR UPDATE OF tokens
   `
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	var id, userID, familyID int64
+	var usedAt *time.Time
+	var activated bool
+
+	err = tx.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh).Scan(&id, &userID, &familyID, &usedAt, &activated)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if usedAt != nil {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family_id = $1`, familyID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return nil, nil, ErrTokenReused
+	}
+
+	if !activated {
+		return nil, nil, ErrInactiveUser
+	}
+
+	// Used refresh tokens are kept until they expire to detect their reuse;
+	// anything expired in the family can go
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET used_at = NOW() WHERE id = $1`, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family_id = $1 AND expiry <= NOW()`, familyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, refresh, err = issueSession(ctx, tx, userID, familyID, accessTTL, refreshTTL, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, tx.Commit()
+}
+
+// Touch records that an authentication token was used, from which address and
+// client. It writes at most once a minute per token.
+func (m TokenModel) Touch(tokenPlaintext, ip, userAgent string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+    UPDATE tokens
+    SET last_used_at = NOW(), ip = $2, user_agent = $3
+    WHERE hash = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+  `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:], ip, clientString(userAgent))
 	return err
 }
 
-func (m TokenModel) DeleteAllForUser(scope string, UserID int64) error {
+// GetSessionsForUser returns the logins of a user that still have a valid
+// token, most recently used first. currentPlaintext marks the caller's own
+// session.
+func (m TokenModel) GetSessionsForUser(userID int64, currentPlaintext string) ([]*Session, error) {
+	currentHash := sha256.Sum256([]byte(currentPlaintext))
+
+	query := `
+    SELECT family_id,
+           min(created_at),
+           max(last_used_at),
+           max(expiry),
+           (array_agg(ip ORDER BY COALESCE(last_used_at, created_at) DESC, id DESC))[1],
+           (array_agg(user_agent ORDER BY COALESCE(last_used_at, created_at) DESC, id DESC))[1],
+           bool_or(hash = $3)
+    FROM tokens
+    WHERE user_id = $1 AND scope = ANY($2) AND family_id IS NOT NULL
+    GROUP BY family_id
+    HAVING bool_or(used_at IS NULL AND expiry > NOW())
+    ORDER BY COALESCE(max(last_used_at), min(created_at)) DESC, family_id DESC
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, pq.Array(sessionScopes), currentHash[:])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(&session.ID, &session.CreatedAt, &session.LastUsedAt, &session.Expiry, &session.IP, &session.UserAgent, &session.Current)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteSessionForUser revokes every token of one login of a user
+func (m TokenModel) DeleteSessionForUser(id, userID int64) error {
 	query := `
     DELETE FROM tokens
-    WHERE scope = $1 AND user_id = $2
+    WHERE family_id = $1 AND user_id = $2 AND scope = ANY($3)
   `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, scope, UserID)
+	result, err := m.DB.ExecContext(ctx, query, id, userID, pq.Array(sessionScopes))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteSessionForToken revokes the login an authentication token belongs to,
+// refresh tokens included
+func (m TokenModel) DeleteSessionForToken(tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+    DELETE FROM tokens
+    WHERE family_id = (SELECT family_id FROM tokens WHERE hash = $1 AND scope = $2)
+       OR hash = $1
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:], ScopeAuthentication)
 	return err
 }
+
+// DeleteSessionsForUser logs a user out everywhere
+func (m TokenModel) DeleteSessionsForUser(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return deleteSessionsForUser(ctx, m.DB, userID)
+}
+
+// DeleteSessionsForUserTx logs a user out everywhere as part of a transaction
+func (m TokenModel) DeleteSessionsForUserTx(tx *sql.Tx, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return deleteSessionsForUser(ctx, tx, userID)
+}
+
+func deleteSessionsForUser(ctx context.Context, db execer, userID int64) error {
+	query := `
+    DELETE FROM tokens
+    WHERE scope = ANY($1) AND user_id = $2
+  `
+
+	_, err := db.ExecContext(ctx, query, pq.Array(sessionScopes), userID)
+	return err
+}
+
+func (m TokenModel) DeleteAllForUser(scope string, UserID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return deleteAllForUser(ctx, m.DB, scope, UserID)
+}
+
+// DeleteAllForUserTx deletes the user's tokens of a scope as part of a
+// transaction
+func (m TokenModel) DeleteAllForUserTx(tx *sql.Tx, scope string, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return deleteAllForUser(ctx, tx, scope, userID)
+}
+
+func deleteAllForUser(ctx context.Context, db execer, scope string, userID int64) error {
+	query := `
+    DELETE FROM tokens
+    WHERE scope = $1 AND user_id = $2
+  `
+
+	_, err := db.ExecContext(ctx, query, scope, userID)
+	return err
+}
+
+// clientString bounds a client-supplied string such as a User-Agent before it
+// is stored
+func clientString(s string) string {
+	if len(s) > 512 {
+		s = s[:512]
+	}
+	return strings.ToValidUTF8(s, "")
+}
@@ -0,0 +1,136 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Upload is a resumable (tus) upload. The data lives in the staging directory
+// and Offset is how much of it has been received.
+type Upload struct {
+	ID        string
+	UserID    int64
+	Length    int64
+	Offset    int64
+	Metadata  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+func (u *Upload) Expired() bool {
+	return time.Now().After(u.ExpiresAt)
+}
+
+type UploadModel struct {
+	DB *sql.DB
+}
+
+func (m UploadModel) Insert(upload *Upload) error {
+	query := `
+    INSERT INTO uploads (id, user_id, upload_length, metadata, expires_at)
+    VALUES ($1, $2, $3, $4, $5)
+    RETURNING created_at
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, upload.ID, upload.UserID, upload.Length, upload.Metadata, upload.ExpiresAt).Scan(&upload.CreatedAt)
+}
+
+func (m UploadModel) Get(id string) (*Upload, error) {
+	query := `
+    SELECT id, user_id, upload_length, upload_offset, metadata, created_at, expires_at
+    FROM uploads
+    WHERE id = $1
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var upload Upload
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&upload.ID, &upload.UserID, &upload.Length, &upload.Offset,
+		&upload.Metadata, &upload.CreatedAt, &upload.ExpiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &upload, nil
+}
+
+// UpdateOffset records the bytes received so far and pushes the expiry back
+func (m UploadModel) UpdateOffset(upload *Upload) error {
+	query := `
+    UPDATE uploads
+    SET upload_offset = $1, expires_at = $2
+    WHERE id = $3
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, upload.Offset, upload.ExpiresAt, upload.ID)
+	return err
+}
+
+func (m UploadModel) Delete(id string) error {
+	query := `DELETE FROM uploads WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteExpired removes the expired uploads and returns their IDs, so their
+// data can be removed too
+func (m UploadModel) DeleteExpired() ([]string, error) {
+	query := `DELETE FROM uploads WHERE expires_at < NOW() RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -66,6 +67,31 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+func (m UserModel) Get(id int64) (*User, error) {
+	query := `
+    SELECT id, created_at, name, email, password_hash, activated, version
+    FROM users
+    WHERE id = $1
+  `
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.CreatedAt, &user.Name, &user.Email, &user.Password.hash, &user.Activated, &user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
     SELECT id, created_at, name, email, password_hash, activated, version
@@ -91,7 +117,93 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
+// UserFilter narrows the user list of the admin API
+type UserFilter struct {
+	// Query matches part of the name or email, taken literally
+	Query      string
+	Activated  *bool
+	Permission string
+}
+
+func (m UserModel) GetAll(filter UserFilter, filters Filters) ([]*User, Metadata, error) {
+	keys := map[string]sortKey{
+		"id":         {expr: "u.id", typ: "bigint"},
+		"name":       {expr: "u.name", typ: "text"},
+		"email":      {expr: "u.email::text", typ: "text"},
+		"created_at": {expr: "u.created_at", typ: "timestamptz"},
+	}
+
+	key := keys[filters.sortColumn()]
+
+	query := fmt.Sprintf(`
+    SELECT %s, u.id, u.created_at, u.name, u.email, u.activated, u.version,
+           (%s)::text AS sort_key, u.id AS sort_id
+    FROM users u
+    WHERE ($1 = '' OR strpos(lower(u.name), lower($1)) > 0 OR strpos(lower(u.email), lower($1)) > 0)
+    AND ($2::boolean IS NULL OR u.activated = $2)
+    AND ($3 = '' OR EXISTS (
+        SELECT 1
+        FROM users_permissions up
+        JOIN permissions p ON p.id = up.permission_id
+        WHERE up.user_id = u.id AND p.code = $3
+    ))
+`, filters.countColumn(), key.expr)
+
+	order := fmt.Sprintf("ORDER BY %s %s, u.id ASC", key.expr, filters.sortDirection())
+
+	query, args := filters.paginate(query, order, key, []any{filter.Query, filter.Activated, filter.Permission})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+	pageKeys := []pageKey{}
+
+	for rows.Next() {
+		var user User
+		var pk pageKey
+
+		err := rows.Scan(&totalRecords, &user.ID, &user.CreatedAt, &user.Name, &user.Email, &user.Activated, &user.Version, &pk.key, &pk.id)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+		pageKeys = append(pageKeys, pk)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	users, metadata := pageOf(filters, users, pageKeys, totalRecords)
+
+	return users, metadata, nil
+}
+
 func (m UserModel) Update(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return updateUser(ctx, m.DB, user)
+}
+
+// UpdateTx updates a user as part of a transaction
+func (m UserModel) UpdateTx(tx *sql.Tx, user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return updateUser(ctx, tx, user)
+}
+
+func updateUser(ctx context.Context, db rowQuerier, user *User) error {
 	query := `
     UPDATE users
     SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
@@ -108,10 +220,7 @@ func (m UserModel) Update(user *User) error {
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	err := db.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
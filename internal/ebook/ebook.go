@@ -0,0 +1,89 @@
+package ebook
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+type Metadata struct {
+	Title     string
+	Authors   string
+	Publisher string
+}
+
+// Converter turns a PDF into an EPUB carrying the given metadata.
+type Converter interface {
+	ToEPUB(pdfPath, epubPath string, meta Metadata) error
+}
+
+// Calibre converts with Calibre's ebook-convert and writes the metadata with
+// ebook-meta, using the same options as uploads/convert_pdfs.sh.
+type Calibre struct {
+	ConvertPath string
+	MetaPath    string
+	Timeout     time.Duration
+}
+
+func NewCalibre(convertPath, metaPath string, timeout time.Duration) *Calibre {
+	if convertPath == "" {
+		convertPath = "ebook-convert"
+	}
+
+	if metaPath == "" {
+		metaPath = "ebook-meta"
+	}
+
+	return &Calibre{ConvertPath: convertPath, MetaPath: metaPath, Timeout: timeout}
+}
+
+func (c *Calibre) ToEPUB(pdfPath, epubPath string, meta Metadata) error {
+	ctx := context.Background()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	convertCmd := exec.CommandContext(ctx, c.ConvertPath, pdfPath, epubPath,
+		"--no-images", "--enable-heuristics",
+		"--remove-paragraph-spacing",
+		"--chapter-mark=pagebreak", "--base-font-size=12", "--asciiize")
+	output, err := convertCmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ebook-convert failed: %w, output: %s", err, tail(output))
+	}
+
+	args := []string{epubPath}
+	if meta.Title != "" {
+		args = append(args, "--title="+meta.Title)
+	}
+	if meta.Authors != "" {
+		args = append(args, "--authors="+meta.Authors)
+	}
+	if meta.Publisher != "" {
+		args = append(args, "--publisher="+meta.Publisher)
+	}
+
+	if len(args) == 1 {
+		return nil
+	}
+
+	metaCmd := exec.CommandContext(ctx, c.MetaPath, args...)
+	output, err = metaCmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ebook-meta failed: %w, output: %s", err, tail(output))
+	}
+
+	return nil
+}
+
+// tail keeps the end of a command's output, where calibre prints its errors
+func tail(output []byte) string {
+	const max = 2048
+	if len(output) > max {
+		output = output[len(output)-max:]
+	}
+	return string(output)
+}
@@ -0,0 +1,214 @@
+// Package feeds renders Atom and RSS 2.0 syndication feeds.
+package feeds
+
+import (
+	"encoding/xml"
+	"strconv"
+	"time"
+)
+
+// Media types of the feeds
+const (
+	TypeAtom = "application/atom+xml; charset=utf-8"
+	TypeRSS  = "application/rss+xml; charset=utf-8"
+)
+
+// Enclosure is a file attached to an item, for readers to download
+type Enclosure struct {
+	URL    string
+	Type   string
+	Length int64
+}
+
+type Item struct {
+	ID         string
+	Title      string
+	Link       string
+	Summary    string
+	Authors    []string
+	Categories []string
+	Published  time.Time
+	Enclosures []Enclosure
+}
+
+// Feed is a list of items. Self is the address of the feed itself and Link
+// that of the page it follows.
+type Feed struct {
+	ID      string
+	Title   string
+	Self    string
+	Link    string
+	Updated time.Time
+	Items   []Item
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Authors    []atomPerson   `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Summary    string         `xml:"summary,omitempty"`
+	Links      []atomLink     `xml:"link"`
+}
+
+// Atom returns the Atom document of the feed
+func (f *Feed) Atom() ([]byte, error) {
+	feed := atomFeed{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		ID:      f.ID,
+		Title:   f.Title,
+		Updated: f.Updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Href: f.Self, Type: "application/atom+xml"},
+			{Rel: "alternate", Href: f.Link},
+		},
+	}
+
+	for _, item := range f.Items {
+		published := item.Published.UTC().Format(time.RFC3339)
+
+		entry := atomEntry{
+			ID:        item.ID,
+			Title:     item.Title,
+			Updated:   published,
+			Published: published,
+			Summary:   item.Summary,
+			Links:     []atomLink{{Rel: "alternate", Href: item.Link}},
+		}
+
+		for _, author := range item.Authors {
+			entry.Authors = append(entry.Authors, atomPerson{Name: author})
+		}
+
+		for _, category := range item.Categories {
+			entry.Categories = append(entry.Categories, atomCategory{Term: category})
+		}
+
+		for _, e := range item.Enclosures {
+			entry.Links = append(entry.Links, atomLink{Rel: "enclosure", Href: e.URL, Type: e.Type, Length: e.Length})
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	return marshal(feed)
+}
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	XmlnsA  string     `xml:"xmlns:atom,attr"`
+	XmlnsDC string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	SelfLink      rssSelf   `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssSelf struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length string `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	GUID        rssGUID       `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Authors     []string      `xml:"dc:creator"`
+	Categories  []string      `xml:"category"`
+	Description string        `xml:"description,omitempty"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+}
+
+// RSS returns the RSS 2.0 document of the feed. RSS allows a single
+// enclosure per item, so only the first one is kept.
+func (f *Feed) RSS() ([]byte, error) {
+	doc := rss{
+		Version: "2.0",
+		XmlnsA:  "http://www.w3.org/2005/Atom",
+		XmlnsDC: "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.Link,
+			Description:   f.Title,
+			SelfLink:      rssSelf{Rel: "self", Href: f.Self, Type: "application/rss+xml"},
+			LastBuildDate: f.Updated.UTC().Format(time.RFC1123Z),
+		},
+	}
+
+	for _, item := range f.Items {
+		ri := rssItem{
+			Title:       item.Title,
+			Link:        item.Link,
+			GUID:        rssGUID{IsPermaLink: "false", Value: item.ID},
+			PubDate:     item.Published.UTC().Format(time.RFC1123Z),
+			Authors:     item.Authors,
+			Categories:  item.Categories,
+			Description: item.Summary,
+		}
+
+		if len(item.Enclosures) > 0 {
+			e := item.Enclosures[0]
+			ri.Enclosure = &rssEnclosure{URL: e.URL, Length: strconv.FormatInt(e.Length, 10), Type: e.Type}
+		}
+
+		doc.Channel.Items = append(doc.Channel.Items, ri)
+	}
+
+	return marshal(doc)
+}
+
+func marshal(v any) ([]byte, error) {
+	output, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), output...), nil
+}
@@ -0,0 +1,100 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// Supported rendition formats
+const (
+	JPEG = "jpeg"
+	WebP = "webp"
+)
+
+// Rendition is a resized copy of a cover. Size is the nominal width it was
+// requested at, Width and Height are its real dimensions.
+type Rendition struct {
+	Size   int
+	Width  int
+	Height int
+	Format string
+	Path   string
+}
+
+// Name returns the file name of the rendition of the given nominal width of
+// the cover with the given base filename.
+func Name(base string, width int, format string) string {
+	ext := ".jpg"
+	if format == WebP {
+		ext = ".webp"
+	}
+
+	return base + "-" + strconv.Itoa(width) + ext
+}
+
+// Renditions resizes the JPEG at src to each width and format with
+// ImageMagick, writing the results to dir. Images are never enlarged, so a
+// rendition can be narrower than its nominal width.
+func Renditions(src string, dir string, base string, widths []int, formats []string) ([]Rendition, error) {
+	srcWidth, srcHeight, err := dimensions(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var renditions []Rendition
+
+	for _, width := range widths {
+		w, h := width, srcHeight*width/srcWidth
+		if width >= srcWidth {
+			w, h = srcWidth, srcHeight
+		}
+
+		for _, format := range formats {
+			path := filepath.Join(dir, Name(base, width, format))
+
+			args := []string{src, "-resize", strconv.Itoa(width) + "x>", "-strip"}
+			switch format {
+			case JPEG:
+				args = append(args, "-interlace", "Plane", "-quality", "82")
+			case WebP:
+				args = append(args, "-quality", "80")
+			default:
+				return nil, fmt.Errorf("unsupported rendition format %q", format)
+			}
+			args = append(args, path)
+
+			output, err := exec.Command("convert", args...).CombinedOutput()
+			if err != nil {
+				return nil, fmt.Errorf("failed to create %dpx %s rendition: %w, output: %s", width, format, err, string(output))
+			}
+
+			renditions = append(renditions, Rendition{Size: width, Width: w, Height: h, Format: format, Path: path})
+		}
+	}
+
+	return renditions, nil
+}
+
+func dimensions(path string) (int, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read cover dimensions: %w", err)
+	}
+
+	if cfg.Width == 0 || cfg.Height == 0 {
+		return 0, 0, fmt.Errorf("cover has no size")
+	}
+
+	return cfg.Width, cfg.Height, nil
+}
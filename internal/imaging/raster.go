@@ -0,0 +1,62 @@
+package imaging
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rasterizer renders the first page of a PDF to a JPEG. It is used as the
+// cover of books uploaded without one.
+type Rasterizer interface {
+	FirstPage(pdfPath, jpegPath string) error
+}
+
+// Pdftoppm renders pages with poppler's pdftoppm, scaled to Width pixels wide.
+type Pdftoppm struct {
+	Path    string
+	Width   int
+	Timeout time.Duration
+}
+
+func NewPdftoppm(path string, width int, timeout time.Duration) *Pdftoppm {
+	if path == "" {
+		path = "pdftoppm"
+	}
+
+	return &Pdftoppm{Path: path, Width: width, Timeout: timeout}
+}
+
+func (p *Pdftoppm) FirstPage(pdfPath, jpegPath string) error {
+	ctx := context.Background()
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	// pdftoppm adds the extension to the output prefix itself
+	prefix := strings.TrimSuffix(jpegPath, filepath.Ext(jpegPath))
+
+	args := []string{"-jpeg", "-jpegopt", "quality=90", "-f", "1", "-l", "1", "-singlefile"}
+	if p.Width > 0 {
+		args = append(args, "-scale-to-x", strconv.Itoa(p.Width), "-scale-to-y", "-1")
+	}
+	args = append(args, pdfPath, prefix)
+
+	output, err := exec.CommandContext(ctx, p.Path, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("pdftoppm failed: %w, output: %s", err, string(output))
+	}
+
+	if prefix+".jpg" != jpegPath {
+		return os.Rename(prefix+".jpg", jpegPath)
+	}
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func scrubImage(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	out, removed, err := cleanImage(data)
+	if err != nil {
+		return err
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	// Write next to the original and rename over it, so a failure never
+	// leaves half an image behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".scrub-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(out)
+	if err == nil {
+		err = tmp.Chmod(fi.Mode().Perm())
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func verifyImage(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	_, removed, err := cleanImage(data)
+	if err != nil {
+		return err
+	}
+	if len(removed) > 0 {
+		return fmt.Errorf("%w: %s", ErrLeftover, strings.Join(removed, ", "))
+	}
+
+	return nil
+}
+
+// cleanImage returns the image without its metadata, and the names of the
+// segments or chunks it removed
+func cleanImage(data []byte) ([]byte, []string, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return cleanJPEG(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return cleanPNG(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return cleanWebP(data)
+	}
+	return nil, nil, fmt.Errorf("%w: unknown image format", ErrUnsupported)
+}
+
+// cleanJPEG drops APP1 (EXIF and XMP), APP13 (IPTC) and comment segments, and
+// every other application segment except JFIF, ICC profiles and the Adobe
+// segment, which affect how the image is decoded.
+func cleanJPEG(data []byte) ([]byte, []string, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+
+	var removed []string
+	pos := 2
+
+	for pos < len(data) {
+		if data[pos] != 0xff {
+			return nil, nil, fmt.Errorf("%w: bad JPEG marker at %d", errSyntax, pos)
+		}
+
+		// Markers may be preceded by any number of fill bytes
+		for pos < len(data) && data[pos] == 0xff {
+			pos++
+		}
+		if pos >= len(data) {
+			break
+		}
+		marker := data[pos]
+		pos++
+
+		switch {
+		case marker == 0xd9: // EOI
+			out = append(out, 0xff, marker)
+			return out, removed, nil
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			out = append(out, 0xff, marker)
+			continue
+		}
+
+		if pos+2 > len(data) {
+			return nil, nil, fmt.Errorf("%w: truncated JPEG segment", errSyntax)
+		}
+		length := int(binary.BigEndian.Uint16(data[pos:]))
+		if length < 2 || pos+length > len(data) {
+			return nil, nil, fmt.Errorf("%w: bad JPEG segment length", errSyntax)
+		}
+		segment := data[pos+2 : pos+length]
+		end := pos + length
+
+		if marker == 0xda { // SOS: copy the entropy-coded data up to the next marker
+			for end < len(data) {
+				if data[end] == 0xff && end+1 < len(data) {
+					next := data[end+1]
+					if next != 0x00 && next != 0xff && (next < 0xd0 || next > 0xd7) {
+						break
+					}
+				}
+				end++
+			}
+		}
+
+		if name := jpegMetadata(marker, segment); name != "" {
+			removed = append(removed, name)
+		} else {
+			out = append(out, 0xff, marker)
+			out = append(out, data[pos:end]...)
+		}
+
+		pos = end
+	}
+
+	return out, removed, nil
+}
+
+// jpegMetadata names the segment if it holds metadata
+func jpegMetadata(marker byte, segment []byte) string {
+	switch {
+	case marker == 0xfe:
+		return "COM"
+	case marker == 0xe1:
+		switch {
+		case bytes.HasPrefix(segment, []byte("Exif\x00")):
+			return "EXIF"
+		case bytes.HasPrefix(segment, []byte("http://ns.adobe.com/")):
+			return "XMP"
+		}
+		return "APP1"
+	case marker == 0xed:
+		return "IPTC"
+	case marker == 0xe0 && (bytes.HasPrefix(segment, []byte("JFIF\x00")) || bytes.HasPrefix(segment, []byte("JFXX\x00"))):
+		return ""
+	case marker == 0xe2 && bytes.HasPrefix(segment, []byte("ICC_PROFILE\x00")):
+		return ""
+	case marker == 0xee && bytes.HasPrefix(segment, []byte("Adobe")):
+		return ""
+	case marker >= 0xe0 && marker <= 0xef:
+		return fmt.Sprintf("APP%d", marker-0xe0)
+	}
+	return ""
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadata are the PNG chunks holding text, EXIF or timestamps
+var pngMetadata = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true, "tIME": true}
+
+func cleanPNG(data []byte) ([]byte, []string, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+
+	var removed []string
+	pos := len(pngSignature)
+
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, nil, fmt.Errorf("%w: truncated PNG chunk", errSyntax)
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		typ := string(data[pos+4 : pos+8])
+		end := pos + 12 + length
+		if length < 0 || end > len(data) || end < pos {
+			return nil, nil, fmt.Errorf("%w: bad PNG chunk length", errSyntax)
+		}
+
+		if pngMetadata[typ] {
+			removed = append(removed, typ)
+		} else {
+			out = append(out, data[pos:end]...)
+		}
+
+		pos = end
+		if typ == "IEND" {
+			break
+		}
+	}
+
+	return out, removed, nil
+}
+
+// VP8X flags for the metadata chunks
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+func cleanWebP(data []byte) ([]byte, []string, error) {
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+
+	var removed []string
+	pos := 12
+
+	for pos+8 <= len(data) {
+		fourcc := string(data[pos : pos+4])
+		length := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + length + length%2
+		if length < 0 || end > len(data) || end < pos {
+			// The padding byte of the last chunk is sometimes missing
+			if pos+8+length == len(data) {
+				end = len(data)
+			} else {
+				return nil, nil, fmt.Errorf("%w: bad WebP chunk length", errSyntax)
+			}
+		}
+
+		switch fourcc {
+		case "EXIF", "XMP ":
+			removed = append(removed, strings.TrimSpace(fourcc))
+		case "VP8X":
+			chunk := append([]byte(nil), data[pos:end]...)
+			if length > 0 && chunk[8]&(webpFlagEXIF|webpFlagXMP) != 0 {
+				chunk[8] &^= webpFlagEXIF | webpFlagXMP
+				removed = append(removed, "VP8X metadata flags")
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[pos:end]...)
+		}
+
+		pos = end
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+
+	return out, removed, nil
+}
@@ -0,0 +1,143 @@
+// Package metadata removes identifying metadata from the files of a book and
+// writes the metadata the library wants in its place.
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+var (
+	// ErrUnsupported is returned by Native for files it cannot rewrite, such
+	// as encrypted or damaged PDFs.
+	ErrUnsupported = errors.New("metadata: unsupported file")
+	// ErrLeftover is returned when verification finds metadata that should
+	// have been removed.
+	ErrLeftover = errors.New("metadata: identifying metadata left in file")
+)
+
+// PDFInfo is the metadata written to a PDF's information dictionary and XMP
+// packet.
+type PDFInfo struct {
+	Title     string
+	Author    string
+	Publisher string
+}
+
+// Scrubber strips the metadata of files in place.
+type Scrubber interface {
+	// ScrubImage removes EXIF, XMP, IPTC and comments from a JPEG, PNG or
+	// WebP image.
+	ScrubImage(path string) error
+	// ScrubPDF replaces the document metadata of a PDF with info.
+	ScrubPDF(path string, info PDFInfo) error
+}
+
+// Drivers are the accepted values of New's driver.
+const (
+	DriverGo       = "go"
+	DriverExiftool = "exiftool"
+)
+
+// New returns the scrubber for driver. With DriverGo, files the Go code
+// cannot handle are passed to exiftool when fallback is set.
+func New(driver string, exiftoolPath string, fallback bool) (Scrubber, error) {
+	switch driver {
+	case DriverGo:
+		n := &Native{}
+		if fallback {
+			n.Fallback = NewExiftool(exiftoolPath)
+		}
+		return n, nil
+	case DriverExiftool:
+		return NewExiftool(exiftoolPath), nil
+	}
+	return nil, fmt.Errorf("metadata: unknown driver %q", driver)
+}
+
+// Native scrubs files without external tools and verifies the result.
+type Native struct {
+	// Fallback, if set, handles the files that fail with ErrUnsupported
+	Fallback Scrubber
+}
+
+func (n *Native) ScrubImage(path string) error {
+	err := scrubImage(path)
+	if errors.Is(err, ErrUnsupported) && n.Fallback != nil {
+		return n.Fallback.ScrubImage(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	return VerifyImage(path)
+}
+
+func (n *Native) ScrubPDF(path string, info PDFInfo) error {
+	err := scrubPDF(path, info)
+	if errors.Is(err, errSyntax) {
+		err = fmt.Errorf("%w: %w", ErrUnsupported, err)
+	}
+	if errors.Is(err, ErrUnsupported) && n.Fallback != nil {
+		return n.Fallback.ScrubPDF(path, info)
+	}
+	if err != nil {
+		return err
+	}
+
+	return VerifyPDF(path)
+}
+
+// VerifyImage fails with ErrLeftover if the image still has metadata.
+func VerifyImage(path string) error {
+	return verifyImage(path)
+}
+
+// VerifyPDF fails with ErrLeftover if the PDF has document metadata other
+// than what ScrubPDF writes, or older versions of it.
+func VerifyPDF(path string) error {
+	return verifyPDF(path)
+}
+
+// Exiftool scrubs files with exiftool.
+type Exiftool struct {
+	Path string
+}
+
+func NewExiftool(path string) *Exiftool {
+	if path == "" {
+		path = "exiftool"
+	}
+
+	return &Exiftool{Path: path}
+}
+
+func (e *Exiftool) ScrubImage(path string) error {
+	output, err := exec.Command(e.Path, "-overwrite_original", "-all:all=", path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to run exiftool on image: %w, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+func (e *Exiftool) ScrubPDF(path string, info PDFInfo) error {
+	output, err := exec.Command(e.Path, "-overwrite_original", "-all:all=", path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to run exiftool on PDF: %w, output: %s", err, string(output))
+	}
+
+	output, err = exec.Command(e.Path,
+		"-overwrite_original",
+		"-charset", "exif=UTF8",
+		"-Title="+info.Title,
+		"-Author="+info.Author,
+		"-Publisher="+info.Publisher,
+		path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to add metadata to PDF: %w, output: %s", err, string(output))
+	}
+
+	return nil
+}
@@ -0,0 +1,614 @@
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// PDF scrubbing works on the file in place:
+//
+//  1. Every version of the document information dictionary and of the
+//     catalog's XMP metadata stream, in every revision of the file, is
+//     overwritten with "null" padded with spaces, so no offsets move. Object
+//     streams holding one of them are blanked the same way and their live
+//     objects written out again.
+//  2. An incremental update is appended with a new information dictionary and
+//     XMP packet holding only the given Info, and a catalog pointing to them.
+
+// allowedInfoKeys are the entries ScrubPDF writes to the information dictionary
+var allowedInfoKeys = []pdfName{"Title", "Author", "Publisher"}
+
+// forbiddenXMP are the XMP namespace prefixes of tool and history metadata
+var forbiddenXMP = []string{"xmp:", "xmpMM:", "pdf:", "photoshop:", "exif:", "tiff:", "stEvt:", "pdfx:"}
+
+func scrubPDF(path string, info PDFInfo) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	doc, err := openPDF(file, fi.Size())
+	if err != nil {
+		return err
+	}
+
+	trailer := doc.sections[0].trailer
+
+	if _, ok := trailer["Encrypt"]; ok {
+		return fmt.Errorf("%w: encrypted PDF", ErrUnsupported)
+	}
+
+	rootRef, ok := trailer["Root"].(pdfRef)
+	if !ok {
+		return fmt.Errorf("%w: trailer has no catalog", errSyntax)
+	}
+
+	catalog, _, err := doc.resolve(rootRef)
+	if err != nil {
+		return err
+	}
+	catalogDict, ok := catalog.(pdfDict)
+	if !ok {
+		return fmt.Errorf("%w: catalog is not a dictionary", errSyntax)
+	}
+
+	targets, err := doc.metadataObjects()
+	if err != nil {
+		return err
+	}
+
+	// Work out everything to blank and write out before touching the file
+	var blank []int64
+	stms := map[int]bool{}
+
+	for _, section := range doc.sections {
+		for num := range targets {
+			entry, ok := section.entries[num]
+			switch {
+			case !ok:
+			case entry.typ == 1:
+				blank = append(blank, entry.offset)
+			case entry.typ == 2:
+				stms[entry.stream] = true
+			}
+		}
+	}
+
+	for num := range stms {
+		for _, section := range doc.sections {
+			if entry, ok := section.entries[num]; ok && entry.typ == 1 {
+				blank = append(blank, entry.offset)
+			}
+		}
+	}
+
+	// Live objects of the blanked object streams, other than the metadata
+	// itself and the catalog, which is rewritten anyway
+	moved := map[int][]byte{}
+	for num := range stms {
+		nums, objects, err := doc.objStmObjects(num)
+		if err != nil {
+			return err
+		}
+
+		for _, objNum := range nums {
+			entry, ok := doc.latest(objNum)
+			if !ok || entry.typ != 2 || entry.stream != num || targets[objNum] || objNum == rootRef.num {
+				continue
+			}
+			moved[objNum] = objects[objNum]
+		}
+	}
+
+	slices.Sort(blank)
+	blank = slices.Compact(blank)
+
+	ranges := make([][2]int64, len(blank))
+	for i, offset := range blank {
+		ranges[i][0], ranges[i][1], err = doc.objectRange(offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, r := range ranges {
+		err = blankRange(file, r[0], r[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	size, _ := trailer["Size"].(int64)
+	infoNum := int(size)
+	xmpNum := infoNum + 1
+
+	catalogDict = maps.Clone(catalogDict)
+	catalogDict["Metadata"] = pdfRef{num: xmpNum}
+
+	u := &pdfUpdate{offset: fi.Size(), entries: map[int]xrefEntry{}}
+
+	// Make sure the update starts on a new line
+	u.buf.WriteString("\n")
+
+	for _, num := range sortedKeys(moved) {
+		u.object(num, 0, func(b *bytes.Buffer) { b.Write(bytes.TrimSpace(moved[num])) })
+	}
+
+	u.object(rootRef.num, rootRef.gen, func(b *bytes.Buffer) { writeValue(b, catalogDict) })
+	u.object(infoNum, 0, func(b *bytes.Buffer) { writeValue(b, infoDict(info)) })
+
+	xmp := xmpPacket(info)
+	u.object(xmpNum, 0, func(b *bytes.Buffer) {
+		writeValue(b, pdfDict{"Type": pdfName("Metadata"), "Subtype": pdfName("XML"), "Length": int64(len(xmp))})
+		b.WriteString("\nstream\n")
+		b.Write(xmp)
+		b.WriteString("\nendstream")
+	})
+
+	for num := range targets {
+		if num != rootRef.num {
+			entry, _ := doc.latest(num)
+			u.entries[num] = xrefEntry{typ: 0, gen: entry.gen + 1}
+		}
+	}
+
+	newTrailer := pdfDict{
+		"Size": int64(max(int(size), xmpNum+1)),
+		"Root": rootRef,
+		"Info": pdfRef{num: infoNum},
+		"Prev": doc.startxref,
+	}
+	if id, ok := trailer["ID"]; ok {
+		newTrailer["ID"] = id
+	}
+
+	u.finish(newTrailer, doc.sections[0].isStream)
+
+	_, err = file.WriteAt(u.buf.Bytes(), fi.Size())
+	if err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// metadataObjects returns the numbers of every object that is, in some
+// revision, the document information dictionary or the catalog's metadata
+// stream
+func (f *pdfFile) metadataObjects() (map[int]bool, error) {
+	targets := map[int]bool{}
+	roots := map[int]bool{}
+
+	for _, section := range f.sections {
+		if ref, ok := section.trailer["Info"].(pdfRef); ok {
+			targets[ref.num] = true
+		}
+		if ref, ok := section.trailer["Root"].(pdfRef); ok {
+			roots[ref.num] = true
+		}
+	}
+
+	// Older versions of the catalog may point to other metadata streams
+	for _, section := range f.sections {
+		for num := range roots {
+			entry, ok := section.entries[num]
+			if !ok || entry.typ == 0 {
+				continue
+			}
+
+			v, _, err := f.entryObject(entry)
+			if err != nil {
+				return nil, err
+			}
+
+			if catalog, ok := v.(pdfDict); ok {
+				if ref, ok := catalog["Metadata"].(pdfRef); ok {
+					targets[ref.num] = true
+				}
+			}
+		}
+	}
+
+	for num := range roots {
+		delete(targets, num)
+	}
+
+	return targets, nil
+}
+
+// blankRange overwrites a range of the file with "null" and spaces
+func blankRange(file *os.File, start, end int64) error {
+	const chunk = 64 * 1024
+
+	if end-start < int64(len(" null ")) {
+		return fmt.Errorf("%w: object too small to blank", errSyntax)
+	}
+
+	spaces := bytes.Repeat([]byte(" "), chunk)
+
+	_, err := file.WriteAt([]byte(" null"), start)
+	if err != nil {
+		return err
+	}
+
+	for offset := start + 5; offset < end; offset += chunk {
+		n := min(chunk, end-offset)
+		_, err := file.WriteAt(spaces[:n], offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pdfUpdate builds an incremental update appended at offset
+type pdfUpdate struct {
+	buf     bytes.Buffer
+	offset  int64
+	entries map[int]xrefEntry
+}
+
+func (u *pdfUpdate) object(num, gen int, body func(b *bytes.Buffer)) {
+	u.entries[num] = xrefEntry{typ: 1, offset: u.offset + int64(u.buf.Len()), gen: gen}
+	fmt.Fprintf(&u.buf, "%d %d obj\n", num, gen)
+	body(&u.buf)
+	u.buf.WriteString("\nendobj\n")
+}
+
+// finish writes the cross-reference section, as a stream when the file uses
+// them
+func (u *pdfUpdate) finish(trailer pdfDict, asStream bool) {
+	xrefOffset := u.offset + int64(u.buf.Len())
+
+	if asStream {
+		num := int(trailer["Size"].(int64))
+		trailer["Size"] = int64(num + 1)
+		u.entries[num] = xrefEntry{typ: 1, offset: xrefOffset}
+
+		var data []byte
+		for _, n := range sortedKeys(u.entries) {
+			entry := u.entries[n]
+			data = append(data, byte(entry.typ))
+			for shift := 56; shift >= 0; shift -= 8 {
+				data = append(data, byte(uint64(entry.offset)>>shift))
+			}
+			data = append(data, byte(entry.gen>>8), byte(entry.gen))
+		}
+
+		dict := maps.Clone(trailer)
+		dict["Type"] = pdfName("XRef")
+		dict["W"] = []any{int64(1), int64(8), int64(2)}
+		dict["Index"] = subsections(u.entries)
+		dict["Length"] = int64(len(data))
+
+		fmt.Fprintf(&u.buf, "%d 0 obj\n", num)
+		writeValue(&u.buf, dict)
+		u.buf.WriteString("\nstream\n")
+		u.buf.Write(data)
+		u.buf.WriteString("\nendstream\nendobj\n")
+	} else {
+		u.buf.WriteString("xref\n")
+
+		index := subsections(u.entries)
+		for i := 0; i < len(index); i += 2 {
+			start, count := int(index[i].(int64)), int(index[i+1].(int64))
+			fmt.Fprintf(&u.buf, "%d %d\n", start, count)
+
+			for n := start; n < start+count; n++ {
+				entry := u.entries[n]
+				kind := "n"
+				if entry.typ == 0 {
+					kind = "f"
+				}
+				fmt.Fprintf(&u.buf, "%010d %05d %s\r\n", entry.offset, entry.gen, kind)
+			}
+		}
+
+		u.buf.WriteString("trailer\n")
+		writeValue(&u.buf, trailer)
+		u.buf.WriteString("\n")
+	}
+
+	fmt.Fprintf(&u.buf, "startxref\n%d\n%%%%EOF\n", xrefOffset)
+}
+
+// subsections groups the object numbers into runs, as /Index pairs
+func subsections(entries map[int]xrefEntry) []any {
+	var index []any
+
+	nums := sortedKeys(entries)
+	for i := 0; i < len(nums); {
+		j := i + 1
+		for j < len(nums) && nums[j] == nums[j-1]+1 {
+			j++
+		}
+		index = append(index, int64(nums[i]), int64(j-i))
+		i = j
+	}
+
+	return index
+}
+
+func infoDict(info PDFInfo) pdfDict {
+	d := pdfDict{}
+	if info.Title != "" {
+		d["Title"] = textString(info.Title)
+	}
+	if info.Author != "" {
+		d["Author"] = textString(info.Author)
+	}
+	if info.Publisher != "" {
+		d["Publisher"] = textString(info.Publisher)
+	}
+	return d
+}
+
+// textString encodes a PDF text string: plain for ASCII, otherwise UTF-16BE
+// with a byte order mark
+func textString(s string) string {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return s
+	}
+
+	b := []byte{0xfe, 0xff}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u>>8), byte(u))
+	}
+	return string(b)
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '&':
+			b.WriteString("&amp;")
+		case '<':
+			b.WriteString("&lt;")
+		case '>':
+			b.WriteString("&gt;")
+		case '"':
+			b.WriteString("&quot;")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func xmpPacket(info PDFInfo) []byte {
+	var b strings.Builder
+
+	b.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/">` + "\n")
+	b.WriteString(`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` + "\n")
+	b.WriteString(`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/">` + "\n")
+	if info.Title != "" {
+		b.WriteString(`<dc:title><rdf:Alt><rdf:li xml:lang="x-default">` + xmlEscape(info.Title) + `</rdf:li></rdf:Alt></dc:title>` + "\n")
+	}
+	if info.Author != "" {
+		b.WriteString(`<dc:creator><rdf:Seq><rdf:li>` + xmlEscape(info.Author) + `</rdf:li></rdf:Seq></dc:creator>` + "\n")
+	}
+	if info.Publisher != "" {
+		b.WriteString(`<dc:publisher><rdf:Bag><rdf:li>` + xmlEscape(info.Publisher) + `</rdf:li></rdf:Bag></dc:publisher>` + "\n")
+	}
+	b.WriteString("</rdf:Description>\n</rdf:RDF>\n</x:xmpmeta>\n")
+	b.WriteString(`<?xpacket end="w"?>`)
+
+	return []byte(b.String())
+}
+
+func writeValue(b *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	case string:
+		b.WriteByte('<')
+		fmt.Fprintf(b, "%x", v)
+		b.WriteByte('>')
+	case pdfName:
+		b.WriteByte('/')
+		for i := 0; i < len(v); i++ {
+			c := v[i]
+			if c <= ' ' || c >= 0x7f || c == '#' || isDelim(c) {
+				fmt.Fprintf(b, "#%02x", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	case pdfRef:
+		fmt.Fprintf(b, "%d %d R", v.num, v.gen)
+	case []any:
+		b.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			writeValue(b, item)
+		}
+		b.WriteByte(']')
+	case pdfDict:
+		b.WriteString("<<")
+		for _, key := range sortedKeys(v) {
+			writeValue(b, key)
+			b.WriteByte(' ')
+			writeValue(b, v[key])
+		}
+		b.WriteString(">>")
+	}
+}
+
+// verifyPDF checks that the only document metadata left in the file is what
+// scrubPDF wrote
+func verifyPDF(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	doc, err := openPDF(file, fi.Size())
+	if err != nil {
+		return err
+	}
+
+	trailer := doc.sections[0].trailer
+
+	info, _, err := doc.resolve(trailer["Info"])
+	if err != nil {
+		return err
+	}
+	if infoDict, ok := info.(pdfDict); ok {
+		for key := range infoDict {
+			if !slices.Contains(allowedInfoKeys, key) {
+				return fmt.Errorf("%w: information dictionary has %s", ErrLeftover, key)
+			}
+		}
+	}
+
+	catalog, _, err := doc.resolve(trailer["Root"])
+	if err != nil {
+		return err
+	}
+	catalogDict, _ := catalog.(pdfDict)
+
+	var current []int
+	if ref, ok := trailer["Info"].(pdfRef); ok {
+		current = append(current, ref.num)
+	}
+
+	if ref, ok := catalogDict["Metadata"].(pdfRef); ok {
+		current = append(current, ref.num)
+
+		_, stm, err := doc.resolve(ref)
+		if err != nil {
+			return err
+		}
+		if stm != nil {
+			data, err := doc.streamData(stm)
+			if err != nil {
+				return err
+			}
+			for _, prefix := range forbiddenXMP {
+				if bytes.Contains(data, []byte(prefix)) {
+					return fmt.Errorf("%w: XMP metadata has %s properties", ErrLeftover, strings.TrimSuffix(prefix, ":"))
+				}
+			}
+		}
+	}
+
+	targets, err := doc.metadataObjects()
+	if err != nil {
+		return err
+	}
+
+	// Every older version must have been blanked
+	for _, section := range doc.sections {
+		for num := range targets {
+			entry, ok := section.entries[num]
+			if !ok || entry.typ == 0 {
+				continue
+			}
+
+			if latest, _ := doc.latest(num); slices.Contains(current, num) && latest == entry {
+				continue
+			}
+
+			v, stm, err := doc.entryObject(entry)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return err
+			}
+			if v != nil || stm != nil {
+				return fmt.Errorf("%w: old metadata object %d", ErrLeftover, num)
+			}
+		}
+	}
+
+	return nil
+}
+
+func sortedKeys[K ~int | ~string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// PageCount returns the number of pages of the PDF at path, as recorded in its
+// page tree.
+func PageCount(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	doc, err := openPDF(file, fi.Size())
+	if err != nil {
+		return 0, err
+	}
+
+	catalog, _, err := doc.resolve(doc.sections[0].trailer["Root"])
+	if err != nil {
+		return 0, err
+	}
+	catalogDict, _ := catalog.(pdfDict)
+
+	pages, _, err := doc.resolve(catalogDict["Pages"])
+	if err != nil {
+		return 0, err
+	}
+	pagesDict, _ := pages.(pdfDict)
+
+	count, _, err := doc.resolve(pagesDict["Count"])
+	if err != nil {
+		return 0, err
+	}
+
+	n, ok := count.(int64)
+	if !ok || n < 0 {
+		return 0, fmt.Errorf("%w: page tree has no count", errSyntax)
+	}
+
+	return int(n), nil
+}
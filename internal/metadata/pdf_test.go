@@ -0,0 +1,321 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Every fixture hides these in the metadata that has to go
+var secrets = []string{"SecretProducer", "SecretCreator", "SecretTool", "SecretUpdater"}
+
+const secretXMP = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:CreatorTool="SecretTool"/>
+</rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+const (
+	catalogObj = "<</Type/Catalog/Pages 2 0 R/Metadata 5 0 R>>"
+	pagesObj   = "<</Type/Pages/Kids[3 0 R]/Count 1>>"
+	pageObj    = "<</Type/Page/Parent 2 0 R/MediaBox[0 0 612 792]>>"
+	infoObj    = "<</Title(Old title)/Producer(SecretProducer)/Creator(SecretCreator)>>"
+	xmpDict    = "/Type/Metadata/Subtype/XML"
+)
+
+func TestScrubPDF(t *testing.T) {
+	info := PDFInfo{Title: "Cien años de soledad", Author: "Gabriel García Márquez", Publisher: "Qumran"}
+
+	tests := []struct {
+		name     string
+		pdf      func() []byte
+		fallback bool
+	}{
+		{"classic xref", classicPDF, false},
+		{"xref stream", xrefStreamPDF, false},
+		{"object stream", objectStreamPDF, false},
+		{"incremental update", updatedPDF, false},
+		{"encrypted", encryptedPDF, true},
+		{"malformed", malformedPDF, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := tt.pdf()
+			path := filepath.Join(t.TempDir(), "book.pdf")
+
+			if err := os.WriteFile(path, original, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.fallback {
+				err := (&Native{}).ScrubPDF(path, info)
+				if !errors.Is(err, ErrUnsupported) {
+					t.Fatalf("ScrubPDF without fallback: got %v, want ErrUnsupported", err)
+				}
+			}
+
+			exiftool := &fakeExiftool{}
+			err := (&Native{Fallback: exiftool}).ScrubPDF(path, info)
+			if err != nil {
+				t.Fatalf("ScrubPDF: %v", err)
+			}
+
+			if exiftool.called != tt.fallback {
+				t.Fatalf("fallback called: got %v, want %v", exiftool.called, tt.fallback)
+			}
+			if tt.fallback && !bytes.Equal(exiftool.received, original) {
+				t.Error("the file was modified before falling back")
+			}
+
+			checkScrubbedPDF(t, path, info)
+		})
+	}
+}
+
+// checkScrubbedPDF checks that the PDF at path parses, that its information
+// dictionary holds exactly info and that none of the secrets is left anywhere
+// in the file
+func checkScrubbedPDF(t *testing.T, path string, info PDFInfo) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range secrets {
+		if bytes.Contains(data, []byte(secret)) {
+			t.Errorf("%s is still in the file", secret)
+		}
+	}
+
+	if err := VerifyPDF(path); err != nil {
+		t.Errorf("VerifyPDF: %v", err)
+	}
+
+	doc, err := openPDF(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("openPDF: %v", err)
+	}
+
+	// The page tree must still be reachable from the rewritten catalog
+	catalog, _, err := doc.resolve(doc.sections[0].trailer["Root"])
+	if err != nil {
+		t.Fatalf("resolve Root: %v", err)
+	}
+	catalogDict, _ := catalog.(pdfDict)
+
+	pages, _, err := doc.resolve(catalogDict["Pages"])
+	if err != nil {
+		t.Fatalf("resolve Pages: %v", err)
+	}
+	if pagesDict, _ := pages.(pdfDict); pagesDict["Count"] != int64(1) {
+		t.Errorf("page tree: got %v, want a count of 1", pages)
+	}
+
+	v, _, err := doc.resolve(doc.sections[0].trailer["Info"])
+	if err != nil {
+		t.Fatalf("resolve Info: %v", err)
+	}
+	got, _ := v.(pdfDict)
+
+	want := infoDict(info)
+	if len(got) != len(want) {
+		t.Errorf("information dictionary: got keys %v, want %v", sortedKeys(got), sortedKeys(want))
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("information dictionary %s: got %q, want %q", key, got[key], value)
+		}
+	}
+}
+
+// fakeExiftool stands in for exiftool as the fallback: it records the file it
+// was given and replaces it with a clean PDF, as exiftool would
+type fakeExiftool struct {
+	called   bool
+	received []byte
+}
+
+func (e *fakeExiftool) ScrubImage(path string) error {
+	return errors.New("unexpected image")
+}
+
+func (e *fakeExiftool) ScrubPDF(path string, info PDFInfo) error {
+	var err error
+
+	e.called = true
+	e.received, err = os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var dict bytes.Buffer
+	writeValue(&dict, infoDict(info))
+
+	w := newPDFWriter()
+	w.object(1, "<</Type/Catalog/Pages 2 0 R>>")
+	w.object(2, pagesObj)
+	w.object(3, pageObj)
+	w.object(4, dict.String())
+	w.xrefTable("/Size 5/Root 1 0 R/Info 4 0 R")
+
+	return os.WriteFile(path, w.buf.Bytes(), 0o644)
+}
+
+// pdfWriter assembles test PDFs, keeping track of where every object lands
+type pdfWriter struct {
+	buf bytes.Buffer
+	// offsets of the uncompressed objects and, for those in object streams,
+	// the stream and index
+	offsets    map[int]int64
+	compressed map[int][2]int
+}
+
+func newPDFWriter() *pdfWriter {
+	w := &pdfWriter{offsets: map[int]int64{}, compressed: map[int][2]int{}}
+	w.buf.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	return w
+}
+
+func (w *pdfWriter) object(num int, body string) {
+	w.offsets[num] = int64(w.buf.Len())
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", num, body)
+}
+
+func (w *pdfWriter) stream(num int, dict string, data []byte) {
+	w.offsets[num] = int64(w.buf.Len())
+	fmt.Fprintf(&w.buf, "%d 0 obj\n<<%s/Length %d>>\nstream\n", num, dict, len(data))
+	w.buf.Write(data)
+	w.buf.WriteString("\nendstream\nendobj\n")
+}
+
+// objectStream stores objects, in order, in the uncompressed object stream num
+func (w *pdfWriter) objectStream(num int, nums []int, bodies []string) {
+	var header, body strings.Builder
+	for i, objNum := range nums {
+		w.compressed[objNum] = [2]int{num, i}
+		fmt.Fprintf(&header, "%d %d ", objNum, body.Len())
+		body.WriteString(bodies[i] + "\n")
+	}
+
+	dict := fmt.Sprintf("/Type/ObjStm/N %d/First %d", len(nums), header.Len())
+	w.stream(num, dict, []byte(header.String()+body.String()))
+}
+
+// xrefTable writes a cross-reference table with the objects written since the
+// previous section, one subsection each, and the trailer
+func (w *pdfWriter) xrefTable(trailer string) {
+	start := int64(w.buf.Len())
+
+	w.buf.WriteString("xref\n0 1\n0000000000 65535 f\r\n")
+	for _, num := range sortedKeys(w.offsets) {
+		fmt.Fprintf(&w.buf, "%d 1\n%010d 00000 n\r\n", num, w.offsets[num])
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<<%s>>\n", trailer)
+
+	w.finish(start)
+}
+
+// xrefStream writes a compressed cross-reference stream as object num,
+// covering every object of the file
+func (w *pdfWriter) xrefStream(num int, trailer string) {
+	w.offsets[num] = int64(w.buf.Len())
+
+	size := num + 1
+	var rows bytes.Buffer
+	for n := 0; n < size; n++ {
+		if offset, ok := w.offsets[n]; ok {
+			rows.Write([]byte{1, byte(offset >> 24), byte(offset >> 16), byte(offset >> 8), byte(offset), 0, 0})
+		} else if loc, ok := w.compressed[n]; ok {
+			rows.Write([]byte{2, 0, 0, byte(loc[0] >> 8), byte(loc[0]), byte(loc[1] >> 8), byte(loc[1])})
+		} else {
+			rows.Write([]byte{0, 0, 0, 0, 0, 0xff, 0xff})
+		}
+	}
+
+	var data bytes.Buffer
+	zw := zlib.NewWriter(&data)
+	zw.Write(rows.Bytes())
+	zw.Close()
+
+	start := int64(w.buf.Len())
+	w.stream(num, fmt.Sprintf("/Type/XRef/Size %d/W[1 4 2]/Filter/FlateDecode%s", size, trailer), data.Bytes())
+	w.finish(start)
+}
+
+func (w *pdfWriter) finish(xref int64) {
+	fmt.Fprintf(&w.buf, "startxref\n%d\n%%%%EOF\n", xref)
+	clear(w.offsets)
+}
+
+func (w *pdfWriter) bookObjects() {
+	w.object(1, catalogObj)
+	w.object(2, pagesObj)
+	w.object(3, pageObj)
+	w.object(4, infoObj)
+	w.stream(5, xmpDict, []byte(secretXMP))
+}
+
+func classicPDF() []byte {
+	w := newPDFWriter()
+	w.bookObjects()
+	w.xrefTable("/Size 6/Root 1 0 R/Info 4 0 R")
+	return w.buf.Bytes()
+}
+
+func xrefStreamPDF() []byte {
+	w := newPDFWriter()
+	w.bookObjects()
+	w.xrefStream(6, "/Root 1 0 R/Info 4 0 R")
+	return w.buf.Bytes()
+}
+
+// objectStreamPDF keeps the catalog, the page tree and the information
+// dictionary in an object stream, so scrubbing has to move the pages out
+func objectStreamPDF() []byte {
+	w := newPDFWriter()
+	w.stream(5, xmpDict, []byte(secretXMP))
+	w.objectStream(6, []int{1, 2, 3, 4}, []string{catalogObj, pagesObj, pageObj, infoObj})
+	w.xrefStream(7, "/Root 1 0 R/Info 4 0 R")
+	return w.buf.Bytes()
+}
+
+// updatedPDF has been edited once already: an incremental update points the
+// trailer to a new information dictionary, leaving the old one behind
+func updatedPDF() []byte {
+	w := newPDFWriter()
+	w.bookObjects()
+
+	prev := int64(w.buf.Len())
+	w.xrefTable("/Size 6/Root 1 0 R/Info 4 0 R")
+
+	w.object(6, "<</Title(Old title)/Producer(SecretUpdater)>>")
+	w.xrefTable(fmt.Sprintf("/Size 7/Root 1 0 R/Info 6 0 R/Prev %d", prev))
+
+	return w.buf.Bytes()
+}
+
+func encryptedPDF() []byte {
+	w := newPDFWriter()
+	w.bookObjects()
+	w.object(6, "<</Filter/Standard/V 2/R 3/Length 128/P -4/O<00>/U<00>>>")
+	w.xrefTable("/Size 7/Root 1 0 R/Info 4 0 R/Encrypt 6 0 R/ID[<01><01>]")
+	return w.buf.Bytes()
+}
+
+// malformedPDF has lost its cross-reference section
+func malformedPDF() []byte {
+	data := classicPDF()
+	i := bytes.LastIndex(data, []byte("\nxref\n"))
+	return slices.Clone(data[:i+1])
+}
@@ -0,0 +1,1021 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// A minimal PDF reader: enough of the syntax to follow the cross-reference
+// sections of a file, resolve objects (including those in object streams)
+// and locate their bytes. Values are represented as:
+//
+//	nil, bool, int64, float64, string (literal and hex strings), pdfName,
+//	[]any, pdfDict, pdfRef and *pdfStream
+
+type pdfName string
+
+type pdfRef struct {
+	num int
+	gen int
+}
+
+type pdfDict map[pdfName]any
+
+type pdfStream struct {
+	dict pdfDict
+	// dataStart is the file offset of the first byte of the stream data
+	dataStart int64
+}
+
+var (
+	errShortBuffer = errors.New("pdf: object extends past the buffer")
+	errSyntax      = errors.New("pdf: syntax error")
+)
+
+type lexer struct {
+	buf []byte
+	pos int
+	// eof is set when buf reaches the end of the file
+	eof bool
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+func isDelim(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+func (l *lexer) short() error {
+	if l.eof {
+		return errSyntax
+	}
+	return errShortBuffer
+}
+
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.buf) {
+		c := l.buf[l.pos]
+		switch {
+		case isSpace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.buf) && l.buf[l.pos] != '\n' && l.buf[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// keyword reads a run of regular characters
+func (l *lexer) keyword() (string, error) {
+	l.skipSpace()
+	start := l.pos
+	for l.pos < len(l.buf) && !isSpace(l.buf[l.pos]) && !isDelim(l.buf[l.pos]) {
+		l.pos++
+	}
+	if l.pos == len(l.buf) && !l.eof {
+		return "", errShortBuffer
+	}
+	return string(l.buf[start:l.pos]), nil
+}
+
+func (l *lexer) expect(word string) error {
+	kw, err := l.keyword()
+	if err != nil {
+		return err
+	}
+	if kw != word {
+		return fmt.Errorf("%w: expected %q, found %q", errSyntax, word, kw)
+	}
+	return nil
+}
+
+func (l *lexer) integer() (int64, error) {
+	kw, err := l.keyword()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(kw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: expected an integer, found %q", errSyntax, kw)
+	}
+	return n, nil
+}
+
+func (l *lexer) value() (any, error) {
+	l.skipSpace()
+	if l.pos >= len(l.buf) {
+		return nil, l.short()
+	}
+
+	switch c := l.buf[l.pos]; {
+	case c == '/':
+		return l.name()
+	case c == '(':
+		return l.literalString()
+	case c == '[':
+		l.pos++
+		arr := []any{}
+		for {
+			l.skipSpace()
+			if l.pos >= len(l.buf) {
+				return nil, l.short()
+			}
+			if l.buf[l.pos] == ']' {
+				l.pos++
+				return arr, nil
+			}
+			v, err := l.value()
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+	case c == '<':
+		if l.pos+1 >= len(l.buf) {
+			return nil, l.short()
+		}
+		if l.buf[l.pos+1] == '<' {
+			return l.dict()
+		}
+		return l.hexString()
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return l.number()
+	default:
+		kw, err := l.keyword()
+		if err != nil {
+			return nil, err
+		}
+		switch kw {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w: unexpected %q", errSyntax, kw)
+	}
+}
+
+// number reads a number, or an indirect reference "num gen R"
+func (l *lexer) number() (any, error) {
+	kw, err := l.keyword()
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.ParseInt(kw, 10, 64)
+	if err != nil {
+		f, err := strconv.ParseFloat(kw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad number %q", errSyntax, kw)
+		}
+		return f, nil
+	}
+
+	// Look ahead for "gen R"
+	save := l.pos
+	gen, err := l.keyword()
+	if err == nil {
+		if g, convErr := strconv.ParseInt(gen, 10, 64); convErr == nil {
+			r, err := l.keyword()
+			if err == nil && r == "R" {
+				return pdfRef{num: int(n), gen: int(g)}, nil
+			}
+			if errors.Is(err, errShortBuffer) {
+				return nil, err
+			}
+		}
+	} else if errors.Is(err, errShortBuffer) {
+		return nil, err
+	}
+	l.pos = save
+
+	return n, nil
+}
+
+func (l *lexer) name() (pdfName, error) {
+	l.pos++ // '/'
+	var b []byte
+	for l.pos < len(l.buf) && !isSpace(l.buf[l.pos]) && !isDelim(l.buf[l.pos]) {
+		c := l.buf[l.pos]
+		if c == '#' && l.pos+2 < len(l.buf) {
+			if v, err := strconv.ParseUint(string(l.buf[l.pos+1:l.pos+3]), 16, 8); err == nil {
+				b = append(b, byte(v))
+				l.pos += 3
+				continue
+			}
+		}
+		b = append(b, c)
+		l.pos++
+	}
+	if l.pos == len(l.buf) && !l.eof {
+		return "", errShortBuffer
+	}
+	return pdfName(b), nil
+}
+
+func (l *lexer) literalString() (string, error) {
+	l.pos++ // '('
+	var b []byte
+	depth := 1
+	for {
+		if l.pos >= len(l.buf) {
+			return "", l.short()
+		}
+		c := l.buf[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return string(b), nil
+			}
+		case '\\':
+			if l.pos >= len(l.buf) {
+				return "", l.short()
+			}
+			e := l.buf[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if l.pos < len(l.buf) && l.buf[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && l.pos < len(l.buf) && l.buf[l.pos] >= '0' && l.buf[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.buf[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		b = append(b, c)
+	}
+}
+
+func (l *lexer) hexString() (string, error) {
+	l.pos++ // '<'
+	var digits []byte
+	for {
+		if l.pos >= len(l.buf) {
+			return "", l.short()
+		}
+		c := l.buf[l.pos]
+		l.pos++
+		if c == '>' {
+			break
+		}
+		if !isSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	b := make([]byte, len(digits)/2)
+	for i := range b {
+		v, err := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("%w: bad hex string", errSyntax)
+		}
+		b[i] = byte(v)
+	}
+	return string(b), nil
+}
+
+func (l *lexer) dict() (pdfDict, error) {
+	l.pos += 2 // "<<"
+	d := pdfDict{}
+	for {
+		l.skipSpace()
+		if l.pos+1 >= len(l.buf) {
+			return nil, l.short()
+		}
+		if l.buf[l.pos] == '>' && l.buf[l.pos+1] == '>' {
+			l.pos += 2
+			return d, nil
+		}
+		if l.buf[l.pos] != '/' {
+			return nil, fmt.Errorf("%w: dictionary key is not a name", errSyntax)
+		}
+		key, err := l.name()
+		if err != nil {
+			return nil, err
+		}
+		v, err := l.value()
+		if err != nil {
+			return nil, err
+		}
+		d[key] = v
+	}
+}
+
+// xrefEntry locates an object: type 1 objects are stored at offset, type 2
+// objects are entry index of object stream stream, type 0 entries are free.
+type xrefEntry struct {
+	typ    int
+	offset int64
+	gen    int
+	stream int
+	index  int
+}
+
+type xrefSection struct {
+	offset   int64
+	isStream bool
+	entries  map[int]xrefEntry
+	trailer  pdfDict
+}
+
+type pdfFile struct {
+	r    io.ReaderAt
+	size int64
+	// sections are ordered from the newest to the oldest
+	sections  []*xrefSection
+	startxref int64
+	objStms   map[int][]byte
+}
+
+func openPDF(r io.ReaderAt, size int64) (*pdfFile, error) {
+	f := &pdfFile{r: r, size: size, objStms: map[int][]byte{}}
+
+	tail := min(size, 2048)
+	buf := make([]byte, tail)
+	_, err := r.ReadAt(buf, size-tail)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	i := bytes.LastIndex(buf, []byte("startxref"))
+	if i < 0 {
+		return nil, fmt.Errorf("%w: startxref not found", errSyntax)
+	}
+
+	l := &lexer{buf: buf[i+len("startxref"):], eof: true}
+	f.startxref, err = l.integer()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[int64]bool{}
+	offset := f.startxref
+
+	for {
+		if seen[offset] || offset <= 0 || offset >= size {
+			return nil, fmt.Errorf("%w: bad cross-reference offset %d", errSyntax, offset)
+		}
+		seen[offset] = true
+
+		section, err := f.readXref(offset)
+		if err != nil {
+			return nil, err
+		}
+
+		// Hybrid files keep the entries of compressed objects in a separate
+		// stream; the table takes precedence
+		if stm, ok := section.trailer["XRefStm"].(int64); ok && !seen[stm] {
+			seen[stm] = true
+			extra, err := f.readXref(stm)
+			if err != nil {
+				return nil, err
+			}
+			for num, entry := range extra.entries {
+				if _, ok := section.entries[num]; !ok {
+					section.entries[num] = entry
+				}
+			}
+		}
+
+		f.sections = append(f.sections, section)
+
+		prev, ok := section.trailer["Prev"].(int64)
+		if !ok {
+			break
+		}
+		offset = prev
+	}
+
+	return f, nil
+}
+
+// readAt parses with fn a window of the file starting at offset, growing the
+// window until the parse fits
+func (f *pdfFile) readAt(offset int64, fn func(l *lexer) error) error {
+	n := int64(16 * 1024)
+	for {
+		n = min(n, f.size-offset)
+		buf := make([]byte, n)
+		_, err := f.r.ReadAt(buf, offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		l := &lexer{buf: buf, eof: offset+n >= f.size}
+		err = fn(l)
+		if errors.Is(err, errShortBuffer) && !l.eof {
+			n *= 4
+			continue
+		}
+		return err
+	}
+}
+
+func (f *pdfFile) readXref(offset int64) (*xrefSection, error) {
+	section := &xrefSection{offset: offset, entries: map[int]xrefEntry{}}
+
+	var isTable bool
+	err := f.readAt(offset, func(l *lexer) error {
+		kw, err := l.keyword()
+		isTable = kw == "xref"
+		if errors.Is(err, errShortBuffer) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !isTable {
+		section.isStream = true
+
+		v, stm, _, err := f.objectAt(offset)
+		if err != nil {
+			return nil, err
+		}
+		if stm == nil || v != nil {
+			return nil, fmt.Errorf("%w: cross-reference stream expected at %d", errSyntax, offset)
+		}
+
+		section.trailer = stm.dict
+		err = f.readXrefStream(stm, section.entries)
+		if err != nil {
+			return nil, err
+		}
+
+		return section, nil
+	}
+
+	err = f.readAt(offset, func(l *lexer) error {
+		clear(section.entries)
+
+		if err := l.expect("xref"); err != nil {
+			return err
+		}
+
+		for {
+			kw, err := l.keyword()
+			if err != nil {
+				return err
+			}
+			if kw == "trailer" {
+				break
+			}
+
+			start, err := strconv.Atoi(kw)
+			if err != nil {
+				return fmt.Errorf("%w: bad cross-reference subsection", errSyntax)
+			}
+			count, err := l.integer()
+			if err != nil {
+				return err
+			}
+
+			for i := 0; i < int(count); i++ {
+				off, err := l.integer()
+				if err != nil {
+					return err
+				}
+				gen, err := l.integer()
+				if err != nil {
+					return err
+				}
+				kind, err := l.keyword()
+				if err != nil {
+					return err
+				}
+
+				entry := xrefEntry{offset: off, gen: int(gen)}
+				if kind == "n" {
+					entry.typ = 1
+				}
+				section.entries[start+i] = entry
+			}
+		}
+
+		v, err := l.value()
+		if err != nil {
+			return err
+		}
+		trailer, ok := v.(pdfDict)
+		if !ok {
+			return fmt.Errorf("%w: trailer is not a dictionary", errSyntax)
+		}
+		section.trailer = trailer
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return section, nil
+}
+
+func (f *pdfFile) readXrefStream(stm *pdfStream, entries map[int]xrefEntry) error {
+	data, err := f.streamData(stm)
+	if err != nil {
+		return err
+	}
+
+	w, _ := stm.dict["W"].([]any)
+	if len(w) != 3 {
+		return fmt.Errorf("%w: bad cross-reference stream /W", errSyntax)
+	}
+	var widths [3]int
+	rowLen := 0
+	for i, v := range w {
+		n, ok := v.(int64)
+		if !ok || n < 0 || n > 8 {
+			return fmt.Errorf("%w: bad cross-reference stream /W", errSyntax)
+		}
+		widths[i] = int(n)
+		rowLen += int(n)
+	}
+
+	index := []any{int64(0), stm.dict["Size"]}
+	if idx, ok := stm.dict["Index"].([]any); ok {
+		index = idx
+	}
+
+	field := func(b []byte) int64 {
+		var v int64
+		for _, c := range b {
+			v = v<<8 | int64(c)
+		}
+		return v
+	}
+
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		start, ok1 := index[i].(int64)
+		count, ok2 := index[i+1].(int64)
+		if !ok1 || !ok2 {
+			return fmt.Errorf("%w: bad cross-reference stream /Index", errSyntax)
+		}
+
+		for j := 0; j < int(count); j++ {
+			if pos+rowLen > len(data) {
+				return fmt.Errorf("%w: cross-reference stream is truncated", errSyntax)
+			}
+			row := data[pos : pos+rowLen]
+			pos += rowLen
+
+			typ := int64(1)
+			if widths[0] > 0 {
+				typ = field(row[:widths[0]])
+			}
+			a := field(row[widths[0] : widths[0]+widths[1]])
+			b := field(row[widths[0]+widths[1]:])
+
+			num := int(start) + j
+			switch typ {
+			case 0:
+				entries[num] = xrefEntry{typ: 0, gen: int(b)}
+			case 1:
+				entries[num] = xrefEntry{typ: 1, offset: a, gen: int(b)}
+			case 2:
+				entries[num] = xrefEntry{typ: 2, stream: int(a), index: int(b)}
+			}
+		}
+	}
+
+	return nil
+}
+
+// objectAt parses the indirect object at offset. For streams the value is
+// nil and the stream is returned instead. end is the file offset just after
+// the object's value (before "stream" for streams).
+func (f *pdfFile) objectAt(offset int64) (any, *pdfStream, int64, error) {
+	var value any
+	var stm *pdfStream
+	var end int64
+
+	err := f.readAt(offset, func(l *lexer) error {
+		if _, err := l.integer(); err != nil {
+			return err
+		}
+		if _, err := l.integer(); err != nil {
+			return err
+		}
+		if err := l.expect("obj"); err != nil {
+			return err
+		}
+
+		v, err := l.value()
+		if err != nil {
+			return err
+		}
+		end = offset + int64(l.pos)
+
+		save := l.pos
+		kw, err := l.keyword()
+		if err != nil && !errors.Is(err, errSyntax) {
+			return err
+		}
+
+		d, isDict := v.(pdfDict)
+		if kw != "stream" || !isDict {
+			l.pos = save
+			value = v
+			return nil
+		}
+
+		// The data starts after the end of line following "stream"
+		if l.pos < len(l.buf) && l.buf[l.pos] == '\r' {
+			l.pos++
+		}
+		if l.pos >= len(l.buf) {
+			return l.short()
+		}
+		if l.buf[l.pos] == '\n' {
+			l.pos++
+		}
+
+		stm = &pdfStream{dict: d, dataStart: offset + int64(l.pos)}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	return value, stm, end, nil
+}
+
+// latest returns the newest cross-reference entry of an object
+func (f *pdfFile) latest(num int) (xrefEntry, bool) {
+	for _, section := range f.sections {
+		if entry, ok := section.entries[num]; ok {
+			return entry, entry.typ != 0
+		}
+	}
+	return xrefEntry{}, false
+}
+
+// resolve follows a reference to its current value
+func (f *pdfFile) resolve(v any) (any, *pdfStream, error) {
+	ref, ok := v.(pdfRef)
+	if !ok {
+		return v, nil, nil
+	}
+
+	entry, ok := f.latest(ref.num)
+	if !ok {
+		return nil, nil, nil
+	}
+
+	return f.entryObject(entry)
+}
+
+// entryObject returns the object an entry points to
+func (f *pdfFile) entryObject(entry xrefEntry) (any, *pdfStream, error) {
+	switch entry.typ {
+	case 1:
+		v, stm, _, err := f.objectAt(entry.offset)
+		return v, stm, err
+	case 2:
+		raw, err := f.objStmObject(entry.stream, entry.index)
+		if err != nil || raw == nil {
+			return nil, nil, err
+		}
+		l := &lexer{buf: raw, eof: true}
+		v, err := l.value()
+		return v, nil, err
+	}
+	return nil, nil, nil
+}
+
+func (f *pdfFile) streamLength(stm *pdfStream) (int64, error) {
+	v, _, err := f.resolve(stm.dict["Length"])
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok || n < 0 || stm.dataStart+n > f.size {
+		return 0, fmt.Errorf("%w: bad stream length", errSyntax)
+	}
+	return n, nil
+}
+
+// streamData returns the decoded data of a stream. Only FlateDecode, with or
+// without PNG predictors, is supported.
+func (f *pdfFile) streamData(stm *pdfStream) ([]byte, error) {
+	n, err := f.streamLength(stm)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, n)
+	_, err = f.r.ReadAt(raw, stm.dataStart)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	filter := stm.dict["Filter"]
+	params := stm.dict["DecodeParms"]
+	if arr, ok := filter.([]any); ok {
+		if len(arr) > 1 {
+			return nil, fmt.Errorf("%w: multiple stream filters", ErrUnsupported)
+		}
+		filter = nil
+		if len(arr) == 1 {
+			filter = arr[0]
+		}
+		if p, ok := params.([]any); ok && len(p) == 1 {
+			params = p[0]
+		}
+	}
+
+	switch filter {
+	case nil:
+		return raw, nil
+	case pdfName("FlateDecode"):
+	default:
+		return nil, fmt.Errorf("%w: stream filter %v", ErrUnsupported, filter)
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+
+	p, _ := params.(pdfDict)
+	if predictor, _ := p["Predictor"].(int64); predictor >= 10 {
+		columns, ok := p["Columns"].(int64)
+		if !ok {
+			columns = 1
+		}
+		colors, ok := p["Colors"].(int64)
+		if !ok {
+			colors = 1
+		}
+		bpc, ok := p["BitsPerComponent"].(int64)
+		if !ok {
+			bpc = 8
+		}
+		return pngUnpredict(data, int(columns), int(colors), int(bpc))
+	} else if predictor > 1 {
+		return nil, fmt.Errorf("%w: TIFF predictor", ErrUnsupported)
+	}
+
+	return data, nil
+}
+
+// pngUnpredict reverses the PNG row filters used by predictors 10 to 15
+func pngUnpredict(data []byte, columns, colors, bpc int) ([]byte, error) {
+	bpp := max(1, colors*bpc/8)
+	rowLen := (colors*bpc*columns + 7) / 8
+	if rowLen == 0 {
+		return nil, fmt.Errorf("%w: bad predictor parameters", errSyntax)
+	}
+
+	out := make([]byte, 0, len(data)/(rowLen+1)*rowLen)
+	prev := make([]byte, rowLen)
+
+	for pos := 0; pos+rowLen+1 <= len(data); pos += rowLen + 1 {
+		filter := data[pos]
+		row := append([]byte(nil), data[pos+1:pos+1+rowLen]...)
+
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left = row[i-bpp]
+				upLeft = prev[i-bpp]
+			}
+			up := prev[i]
+
+			switch filter {
+			case 0:
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			default:
+				return nil, fmt.Errorf("%w: bad PNG filter %d", errSyntax, filter)
+			}
+		}
+
+		out = append(out, row...)
+		prev = row
+	}
+
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// objStmObjects returns the raw bytes of every object in an object stream,
+// keyed by object number, in the order they are stored
+func (f *pdfFile) objStmObjects(num int) ([]int, map[int][]byte, error) {
+	data, ok := f.objStms[num]
+	var first int64
+
+	if !ok {
+		entry, found := f.latest(num)
+		if !found || entry.typ != 1 {
+			return nil, nil, fmt.Errorf("%w: object stream %d not found", errSyntax, num)
+		}
+
+		_, stm, _, err := f.objectAt(entry.offset)
+		if err != nil {
+			return nil, nil, err
+		}
+		if stm == nil {
+			// Blanked by a previous scrub
+			return nil, nil, nil
+		}
+
+		data, err = f.streamData(stm)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		first, _ = stm.dict["First"].(int64)
+		n, _ := stm.dict["N"].(int64)
+		if first <= 0 || first > int64(len(data)) || n < 0 {
+			return nil, nil, fmt.Errorf("%w: bad object stream %d", errSyntax, num)
+		}
+
+		// Keep the header size in front of the data
+		data = append([]byte(strconv.FormatInt(first, 10)+" "+strconv.FormatInt(n, 10)+"\n"), data...)
+		f.objStms[num] = data
+	}
+
+	nl := bytes.IndexByte(data, '\n')
+	l := &lexer{buf: data[:nl], eof: true}
+	first, _ = l.integer()
+	n, _ := l.integer()
+	body := data[nl+1:]
+
+	l = &lexer{buf: body[:first], eof: true}
+	nums := make([]int, n)
+	offsets := make([]int64, n+1)
+	for i := range nums {
+		objNum, err := l.integer()
+		if err != nil {
+			return nil, nil, err
+		}
+		off, err := l.integer()
+		if err != nil {
+			return nil, nil, err
+		}
+		nums[i] = int(objNum)
+		offsets[i] = first + off
+	}
+	offsets[n] = int64(len(body))
+
+	objects := map[int][]byte{}
+	for i, objNum := range nums {
+		start, end := offsets[i], offsets[i+1]
+		if start > end || end > int64(len(body)) {
+			return nil, nil, fmt.Errorf("%w: bad object stream %d", errSyntax, num)
+		}
+		objects[objNum] = body[start:end]
+	}
+
+	return nums, objects, nil
+}
+
+func (f *pdfFile) objStmObject(num int, index int) ([]byte, error) {
+	nums, objects, err := f.objStmObjects(num)
+	if err != nil || index >= len(nums) {
+		return nil, err
+	}
+	return objects[nums[index]], nil
+}
+
+// objectRange returns the file range holding the value of the object at
+// offset, from after "obj" to "endobj", including stream data
+func (f *pdfFile) objectRange(offset int64) (int64, int64, error) {
+	var start int64
+
+	err := f.readAt(offset, func(l *lexer) error {
+		if _, err := l.integer(); err != nil {
+			return err
+		}
+		if _, err := l.integer(); err != nil {
+			return err
+		}
+		if err := l.expect("obj"); err != nil {
+			return err
+		}
+		start = offset + int64(l.pos)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	_, stm, end, err := f.objectAt(offset)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if stm != nil {
+		end = stm.dataStart
+		if n, err := f.streamLength(stm); err == nil {
+			end += n
+		}
+	}
+
+	endobj, err := f.find(end, []byte("endobj"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return start, endobj, nil
+}
+
+// find returns the offset of the next occurrence of needle from offset
+func (f *pdfFile) find(offset int64, needle []byte) (int64, error) {
+	const chunk = 64 * 1024
+
+	buf := make([]byte, chunk+len(needle))
+	for offset < f.size {
+		n, err := f.r.ReadAt(buf, offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if i := bytes.Index(buf[:n], needle); i >= 0 {
+			return offset + int64(i), nil
+		}
+		if n < len(buf) {
+			break
+		}
+		offset += chunk
+	}
+
+	return 0, fmt.Errorf("%w: %q not found", errSyntax, needle)
+}
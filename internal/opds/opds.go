@@ -0,0 +1,393 @@
+// Package opds renders catalog feeds for e-reader apps, as OPDS 1.2 Atom
+// documents and as OPDS 2.0 JSON.
+package opds
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Media types of OPDS documents
+const (
+	TypeNavigation  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	TypeAcquisition = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	TypeJSON        = "application/opds+json"
+	TypeOpenSearch  = "application/opensearchdescription+xml"
+)
+
+const (
+	relAcquisition  = "http://opds-spec.org/acquisition/open-access"
+	relImage        = "http://opds-spec.org/image"
+	relThumbnail    = "http://opds-spec.org/image/thumbnail"
+	relSubsection   = "subsection"
+	schemaBook      = "http://schema.org/Book"
+	namespaceAtom   = "http://www.w3.org/2005/Atom"
+	namespaceDC     = "http://purl.org/dc/terms/"
+	namespaceOPDS   = "http://opds-spec.org/2010/catalog"
+	namespaceSearch = "http://a9.com/-/spec/opensearch/1.1/"
+)
+
+// Link is a link of a feed or publication. Links of both versions of a feed
+// are built with the media type of the version they point to.
+type Link struct {
+	Rel   string
+	Href  string
+	Type  string
+	Title string
+	// Templated marks OPDS 2.0 links with URI template variables
+	Templated bool
+}
+
+// Navigation is an entry of a navigation feed, leading to another feed
+type Navigation struct {
+	ID    string
+	Title string
+	Href  string
+	Type  string
+	Count int
+}
+
+// Author of a publication, with a link to the feed of their books
+type Author struct {
+	Name string
+	Href string
+}
+
+// Image is a cover rendition
+type Image struct {
+	Href   string
+	Type   string
+	Width  int
+	Height int
+}
+
+// Publication is a book of an acquisition feed
+type Publication struct {
+	ID        string
+	Title     string
+	Authors   []Author
+	Publisher string
+	Language  string
+	Year      int
+	Subjects  []string
+	Updated   time.Time
+	// Acquisitions are the download links of the book's files
+	Acquisitions []Link
+	Cover        *Image
+	Thumbnail    *Image
+}
+
+// Feed is a navigation feed when it has Navigation entries and an
+// acquisition feed when it has Publications.
+type Feed struct {
+	ID           string
+	Title        string
+	Updated      time.Time
+	Links        []Link
+	Navigation   []Navigation
+	Publications []Publication
+	// Paging, when known
+	TotalResults int
+	ItemsPerPage int
+	CurrentPage  int
+}
+
+type atomFeed struct {
+	XMLName      xml.Name    `xml:"feed"`
+	Xmlns        string      `xml:"xmlns,attr"`
+	XmlnsDC      string      `xml:"xmlns:dc,attr"`
+	XmlnsOPDS    string      `xml:"xmlns:opds,attr"`
+	XmlnsSearch  string      `xml:"xmlns:opensearch,attr"`
+	ID           string      `xml:"id"`
+	Title        string      `xml:"title"`
+	Updated      string      `xml:"updated"`
+	Author       atomAuthor  `xml:"author"`
+	TotalResults int         `xml:"opensearch:totalResults,omitempty"`
+	ItemsPerPage int         `xml:"opensearch:itemsPerPage,omitempty"`
+	Links        []atomLink  `xml:"link"`
+	Entries      []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomLink struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Authors    []atomAuthor   `xml:"author"`
+	Publisher  string         `xml:"dc:publisher,omitempty"`
+	Language   string         `xml:"dc:language,omitempty"`
+	Issued     string         `xml:"dc:issued,omitempty"`
+	Categories []atomCategory `xml:"category"`
+	Content    *atomContent   `xml:"content"`
+	Links      []atomLink     `xml:"link"`
+}
+
+// Name is the catalog's name, shown as the author of the feeds
+const Name = "Pirateca"
+
+// books describes the size of a navigation entry
+func books(n int) string {
+	if n == 1 {
+		return "1 libro"
+	}
+	return fmt.Sprintf("%d libros", n)
+}
+
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Atom returns the OPDS 1.2 document of the feed
+func (f *Feed) Atom() ([]byte, error) {
+	feed := atomFeed{
+		Xmlns:        namespaceAtom,
+		XmlnsDC:      namespaceDC,
+		XmlnsOPDS:    namespaceOPDS,
+		XmlnsSearch:  namespaceSearch,
+		ID:           f.ID,
+		Title:        f.Title,
+		Updated:      atomTime(f.Updated),
+		Author:       atomAuthor{Name: Name},
+		TotalResults: f.TotalResults,
+		ItemsPerPage: f.ItemsPerPage,
+	}
+
+	for _, l := range f.Links {
+		feed.Links = append(feed.Links, atomLink{Rel: l.Rel, Href: l.Href, Type: l.Type, Title: l.Title})
+	}
+
+	for _, n := range f.Navigation {
+		entry := atomEntry{
+			ID:      n.ID,
+			Title:   n.Title,
+			Updated: atomTime(f.Updated),
+			Links:   []atomLink{{Rel: relSubsection, Href: n.Href, Type: n.Type}},
+		}
+		if n.Count > 0 {
+			entry.Content = &atomContent{Type: "text", Text: books(n.Count)}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	for _, p := range f.Publications {
+		entry := atomEntry{
+			ID:        p.ID,
+			Title:     p.Title,
+			Updated:   atomTime(p.Updated),
+			Publisher: p.Publisher,
+			Language:  p.Language,
+		}
+
+		if p.Year > 0 {
+			entry.Issued = strconv.Itoa(p.Year)
+		}
+
+		for _, a := range p.Authors {
+			entry.Authors = append(entry.Authors, atomAuthor{Name: a.Name, URI: a.Href})
+		}
+
+		for _, s := range p.Subjects {
+			entry.Categories = append(entry.Categories, atomCategory{Term: s, Label: s})
+		}
+
+		if p.Cover != nil {
+			entry.Links = append(entry.Links, atomLink{Rel: relImage, Href: p.Cover.Href, Type: p.Cover.Type})
+		}
+		if p.Thumbnail != nil {
+			entry.Links = append(entry.Links, atomLink{Rel: relThumbnail, Href: p.Thumbnail.Href, Type: p.Thumbnail.Type})
+		}
+
+		for _, l := range p.Acquisitions {
+			entry.Links = append(entry.Links, atomLink{Rel: relAcquisition, Href: l.Href, Type: l.Type, Title: l.Title})
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	output, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), output...), nil
+}
+
+type jsonFeed struct {
+	Metadata     jsonFeedMetadata  `json:"metadata"`
+	Links        []jsonLink        `json:"links"`
+	Navigation   []jsonLink        `json:"navigation,omitempty"`
+	Publications []jsonPublication `json:"publications,omitempty"`
+}
+
+type jsonFeedMetadata struct {
+	Title         string `json:"title"`
+	Modified      string `json:"modified,omitempty"`
+	NumberOfItems int    `json:"numberOfItems,omitempty"`
+	ItemsPerPage  int    `json:"itemsPerPage,omitempty"`
+	CurrentPage   int    `json:"currentPage,omitempty"`
+}
+
+type jsonLink struct {
+	Rel        string          `json:"rel,omitempty"`
+	Href       string          `json:"href"`
+	Type       string          `json:"type,omitempty"`
+	Title      string          `json:"title,omitempty"`
+	Templated  bool            `json:"templated,omitempty"`
+	Width      int             `json:"width,omitempty"`
+	Height     int             `json:"height,omitempty"`
+	Properties *jsonProperties `json:"properties,omitempty"`
+}
+
+type jsonProperties struct {
+	NumberOfItems int `json:"numberOfItems,omitempty"`
+}
+
+type jsonContributor struct {
+	Name  string     `json:"name"`
+	Links []jsonLink `json:"links,omitempty"`
+}
+
+type jsonPublicationMetadata struct {
+	Type       string            `json:"@type"`
+	Identifier string            `json:"identifier"`
+	Title      string            `json:"title"`
+	Author     []jsonContributor `json:"author,omitempty"`
+	Publisher  string            `json:"publisher,omitempty"`
+	Language   string            `json:"language,omitempty"`
+	Published  string            `json:"published,omitempty"`
+	Modified   string            `json:"modified,omitempty"`
+	Subject    []string          `json:"subject,omitempty"`
+}
+
+type jsonPublication struct {
+	Metadata jsonPublicationMetadata `json:"metadata"`
+	Links    []jsonLink              `json:"links"`
+	Images   []jsonLink              `json:"images,omitempty"`
+}
+
+// JSON returns the OPDS 2.0 document of the feed
+func (f *Feed) JSON() ([]byte, error) {
+	feed := jsonFeed{
+		Metadata: jsonFeedMetadata{
+			Title:         f.Title,
+			Modified:      atomTime(f.Updated),
+			NumberOfItems: f.TotalResults,
+			ItemsPerPage:  f.ItemsPerPage,
+			CurrentPage:   f.CurrentPage,
+		},
+		Links: []jsonLink{},
+	}
+
+	for _, l := range f.Links {
+		feed.Links = append(feed.Links, jsonLink{Rel: l.Rel, Href: l.Href, Type: l.Type, Title: l.Title, Templated: l.Templated})
+	}
+
+	for _, n := range f.Navigation {
+		link := jsonLink{Rel: relSubsection, Href: n.Href, Type: n.Type, Title: n.Title}
+		if n.Count > 0 {
+			link.Properties = &jsonProperties{NumberOfItems: n.Count}
+		}
+		feed.Navigation = append(feed.Navigation, link)
+	}
+
+	for _, p := range f.Publications {
+		pub := jsonPublication{
+			Metadata: jsonPublicationMetadata{
+				Type:       schemaBook,
+				Identifier: p.ID,
+				Title:      p.Title,
+				Publisher:  p.Publisher,
+				Language:   p.Language,
+				Modified:   atomTime(p.Updated),
+				Subject:    p.Subjects,
+			},
+			Links: []jsonLink{},
+		}
+
+		if p.Year > 0 {
+			pub.Metadata.Published = strconv.Itoa(p.Year)
+		}
+
+		for _, a := range p.Authors {
+			author := jsonContributor{Name: a.Name}
+			if a.Href != "" {
+				author.Links = []jsonLink{{Href: a.Href, Type: TypeJSON}}
+			}
+			pub.Metadata.Author = append(pub.Metadata.Author, author)
+		}
+
+		for _, l := range p.Acquisitions {
+			pub.Links = append(pub.Links, jsonLink{Rel: relAcquisition, Href: l.Href, Type: l.Type, Title: l.Title})
+		}
+
+		for _, image := range []*Image{p.Cover, p.Thumbnail} {
+			if image != nil {
+				pub.Images = append(pub.Images, jsonLink{Href: image.Href, Type: image.Type, Width: image.Width, Height: image.Height})
+			}
+		}
+
+		feed.Publications = append(feed.Publications, pub)
+	}
+
+	return json.MarshalIndent(feed, "", "\t")
+}
+
+type openSearchDescription struct {
+	XMLName        xml.Name      `xml:"OpenSearchDescription"`
+	Xmlns          string        `xml:"xmlns,attr"`
+	ShortName      string        `xml:"ShortName"`
+	Description    string        `xml:"Description"`
+	InputEncoding  string        `xml:"InputEncoding"`
+	OutputEncoding string        `xml:"OutputEncoding"`
+	URL            openSearchURL `xml:"Url"`
+}
+
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Template string `xml:"template,attr"`
+}
+
+// OpenSearch returns the OpenSearch description of the catalog search.
+// template is the URL of the search feed with {searchTerms} in place of the
+// query.
+func OpenSearch(template string) ([]byte, error) {
+	description := openSearchDescription{
+		Xmlns:          namespaceSearch,
+		ShortName:      Name,
+		Description:    "Buscar libros en " + Name,
+		InputEncoding:  "UTF-8",
+		OutputEncoding: "UTF-8",
+		URL:            openSearchURL{Type: TypeAcquisition, Template: template},
+	}
+
+	output, err := xml.MarshalIndent(description, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), output...), nil
+}
@@ -0,0 +1,43 @@
+// Package settings holds the configuration shared by the API and qumranctl, so
+// both read the same config.yaml the same way.
+package settings
+
+import (
+	"fmt"
+
+	"qumran.jesarx.com/internal/imaging"
+	"qumran.jesarx.com/internal/storage"
+
+	"github.com/spf13/viper"
+)
+
+// SetDefaults registers the defaults of the config.yaml keys read by both
+// binaries: the storage backend, the cover renditions, the text extraction and
+// the torrent trackers.
+func SetDefaults() {
+	viper.SetDefault("storage.driver", "local")
+	viper.SetDefault("storage.root", "./uploads")
+	viper.SetDefault("storage.s3.region", "us-east-1")
+	viper.SetDefault("covers.widths", []int{160, 320, 640})
+	viper.SetDefault("covers.formats", []string{imaging.JPEG, imaging.WebP})
+	viper.SetDefault("text.pdftotext_path", "pdftotext")
+	viper.SetDefault("text.sample_pages", 10)
+	viper.SetDefault("torrent.trackers", []string{
+		"udp://tracker.opentrackr.org:1337/announce",
+		"udp://open.demonii.com:1337/announce",
+		"udp://tracker.torrent.eu.org:451/announce",
+	})
+}
+
+// OpenStorage returns the storage backend for the given driver (local|s3).
+// root is only used by the local backend and s3 by the S3 one.
+func OpenStorage(driver string, root string, s3 storage.S3Config) (storage.Storage, error) {
+	switch driver {
+	case "local":
+		return storage.NewLocal(root)
+	case "s3":
+		return storage.NewS3(s3)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", driver)
+	}
+}
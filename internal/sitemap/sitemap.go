@@ -0,0 +1,65 @@
+// Package sitemap renders sitemaps and sitemap indexes (sitemaps.org 0.9).
+package sitemap
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+const (
+	Type  = "application/xml; charset=utf-8"
+	xmlns = "http://www.sitemaps.org/schemas/sitemap/0.9"
+)
+
+// URL is a page of a sitemap, or a sitemap of an index
+type URL struct {
+	Loc     string
+	LastMod time.Time
+}
+
+type entry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type urlset struct {
+	XMLName xml.Name `xml:"urlset"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	URLs    []entry  `xml:"url"`
+}
+
+type index struct {
+	XMLName  xml.Name `xml:"sitemapindex"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Sitemaps []entry  `xml:"sitemap"`
+}
+
+// Sitemap returns the sitemap listing urls
+func Sitemap(urls []URL) ([]byte, error) {
+	return marshal(urlset{Xmlns: xmlns, URLs: entries(urls)})
+}
+
+// Index returns the sitemap index listing the sitemaps at urls
+func Index(urls []URL) ([]byte, error) {
+	return marshal(index{Xmlns: xmlns, Sitemaps: entries(urls)})
+}
+
+func entries(urls []URL) []entry {
+	out := make([]entry, len(urls))
+	for i, u := range urls {
+		out[i].Loc = u.Loc
+		if !u.LastMod.IsZero() {
+			out[i].LastMod = u.LastMod.UTC().Format(time.RFC3339)
+		}
+	}
+	return out
+}
+
+func marshal(v any) ([]byte, error) {
+	output, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), output...), nil
+}
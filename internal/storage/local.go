@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const tempPrefix = ".tmp-"
+
+// Local stores assets on the local filesystem under Root/<kind>/<name>.
+type Local struct {
+	Root string
+}
+
+func NewLocal(root string) (*Local, error) {
+	for _, kind := range Kinds {
+		dir := filepath.Join(root, string(kind))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+	}
+
+	return &Local{Root: root}, nil
+}
+
+func (l *Local) path(kind Kind, name string) (string, error) {
+	clean := filepath.Base(filepath.Clean(name))
+	if clean != name || clean == "." || clean == "/" || strings.HasPrefix(clean, tempPrefix) {
+		return "", fmt.Errorf("storage: invalid object name %q", name)
+	}
+
+	return filepath.Join(l.Root, string(kind), clean), nil
+}
+
+// Put writes the object to a temporary file in the target directory and
+// renames it into place, so readers never see a partially written file.
+func (l *Local) Put(kind Kind, name string, r io.Reader) error {
+	dst, err := l.path(kind, name)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(dst)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
+
+func (l *Local) Get(kind Kind, name string) (Object, *Info, error) {
+	p, err := l.path(kind, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return file, &Info{Kind: kind, Name: name, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (l *Local) Stat(kind Kind, name string) (*Info, error) {
+	p, err := l.path(kind, name)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &Info{Kind: kind, Name: name, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (l *Local) Delete(kind Kind, name string) error {
+	p, err := l.path(kind, name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (l *Local) List(kind Kind) ([]*Info, error) {
+	entries, err := os.ReadDir(filepath.Join(l.Root, string(kind)))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return []*Info{}, nil
+		}
+		return nil, err
+	}
+
+	infos := []*Info{}
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), tempPrefix) {
+			continue
+		}
+
+		fi, err := entry.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+
+		infos = append(infos, &Info{Kind: kind, Name: entry.Name(), Size: fi.Size(), ModTime: fi.ModTime()})
+	}
+
+	return infos, nil
+}
@@ -0,0 +1,480 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string
+	// PathStyle addresses the bucket as /<bucket>/<key> instead of as a
+	// subdomain. MinIO and most self-hosted S3 stand-ins need it.
+	PathStyle bool
+}
+
+// S3 stores assets in an S3-compatible bucket under <prefix><kind>/<name>.
+// Requests are signed with AWS Signature Version 4.
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("storage: s3 endpoint and bucket must be provided")
+	}
+
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("storage: invalid s3 endpoint: %w", err)
+	}
+
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("storage: invalid s3 endpoint %q", cfg.Endpoint)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: 10 * time.Second}).DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			MaxIdleConnsPerHost:   16,
+			IdleConnTimeout:       90 * time.Second,
+		},
+	}
+
+	return &S3{cfg: cfg, endpoint: endpoint, client: client}, nil
+}
+
+func (s *S3) key(kind Kind, name string) (string, error) {
+	if name == "" || strings.Contains(name, "/") || name == "." || name == ".." {
+		return "", fmt.Errorf("storage: invalid object name %q", name)
+	}
+
+	return s.cfg.Prefix + string(kind) + "/" + name, nil
+}
+
+// objectURL builds the request URL for a key (or the bucket itself when key is
+// empty) and returns it together with the canonical URI used for signing.
+func (s *S3) objectURL(key string, query url.Values) (*url.URL, string) {
+	u := *s.endpoint
+	host := u.Host
+
+	path := ""
+	if s.cfg.PathStyle {
+		path = "/" + s.cfg.Bucket
+	} else {
+		host = s.cfg.Bucket + "." + host
+	}
+
+	if key != "" {
+		path += "/" + key
+	} else if path == "" {
+		path = "/"
+	}
+
+	escaped := awsEscape(path, false)
+
+	u.Host = host
+	u.Path = path
+	u.RawPath = escaped
+	u.RawQuery = canonicalQuery(query)
+
+	return &u, escaped
+}
+
+func (s *S3) do(method, key string, query url.Values, header http.Header, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u, canonicalURI := s.objectURL(key, query)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.URL = u
+
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	for k, values := range header {
+		req.Header[k] = values
+	}
+
+	s.sign(req, canonicalURI, payloadHash, time.Now())
+
+	return s.client.Do(req)
+}
+
+func (s *S3) sign(req *http.Request, canonicalURI, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	canonicalRequest, signedHeaders := canonicalRequest(req, canonicalURI, payloadHash)
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashedRequest[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), date)
+	signingKey = hmacSHA256(signingKey, s.cfg.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+// canonicalRequest returns the SigV4 canonical request of req and the list of
+// headers it signs. Besides host, the x-amz-* headers, Range and Content-Type
+// are signed.
+func canonicalRequest(req *http.Request, canonicalURI, payloadHash string) (string, string) {
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+
+	for k := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "range" || lk == "content-type" || strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(req.Header.Get(k))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	return canonicalRequest, signedHeaders
+}
+
+// Put spools the object to a temporary file first: S3 needs the content length
+// and the payload hash before the upload starts.
+func (s *S3) Put(kind Kind, name string, r io.Reader) error {
+	key, err := s.key(kind, name)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp("", "qumran-s3-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	header := make(http.Header)
+	header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := s.do(http.MethodPut, key, nil, header, tmp, size, hex.EncodeToString(hasher.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.responseError(http.MethodPut, key, resp)
+	}
+
+	return nil
+}
+
+func (s *S3) Get(kind Kind, name string) (Object, *Info, error) {
+	info, err := s.Stat(kind, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, _ := s.key(kind, name)
+
+	return &s3Object{s3: s, key: key, size: info.Size}, info, nil
+}
+
+func (s *S3) Stat(kind Kind, name string) (*Info, error) {
+	key, err := s.key(kind, name)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(http.MethodHead, key, nil, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, s.responseError(http.MethodHead, key, resp)
+	}
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	return &Info{Kind: kind, Name: name, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+// Delete reports ErrNotFound when the object does not exist, matching the
+// local backend. S3 itself answers 204 for missing keys.
+func (s *S3) Delete(kind Kind, name string) error {
+	if _, err := s.Stat(kind, name); err != nil {
+		return err
+	}
+
+	key, _ := s.key(kind, name)
+
+	resp, err := s.do(http.MethodDelete, key, nil, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s.responseError(http.MethodDelete, key, resp)
+	}
+
+	return nil
+}
+
+func (s *S3) List(kind Kind) ([]*Info, error) {
+	prefix := s.cfg.Prefix + string(kind) + "/"
+	infos := []*Info{}
+	token := ""
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(http.MethodGet, "", query, nil, nil, 0, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			err = s.responseError(http.MethodGet, prefix, resp)
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var result struct {
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+			Contents              []struct {
+				Key          string    `xml:"Key"`
+				LastModified time.Time `xml:"LastModified"`
+				Size         int64     `xml:"Size"`
+			} `xml:"Contents"`
+		}
+
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range result.Contents {
+			name := strings.TrimPrefix(obj.Key, prefix)
+			if name == "" || strings.Contains(name, "/") {
+				continue
+			}
+			infos = append(infos, &Info{Kind: kind, Name: name, Size: obj.Size, ModTime: obj.LastModified})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	return infos, nil
+}
+
+func (s *S3) responseError(method, key string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	return fmt.Errorf("storage: s3 %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(body)))
+}
+
+// s3Object reads an object lazily with ranged GET requests so it can be used
+// with http.ServeContent, which seeks to serve Range requests.
+type s3Object struct {
+	s3     *S3
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body == nil {
+		header := make(http.Header)
+		header.Set("Range", "bytes="+strconv.FormatInt(o.offset, 10)+"-")
+
+		resp, err := o.s3.do(http.MethodGet, o.key, nil, header, nil, 0, emptyPayloadHash)
+		if err != nil {
+			return 0, err
+		}
+
+		if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+			err = o.s3.responseError(http.MethodGet, o.key, resp)
+			resp.Body.Close()
+			return 0, err
+		}
+
+		o.body = resp.Body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = o.offset + offset
+	case io.SeekEnd:
+		abs = o.size + offset
+	default:
+		return 0, errors.New("storage: invalid whence")
+	}
+
+	if abs < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+
+	if abs != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+
+	o.offset = abs
+
+	return abs, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body != nil {
+		return o.body.Close()
+	}
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsEscape percent-encodes s the way SigV4 expects: every byte except the
+// unreserved characters, and '/' only when encodeSlash is set.
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := []string{}
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("storage: object not found")
+
+// Kind identifies the type of asset stored for a book. Every kind lives in its
+// own directory (local) or key prefix (S3).
+type Kind string
+
+const (
+	KindPDF          Kind = "pdfs"
+	KindCover        Kind = "covers"
+	KindTorrent      Kind = "torrents"
+	KindTorrentAdded Kind = "torrentadded"
+	KindEPUB         Kind = "epubs"
+
+	// KindRendition holds the resized copies of the covers, several per book,
+	// named by the imaging package, so it is not part of Kinds.
+	KindRendition Kind = "renditions"
+
+	// KindQuarantine holds files moved aside by the asset audit. It is not a
+	// book asset, so it is not part of Kinds.
+	KindQuarantine Kind = "quarantine"
+)
+
+// Kinds lists every asset kind a book can have.
+var Kinds = []Kind{KindPDF, KindCover, KindTorrent, KindTorrentAdded, KindEPUB}
+
+// Filename returns the name under which the asset of the given kind is stored
+// for a book with the given base filename.
+func Filename(kind Kind, base string) string {
+	switch kind {
+	case KindPDF:
+		return base + ".pdf"
+	case KindCover:
+		return base + ".jpg"
+	case KindTorrent, KindTorrentAdded:
+		return base + ".pdf.torrent"
+	case KindEPUB:
+		return base + ".epub"
+	default:
+		return base
+	}
+}
+
+type Info struct {
+	Kind    Kind      `json:"kind"`
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+type Object interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+type Storage interface {
+	Put(kind Kind, name string, r io.Reader) error
+	Get(kind Kind, name string) (Object, *Info, error)
+	Stat(kind Kind, name string) (*Info, error)
+	Delete(kind Kind, name string) error
+	List(kind Kind) ([]*Info, error)
+}
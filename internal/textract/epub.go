@@ -0,0 +1,151 @@
+package textract
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"html"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// EPUBText returns the text of an EPUB's documents in reading order.
+func EPUBText(epubPath string) (string, error) {
+	zr, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var container struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+
+	err = readXML(files["META-INF/container.xml"], &container)
+	if err != nil {
+		return "", err
+	}
+	if len(container.Rootfiles) == 0 {
+		return "", errors.New("epub: no package document")
+	}
+
+	opfPath := container.Rootfiles[0].FullPath
+
+	var pkg struct {
+		Items []struct {
+			ID        string `xml:"id,attr"`
+			Href      string `xml:"href,attr"`
+			MediaType string `xml:"media-type,attr"`
+		} `xml:"manifest>item"`
+		Spine []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"spine>itemref"`
+	}
+
+	err = readXML(files[opfPath], &pkg)
+	if err != nil {
+		return "", err
+	}
+
+	hrefs := map[string]string{}
+	for _, item := range pkg.Items {
+		if strings.Contains(item.MediaType, "html") {
+			href, err := url.PathUnescape(item.Href)
+			if err != nil {
+				href = item.Href
+			}
+			hrefs[item.ID] = path.Join(path.Dir(opfPath), href)
+		}
+	}
+
+	var b strings.Builder
+
+	for _, ref := range pkg.Spine {
+		f := files[hrefs[ref.IDRef]]
+		if f == nil {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		doc, err := io.ReadAll(io.LimitReader(rc, 16<<20))
+		rc.Close()
+		if err != nil {
+			return "", err
+		}
+
+		b.WriteString(stripTags(string(doc)))
+		b.WriteByte('\n')
+
+		if b.Len() > MaxBodyBytes {
+			break
+		}
+	}
+
+	return b.String(), nil
+}
+
+func readXML(f *zip.File, v any) error {
+	if f == nil {
+		return errors.New("epub: missing file")
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+// stripTags returns the text of an (X)HTML document, without the contents of
+// its head, scripts and styles
+func stripTags(doc string) string {
+	var b strings.Builder
+
+	if i := strings.Index(strings.ToLower(doc), "<body"); i >= 0 {
+		doc = doc[i:]
+	}
+
+	for len(doc) > 0 {
+		lt := strings.IndexByte(doc, '<')
+		if lt < 0 {
+			b.WriteString(html.UnescapeString(doc))
+			break
+		}
+		b.WriteString(html.UnescapeString(doc[:lt]))
+		doc = doc[lt:]
+
+		gt := strings.IndexByte(doc, '>')
+		if gt < 0 {
+			break
+		}
+		tag := strings.ToLower(doc[1:gt])
+		doc = doc[gt+1:]
+
+		for _, skip := range []string{"script", "style"} {
+			if strings.HasPrefix(tag, skip) {
+				if end := strings.Index(strings.ToLower(doc), "</"+skip); end >= 0 {
+					doc = doc[end:]
+				}
+			}
+		}
+
+		// Keep words of adjacent blocks apart
+		b.WriteByte(' ')
+	}
+
+	return b.String()
+}
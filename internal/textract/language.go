@@ -0,0 +1,75 @@
+package textract
+
+import (
+	"strings"
+	"unicode"
+)
+
+// stopwords are frequent words of each language, as ISO 639-1 codes. Words
+// shared by several languages still count for each of them; the most frequent
+// words of the right language outweigh them.
+var stopwords = map[string][]string{
+	"es": {"el", "la", "los", "las", "de", "del", "que", "y", "en", "un", "una", "por", "con", "no", "su", "para", "es", "al", "lo", "como", "más", "pero", "sus", "le", "ya", "o", "este", "sí", "porque", "esta", "entre", "cuando", "muy", "sin", "sobre", "también", "me", "hasta", "hay", "donde", "desde", "todo", "nos", "durante", "ni", "contra", "otros", "ese", "eso", "ante", "ellos", "esto", "mí", "antes", "algunos", "qué", "unos", "yo", "otro", "otras", "otra", "él", "tanto", "esa", "estos", "mucho", "quienes", "nada", "muchos", "cual", "poco", "ella", "estar", "estas", "algunas", "algo", "nosotros"},
+	"en": {"the", "of", "and", "to", "in", "is", "that", "it", "was", "for", "on", "are", "as", "with", "his", "they", "at", "be", "this", "have", "from", "or", "had", "by", "not", "but", "what", "all", "were", "we", "when", "your", "can", "said", "there", "which", "she", "do", "their", "if", "will", "would", "each", "about", "how", "up", "out", "them", "then", "many", "some", "so", "these", "her", "him", "into", "has", "more", "could", "been", "who", "its", "than", "now", "only", "other", "also", "after", "any", "where"},
+	"pt": {"o", "a", "os", "as", "de", "do", "da", "dos", "das", "que", "e", "em", "um", "uma", "para", "com", "não", "no", "na", "nos", "nas", "por", "mais", "se", "como", "mas", "ao", "ele", "ela", "seu", "sua", "ou", "quando", "muito", "já", "também", "só", "pelo", "pela", "até", "isso", "entre", "depois", "sem", "mesmo", "aos", "seus", "quem", "me", "esse", "eles", "você", "essa", "num", "nem", "suas", "meu", "às", "minha", "numa", "pelos", "elas", "qual", "lhe", "deles", "essas", "esses", "pelas", "este", "dele", "tu", "te", "vocês", "lhes", "isto", "aquilo", "estão", "são", "foi", "há", "então"},
+	"fr": {"le", "la", "les", "de", "des", "du", "et", "en", "un", "une", "est", "que", "qui", "dans", "pour", "pas", "au", "aux", "sur", "ne", "se", "ce", "il", "elle", "par", "plus", "avec", "son", "sa", "ses", "mais", "ou", "comme", "nous", "vous", "leur", "leurs", "été", "fait", "être", "sont", "cette", "ces", "tout", "aussi", "même", "je", "lui", "y", "était", "ont", "où", "donc", "sans", "peut", "entre", "encore", "bien", "deux", "très", "avait", "dont", "ils", "elles", "quand", "alors", "après", "avant", "c'est", "d'un", "d'une", "l'on", "qu'il"},
+	"it": {"il", "lo", "la", "i", "gli", "le", "di", "del", "della", "dei", "delle", "che", "e", "è", "un", "una", "per", "in", "con", "non", "si", "da", "al", "alla", "nel", "nella", "sono", "ma", "come", "anche", "più", "se", "ha", "questo", "questa", "suo", "sua", "loro", "o", "dal", "dalla", "tra", "fra", "quando", "perché", "essere", "stato", "molto", "ancora", "dopo", "sempre", "tutto", "tutti", "cui", "chi", "ci", "già", "solo", "era", "aveva", "hanno", "negli", "sul", "sulla", "degli", "dello", "quello", "quella", "poi", "senza"},
+	"de": {"der", "die", "das", "und", "in", "zu", "den", "von", "mit", "ist", "des", "sich", "nicht", "auf", "für", "als", "auch", "es", "an", "er", "so", "dass", "sie", "nach", "bei", "ein", "eine", "einer", "eines", "einem", "einen", "wie", "dem", "aus", "wird", "werden", "war", "hat", "haben", "noch", "oder", "aber", "nur", "vor", "über", "sein", "seine", "ihre", "ihr", "man", "wenn", "durch", "kann", "schon", "wurde", "sind", "diese", "dieser", "zum", "zur", "um", "ich", "wir", "mehr", "sehr", "hier", "da", "was", "keine", "unter", "gegen"},
+	"ca": {"el", "la", "els", "les", "de", "del", "dels", "que", "i", "en", "un", "una", "per", "amb", "no", "es", "és", "al", "als", "com", "més", "però", "seu", "seva", "seus", "seves", "o", "aquest", "aquesta", "aquests", "entre", "quan", "molt", "sense", "sobre", "també", "fins", "hi", "ha", "on", "des", "tot", "ens", "durant", "ni", "contra", "altres", "això", "abans", "alguns", "què", "uns", "jo", "altre", "ell", "ella", "tant", "aquella", "molts", "qui", "res", "poc", "són", "era", "perquè", "doncs", "mai", "sempre", "nosaltres", "vosaltres"},
+}
+
+var stopwordSets = func() map[string]map[string]bool {
+	sets := map[string]map[string]bool{}
+	for lang, words := range stopwords {
+		sets[lang] = map[string]bool{}
+		for _, w := range words {
+			sets[lang][w] = true
+		}
+	}
+	return sets
+}()
+
+const (
+	// minStopwords is the number of stopword hits needed to name a language
+	minStopwords = 20
+	// minMargin is how much the best language must beat the runner-up by
+	minMargin = 1.15
+)
+
+// DetectLanguage guesses the language of text by counting the stopwords of
+// each known language. It returns an ISO 639-1 code, or an empty string when
+// the text is too short or too mixed to tell.
+func DetectLanguage(text string) string {
+	counts := map[string]int{}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+
+	for _, word := range words {
+		for lang, set := range stopwordSets {
+			if set[word] {
+				counts[lang]++
+			}
+		}
+	}
+
+	best, bestCount, secondCount := "", 0, 0
+	for lang, count := range counts {
+		switch {
+		case count > bestCount || (count == bestCount && lang < best):
+			if best != "" {
+				secondCount = max(secondCount, bestCount)
+			}
+			best, bestCount = lang, count
+		case count > secondCount:
+			secondCount = count
+		}
+	}
+
+	if bestCount < minStopwords || float64(bestCount) < float64(secondCount)*minMargin {
+		return ""
+	}
+
+	return best
+}
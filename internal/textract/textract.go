@@ -0,0 +1,153 @@
+// Package textract extracts the text of uploaded books and what can be learnt
+// from it: whether a PDF has a text layer and which language it is written in.
+package textract
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"qumran.jesarx.com/internal/metadata"
+)
+
+// Extractor returns the text of the first pages of a PDF, or of all of them
+// when pages is 0.
+type Extractor interface {
+	PDFText(path string, pages int) (string, error)
+}
+
+// Pdftotext extracts text with poppler's pdftotext.
+type Pdftotext struct {
+	Path    string
+	Timeout time.Duration
+}
+
+func NewPdftotext(path string, timeout time.Duration) *Pdftotext {
+	if path == "" {
+		path = "pdftotext"
+	}
+
+	return &Pdftotext{Path: path, Timeout: timeout}
+}
+
+func (p *Pdftotext) PDFText(path string, pages int) (string, error) {
+	ctx := context.Background()
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	args := []string{"-enc", "UTF-8", "-q"}
+	if pages > 0 {
+		args = append(args, "-l", strconv.Itoa(pages))
+	}
+	args = append(args, path, "-")
+
+	cmd := exec.CommandContext(ctx, p.Path, args...)
+
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("pdftotext failed: %w, output: %s", err, string(exitErr.Stderr))
+		}
+		return "", fmt.Errorf("pdftotext failed: %w", err)
+	}
+
+	return string(output), nil
+}
+
+// Analysis is what is learnt from a PDF. HasTextLayer and Language are only
+// known when text could be extracted.
+type Analysis struct {
+	Pages        int
+	HasTextLayer *bool
+	Language     string
+}
+
+// minLettersPerPage is the amount of text below which the sampled pages are
+// considered scanned images without a text layer
+const minLettersPerPage = 100
+
+// Analyze counts the pages of the PDF at path and looks at the text of its
+// first samplePages pages. Text extraction is skipped when ex is nil.
+func Analyze(ex Extractor, path string, samplePages int) (*Analysis, error) {
+	pages, err := metadata.PageCount(path)
+	if err != nil {
+		return nil, err
+	}
+
+	analysis := &Analysis{Pages: pages}
+
+	if ex == nil || pages == 0 {
+		return analysis, nil
+	}
+
+	text, err := ex.PDFText(path, samplePages)
+	if err != nil {
+		return analysis, err
+	}
+
+	sampled := pages
+	if samplePages > 0 {
+		sampled = min(pages, samplePages)
+	}
+
+	letters := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+
+	hasText := letters >= minLettersPerPage*sampled
+	analysis.HasTextLayer = &hasText
+
+	if hasText {
+		analysis.Language = DetectLanguage(text)
+	}
+
+	return analysis, nil
+}
+
+// MaxBodyBytes is the most text kept from a book for full-text search
+const MaxBodyBytes = 512 * 1024
+
+// CleanBody prepares extracted text for storage: invalid UTF-8 and NUL bytes
+// are dropped, runs of whitespace collapsed and the result cut to
+// MaxBodyBytes.
+func CleanBody(text string) string {
+	text = strings.ToValidUTF8(text, "")
+
+	var b strings.Builder
+	b.Grow(min(len(text), MaxBodyBytes))
+
+	space := false
+	for _, r := range text {
+		if r == 0 {
+			continue
+		}
+
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+
+		if b.Len()+utf8.RuneLen(r) > MaxBodyBytes {
+			break
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,242 @@
+package torrent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+var ErrInvalidBencode = errors.New("torrent: invalid bencoded data")
+
+// Encode writes v in bencoding. Supported types are strings, byte slices,
+// integers, lists ([]any, []string, [][]string) and dictionaries
+// (map[string]any). Dictionary keys are written in sorted order as required by
+// BEP 3.
+func Encode(w io.Writer, v any) error {
+	var buf bytes.Buffer
+
+	if err := encodeValue(&buf, v); err != nil {
+		return err
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Marshal returns the bencoding of v.
+func Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := encodeValue(&buf, v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func encodeValue(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case string:
+		buf.WriteString(strconv.Itoa(len(v)))
+		buf.WriteByte(':')
+		buf.WriteString(v)
+	case []byte:
+		buf.WriteString(strconv.Itoa(len(v)))
+		buf.WriteByte(':')
+		buf.Write(v)
+	case int:
+		return encodeValue(buf, int64(v))
+	case int64:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatInt(v, 10))
+		buf.WriteByte('e')
+	case []any:
+		buf.WriteByte('l')
+		for _, item := range v {
+			if err := encodeValue(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case []string:
+		buf.WriteByte('l')
+		for _, item := range v {
+			encodeValue(buf, item)
+		}
+		buf.WriteByte('e')
+	case [][]string:
+		buf.WriteByte('l')
+		for _, item := range v {
+			encodeValue(buf, item)
+		}
+		buf.WriteByte('e')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('d')
+		for _, k := range keys {
+			encodeValue(buf, k)
+			if err := encodeValue(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return fmt.Errorf("torrent: cannot bencode value of type %T", v)
+	}
+
+	return nil
+}
+
+// Unmarshal decodes bencoded data. Byte strings become Go strings, integers
+// int64, lists []any and dictionaries map[string]any.
+func Unmarshal(data []byte) (any, error) {
+	d := decoder{data: data}
+
+	v, err := d.value()
+	if err != nil {
+		return nil, err
+	}
+
+	if d.pos != len(d.data) {
+		return nil, ErrInvalidBencode
+	}
+
+	return v, nil
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+	// infoStart and infoEnd delimit the raw "info" value of the top level
+	// dictionary, needed to compute info hashes byte for byte.
+	depth     int
+	infoStart int
+	infoEnd   int
+}
+
+func (d *decoder) value() (any, error) {
+	if d.pos >= len(d.data) {
+		return nil, ErrInvalidBencode
+	}
+
+	switch c := d.data[d.pos]; {
+	case c == 'i':
+		return d.integer()
+	case c == 'l':
+		return d.list()
+	case c == 'd':
+		return d.dict()
+	case c >= '0' && c <= '9':
+		return d.string()
+	default:
+		return nil, ErrInvalidBencode
+	}
+}
+
+func (d *decoder) integer() (any, error) {
+	end := bytes.IndexByte(d.data[d.pos:], 'e')
+	if end < 0 {
+		return nil, ErrInvalidBencode
+	}
+
+	n, err := strconv.ParseInt(string(d.data[d.pos+1:d.pos+end]), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidBencode
+	}
+
+	d.pos += end + 1
+
+	return n, nil
+}
+
+func (d *decoder) string() (string, error) {
+	colon := bytes.IndexByte(d.data[d.pos:], ':')
+	if colon < 0 {
+		return "", ErrInvalidBencode
+	}
+
+	n, err := strconv.Atoi(string(d.data[d.pos : d.pos+colon]))
+	if err != nil || n < 0 {
+		return "", ErrInvalidBencode
+	}
+
+	start := d.pos + colon + 1
+	if start+n > len(d.data) {
+		return "", ErrInvalidBencode
+	}
+
+	d.pos = start + n
+
+	return string(d.data[start : start+n]), nil
+}
+
+func (d *decoder) list() (any, error) {
+	d.pos++
+	d.depth++
+	defer func() { d.depth-- }()
+
+	list := []any{}
+
+	for {
+		if d.pos >= len(d.data) {
+			return nil, ErrInvalidBencode
+		}
+
+		if d.data[d.pos] == 'e' {
+			d.pos++
+			return list, nil
+		}
+
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, v)
+	}
+}
+
+func (d *decoder) dict() (any, error) {
+	d.pos++
+	d.depth++
+	defer func() { d.depth-- }()
+
+	dict := map[string]any{}
+
+	for {
+		if d.pos >= len(d.data) {
+			return nil, ErrInvalidBencode
+		}
+
+		if d.data[d.pos] == 'e' {
+			d.pos++
+			return dict, nil
+		}
+
+		key, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+
+		start := d.pos
+
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+
+		if d.depth == 1 && key == "info" {
+			d.infoStart, d.infoEnd = start, d.pos
+		}
+
+		dict[key] = v
+	}
+}
@@ -0,0 +1,100 @@
+package torrent
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+		want string
+	}{
+		{"string", "spam", "4:spam"},
+		{"empty string", "", "0:"},
+		{"utf-8 string", "año", "4:año"},
+		{"byte string", []byte{0x00, 0xff, ':', 'e'}, "4:\x00\xff:e"},
+		{"int", 42, "i42e"},
+		{"zero", int64(0), "i0e"},
+		{"negative", int64(-3), "i-3e"},
+		{"list", []any{"spam", int64(7)}, "l4:spami7ee"},
+		{"empty list", []any{}, "le"},
+		{"string list", []string{"a", "bc"}, "l1:a2:bce"},
+		{"tiers", [][]string{{"udp://a"}, {"udp://b", "udp://c"}}, "ll7:udp://ael7:udp://b7:udp://cee"},
+		{"empty dict", map[string]any{}, "de"},
+		{
+			"sorted keys",
+			map[string]any{"zeta": int64(1), "alpha": "x", "Beta": "y", "piece length": int64(2), "pieces": ""},
+			"d4:Beta1:y5:alpha1:x12:piece lengthi2e6:pieces0:4:zetai1ee",
+		},
+		{
+			"nested",
+			map[string]any{
+				"info": map[string]any{
+					"b": []any{map[string]any{"y": int64(1), "x": []byte("z")}},
+					"a": map[string]any{"": map[string]any{"length": int64(5)}},
+				},
+				"announce-list": [][]string{{"udp://t"}},
+			},
+			"d13:announce-listll7:udp://tee4:infod1:ad0:d6:lengthi5eee1:bld1:x1:z1:yi1eeeee",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Marshal(tt.v)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshalUnsupported(t *testing.T) {
+	for _, v := range []any{1.5, true, map[string]any{"k": []any{struct{}{}}}} {
+		if _, err := Marshal(v); err == nil {
+			t.Errorf("Marshal(%#v): expected an error", v)
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		data string
+		want any
+	}{
+		{"4:spam", "spam"},
+		{"0:", ""},
+		{"i-42e", int64(-42)},
+		{"le", []any{}},
+		{"l4:spami7ee", []any{"spam", int64(7)}},
+		{"d1:ad1:bl1:ceee", map[string]any{"a": map[string]any{"b": []any{"c"}}}},
+		{"4:\x00\xff:e", "\x00\xff:e"},
+	}
+
+	for _, tt := range tests {
+		got, err := Unmarshal([]byte(tt.data))
+		if err != nil {
+			t.Errorf("Unmarshal(%q): %v", tt.data, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Unmarshal(%q): got %#v, want %#v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	for _, data := range []string{"", "i12", "ixe", "5:abc", "-1:", "l4:spam", "d1:a", "di1ei2ee", "x", "4:spamtrailing"} {
+		if _, err := Unmarshal([]byte(data)); !errors.Is(err, ErrInvalidBencode) {
+			t.Errorf("Unmarshal(%q): got %v, want ErrInvalidBencode", data, err)
+		}
+	}
+}
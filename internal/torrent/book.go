@@ -0,0 +1,43 @@
+package torrent
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// BookOptions returns the creation options for the torrent of a book's PDF.
+// The API and qumranctl both build book torrents with it, so a regenerated
+// torrent only differs from the original in its creation date. baseURL is the
+// public address of the API, added as a web seed when it is known.
+func (c Config) BookOptions(baseURL, name, shortTitle, authorName, authorLastName string) Options {
+	var webSeeds []string
+	if seed := WebSeed(baseURL, name); seed != "" {
+		webSeeds = append(webSeeds, seed)
+	}
+
+	return c.Options(name, BookComment(shortTitle, authorName, authorLastName), webSeeds...)
+}
+
+// WebSeed returns the API download URL of the PDF with the given name, or an
+// empty string when baseURL is empty.
+func WebSeed(baseURL, name string) string {
+	if baseURL == "" {
+		return ""
+	}
+
+	return baseURL + "/v1/pdfs?file=" + url.QueryEscape(name)
+}
+
+// BookComment returns the comment of the torrent of a book, without the
+// control characters the titles and names could carry.
+func BookComment(shortTitle, authorName, authorLastName string) string {
+	comment := strings.Join(strings.Fields(fmt.Sprintf("%s by %s %s", shortTitle, authorName, authorLastName)), " ")
+
+	return strings.Map(func(r rune) rune {
+		if r < 32 || r == 127 {
+			return -1
+		}
+		return r
+	}, comment)
+}
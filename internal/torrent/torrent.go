@@ -0,0 +1,430 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// blockSize is the size of the leaves of the BitTorrent v2 merkle trees.
+const blockSize = 16 * 1024
+
+var (
+	ErrInvalidMetainfo = errors.New("torrent: invalid metainfo")
+	ErrMismatch        = errors.New("torrent: data does not match the torrent")
+)
+
+type Options struct {
+	// Name is the file name announced in the info dictionary.
+	Name string
+	// PieceLength in bytes. It must be a power of two of at least 16 KiB; zero
+	// picks one from the file size the way transmission-create does.
+	PieceLength int64
+	// AnnounceList holds the tracker tiers. The first tracker of the first
+	// tier is also written as "announce".
+	AnnounceList [][]string
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time
+	// Hybrid adds the BitTorrent v2 (BEP 52) fields next to the v1 ones.
+	Hybrid bool
+	// WebSeeds are HTTP URLs serving the file itself (BEP 19 "url-list").
+	WebSeeds []string
+}
+
+// Config holds the settings shared by every torrent generated for the
+// catalog.
+type Config struct {
+	Trackers    []string
+	PieceLength int64
+	Hybrid      bool
+	CreatedBy   string
+}
+
+// Options returns the creation options for a file, putting every tracker in
+// its own tier like transmission-create does.
+func (c Config) Options(name, comment string, webSeeds ...string) Options {
+	announceList := [][]string{}
+	for _, tracker := range c.Trackers {
+		announceList = append(announceList, []string{tracker})
+	}
+
+	return Options{
+		Name:         name,
+		PieceLength:  c.PieceLength,
+		AnnounceList: announceList,
+		Comment:      comment,
+		CreatedBy:    c.CreatedBy,
+		CreationDate: time.Now(),
+		Hybrid:       c.Hybrid,
+		WebSeeds:     webSeeds,
+	}
+}
+
+// MagnetURI builds a magnet link from a hex encoded v1 info hash.
+func MagnetURI(infoHash, name string, trackers, webSeeds []string) string {
+	var b strings.Builder
+
+	b.WriteString("magnet:?xt=urn:btih:")
+	b.WriteString(infoHash)
+
+	if name != "" {
+		b.WriteString("&dn=" + url.QueryEscape(name))
+	}
+
+	for _, tracker := range trackers {
+		b.WriteString("&tr=" + url.QueryEscape(tracker))
+	}
+
+	for _, seed := range webSeeds {
+		b.WriteString("&ws=" + url.QueryEscape(seed))
+	}
+
+	return b.String()
+}
+
+// Torrent is a generated .torrent file.
+type Torrent struct {
+	Data       []byte
+	InfoHash   [20]byte
+	InfoHashV2 []byte
+}
+
+// InfoHashHex returns the hex encoded v1 info hash.
+func (t *Torrent) InfoHashHex() string {
+	return hex.EncodeToString(t.InfoHash[:])
+}
+
+// DefaultPieceLength mirrors the piece size table used by transmission-create.
+func DefaultPieceLength(size int64) int64 {
+	const (
+		KiB = 1024
+		MiB = 1024 * KiB
+		GiB = 1024 * MiB
+	)
+
+	switch {
+	case size >= 2*GiB:
+		return 2 * MiB
+	case size >= 1*GiB:
+		return 1 * MiB
+	case size >= 512*MiB:
+		return 512 * KiB
+	case size >= 350*MiB:
+		return 256 * KiB
+	case size >= 150*MiB:
+		return 128 * KiB
+	case size >= 50*MiB:
+		return 64 * KiB
+	default:
+		return 32 * KiB
+	}
+}
+
+// Create builds single-file metainfo for the size bytes read from r.
+func Create(r io.Reader, size int64, opts Options) (*Torrent, error) {
+	if opts.Name == "" {
+		return nil, errors.New("torrent: name must be provided")
+	}
+
+	pieceLength := opts.PieceLength
+	if pieceLength == 0 {
+		pieceLength = DefaultPieceLength(size)
+	}
+
+	if pieceLength < blockSize || pieceLength&(pieceLength-1) != 0 {
+		return nil, fmt.Errorf("torrent: piece length %d must be a power of two of at least %d", pieceLength, blockSize)
+	}
+
+	pieces := make([]byte, 0, 20*(size/pieceLength+1))
+	leaves := [][32]byte{}
+	buf := make([]byte, pieceLength)
+	var total int64
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			piece := buf[:n]
+			total += int64(n)
+
+			sum := sha1.Sum(piece)
+			pieces = append(pieces, sum[:]...)
+
+			if opts.Hybrid {
+				for off := 0; off < n; off += blockSize {
+					end := min(off+blockSize, n)
+					leaves = append(leaves, sha256.Sum256(piece[off:end]))
+				}
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if total != size {
+		return nil, fmt.Errorf("torrent: read %d bytes, expected %d", total, size)
+	}
+
+	info := map[string]any{
+		"length":       size,
+		"name":         opts.Name,
+		"piece length": pieceLength,
+		"pieces":       pieces,
+		"private":      int64(0),
+	}
+
+	var piecesRoot, pieceLayer []byte
+
+	if opts.Hybrid {
+		file := map[string]any{"length": size}
+
+		if size > 0 {
+			var root [32]byte
+			root, pieceLayer = merkle(leaves, int(pieceLength/blockSize))
+			piecesRoot = root[:]
+			file["pieces root"] = piecesRoot
+		}
+
+		info["meta version"] = int64(2)
+		info["file tree"] = map[string]any{
+			opts.Name: map[string]any{"": file},
+		}
+	}
+
+	infoBytes, err := Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	creationDate := opts.CreationDate
+	if creationDate.IsZero() {
+		creationDate = time.Now()
+	}
+
+	meta := map[string]any{
+		"creation date": creationDate.Unix(),
+		"encoding":      "UTF-8",
+		"info":          info,
+	}
+
+	if len(opts.AnnounceList) > 0 && len(opts.AnnounceList[0]) > 0 {
+		meta["announce"] = opts.AnnounceList[0][0]
+		meta["announce-list"] = opts.AnnounceList
+	}
+
+	if opts.Comment != "" {
+		meta["comment"] = opts.Comment
+	}
+
+	if opts.CreatedBy != "" {
+		meta["created by"] = opts.CreatedBy
+	}
+
+	if len(opts.WebSeeds) > 0 {
+		meta["url-list"] = opts.WebSeeds
+	}
+
+	// Piece layers are only present for files larger than one piece
+	if opts.Hybrid && size > pieceLength {
+		meta["piece layers"] = map[string]any{string(piecesRoot): pieceLayer}
+	}
+
+	data, err := Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Torrent{
+		Data:     data,
+		InfoHash: sha1.Sum(infoBytes),
+	}
+
+	if opts.Hybrid {
+		sum := sha256.Sum256(infoBytes)
+		t.InfoHashV2 = sum[:]
+	}
+
+	return t, nil
+}
+
+// merkle returns the BEP 52 pieces root for the given 16 KiB leaf hashes and
+// the concatenated piece layer.
+func merkle(leaves [][32]byte, blocksPerPiece int) ([32]byte, []byte) {
+	// Files no larger than a piece hash their leaves directly, padded to the
+	// next power of two.
+	if len(leaves) <= blocksPerPiece {
+		padded := make([][32]byte, nextPowerOfTwo(len(leaves)))
+		copy(padded, leaves)
+		root := merkleRoot(padded)
+		return root, root[:]
+	}
+
+	layer := [][32]byte{}
+
+	for off := 0; off < len(leaves); off += blocksPerPiece {
+		padded := make([][32]byte, blocksPerPiece)
+		copy(padded, leaves[off:min(off+blocksPerPiece, len(leaves))])
+		layer = append(layer, merkleRoot(padded))
+	}
+
+	pieceLayer := make([]byte, 0, 32*len(layer))
+	for _, h := range layer {
+		pieceLayer = append(pieceLayer, h[:]...)
+	}
+
+	// Pad the piece layer with the hash of a piece made only of zero leaves
+	padHash := merkleRoot(make([][32]byte, blocksPerPiece))
+	padded := make([][32]byte, nextPowerOfTwo(len(layer)))
+	copy(padded, layer)
+	for i := len(layer); i < len(padded); i++ {
+		padded[i] = padHash
+	}
+
+	return merkleRoot(padded), pieceLayer
+}
+
+func merkleRoot(hashes [][32]byte) [32]byte {
+	for len(hashes) > 1 {
+		next := make([][32]byte, len(hashes)/2)
+		for i := range next {
+			var pair [64]byte
+			copy(pair[:32], hashes[2*i][:])
+			copy(pair[32:], hashes[2*i+1][:])
+			next[i] = sha256.Sum256(pair[:])
+		}
+		hashes = next
+	}
+
+	return hashes[0]
+}
+
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// Metainfo is the subset of a parsed .torrent file the API works with.
+type Metainfo struct {
+	Name         string
+	Length       int64
+	PieceLength  int64
+	Pieces       []byte
+	Comment      string
+	AnnounceList [][]string
+	WebSeeds     []string
+	InfoHash     [20]byte
+}
+
+// InfoHashHex returns the hex encoded v1 info hash.
+func (m *Metainfo) InfoHashHex() string {
+	return hex.EncodeToString(m.InfoHash[:])
+}
+
+// Verify hashes the size bytes read from r piece by piece and compares them
+// with the v1 piece hashes of the torrent. It returns an error wrapping
+// ErrMismatch on the first difference.
+func (m *Metainfo) Verify(r io.Reader, size int64) error {
+	if size != m.Length {
+		return fmt.Errorf("%w: length is %d, expected %d", ErrMismatch, size, m.Length)
+	}
+
+	pieces := int((size + m.PieceLength - 1) / m.PieceLength)
+	if pieces != len(m.Pieces)/20 {
+		return fmt.Errorf("%w: %d pieces, expected %d", ErrMismatch, pieces, len(m.Pieces)/20)
+	}
+
+	buf := make([]byte, m.PieceLength)
+
+	for i := 0; i < pieces; i++ {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+
+		sum := sha1.Sum(buf[:n])
+		if string(sum[:]) != string(m.Pieces[i*20:i*20+20]) {
+			return fmt.Errorf("%w: piece %d differs", ErrMismatch, i)
+		}
+	}
+
+	return nil
+}
+
+// Parse decodes a single-file .torrent.
+func Parse(data []byte) (*Metainfo, error) {
+	d := decoder{data: data}
+
+	v, err := d.value()
+	if err != nil {
+		return nil, err
+	}
+
+	if d.pos != len(data) {
+		return nil, ErrInvalidBencode
+	}
+
+	meta, ok := v.(map[string]any)
+	if !ok {
+		return nil, ErrInvalidMetainfo
+	}
+
+	info, ok := meta["info"].(map[string]any)
+	if !ok {
+		return nil, ErrInvalidMetainfo
+	}
+
+	m := &Metainfo{InfoHash: sha1.Sum(data[d.infoStart:d.infoEnd])}
+
+	m.Name, _ = info["name"].(string)
+	m.Length, _ = info["length"].(int64)
+	m.PieceLength, _ = info["piece length"].(int64)
+	pieces, _ := info["pieces"].(string)
+	m.Pieces = []byte(pieces)
+	m.Comment, _ = meta["comment"].(string)
+
+	if m.PieceLength <= 0 || len(m.Pieces)%20 != 0 {
+		return nil, ErrInvalidMetainfo
+	}
+
+	if tiers, ok := meta["announce-list"].([]any); ok {
+		for _, tier := range tiers {
+			list, _ := tier.([]any)
+			trackers := []string{}
+			for _, tracker := range list {
+				if s, ok := tracker.(string); ok {
+					trackers = append(trackers, s)
+				}
+			}
+			m.AnnounceList = append(m.AnnounceList, trackers)
+		}
+	} else if announce, ok := meta["announce"].(string); ok {
+		m.AnnounceList = [][]string{{announce}}
+	}
+
+	switch seeds := meta["url-list"].(type) {
+	case string:
+		m.WebSeeds = []string{seeds}
+	case []any:
+		for _, seed := range seeds {
+			if s, ok := seed.(string); ok {
+				m.WebSeeds = append(m.WebSeeds, s)
+			}
+		}
+	}
+
+	return m, nil
+}
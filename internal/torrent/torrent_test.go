@@ -0,0 +1,155 @@
+package torrent
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// testContent returns n bytes of a repeating text, the file the vectors below
+// were computed for
+func testContent(n int) []byte {
+	return bytes.Repeat([]byte("qumran pirateca "), n/16+1)[:n]
+}
+
+// The expected hashes were computed outside Go, with Python's hashlib over
+// info dictionaries bencoded by hand following BEP 3 and BEP 52, so they don't
+// depend on the encoder or the merkle code under test.
+func TestCreateVectors(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		pieceLength int64
+		hybrid      bool
+		infoHash    string
+		infoHashV2  string
+		piecesRoot  string
+		pieceLayer  []string
+	}{
+		{
+			name:        "v1 with a short last piece",
+			size:        40000,
+			pieceLength: 16384,
+			infoHash:    "31bfe3bd3362b6dfcca1e4c2fed484c38979949a",
+		},
+		{
+			name:        "hybrid with padded leaves",
+			size:        40000,
+			pieceLength: 32768,
+			hybrid:      true,
+			infoHash:    "16e73a1338b6e4becb806877d9d40122033a11e0",
+			infoHashV2:  "3dc9bfacf6894a595c008f45babd5cc2be0da6e8477b34fd6796224589377c81",
+			piecesRoot:  "58b32f6acdf68a3d84c0610fbb86d795009a5db4e7f9f55cc09682c6a15da159",
+			pieceLayer: []string{
+				"aa07b4a6ce6771709ecfc28d2e5d5ecfa2dab6432988ec0b3b864ac677a27d59",
+				"cd0efb15cb0040421b5ed30d59cffb7e48922b38d01ecaa9483544437a87d078",
+			},
+		},
+		{
+			// A file of one block is its own merkle tree: the pieces root is
+			// the SHA-256 of the file and there are no piece layers
+			name:        "hybrid single block",
+			size:        1000,
+			pieceLength: 16384,
+			hybrid:      true,
+			piecesRoot:  "088605cdf594b9cc4ebf8a7568132a296d20d274beb09e9f40221f31a954e209",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := testContent(tt.size)
+
+			tor, err := Create(bytes.NewReader(content), int64(tt.size), Options{
+				Name:         "libro.pdf",
+				PieceLength:  tt.pieceLength,
+				AnnounceList: [][]string{{"udp://tracker.example:1337/announce"}},
+				Comment:      "a comment outside the info dictionary",
+				CreationDate: time.Unix(1700000000, 0),
+				Hybrid:       tt.hybrid,
+			})
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+
+			if tt.infoHash != "" && tor.InfoHashHex() != tt.infoHash {
+				t.Errorf("info hash: got %s, want %s", tor.InfoHashHex(), tt.infoHash)
+			}
+			if tt.infoHashV2 != "" && hex.EncodeToString(tor.InfoHashV2) != tt.infoHashV2 {
+				t.Errorf("v2 info hash: got %x, want %s", tor.InfoHashV2, tt.infoHashV2)
+			}
+
+			// The info hash must survive a round trip through the file
+			meta, err := Parse(tor.Data)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if meta.InfoHash != tor.InfoHash {
+				t.Errorf("parsed info hash: got %x, want %x", meta.InfoHash, tor.InfoHash)
+			}
+			if meta.Length != int64(tt.size) || meta.PieceLength != tt.pieceLength || meta.Name != "libro.pdf" {
+				t.Errorf("parsed %q of %d bytes in pieces of %d", meta.Name, meta.Length, meta.PieceLength)
+			}
+
+			if !tt.hybrid {
+				return
+			}
+
+			v, err := Unmarshal(tor.Data)
+			if err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			top := v.(map[string]any)
+			info := top["info"].(map[string]any)
+
+			if info["meta version"] != int64(2) {
+				t.Errorf("meta version: got %v, want 2", info["meta version"])
+			}
+
+			file, _ := info["file tree"].(map[string]any)["libro.pdf"].(map[string]any)[""].(map[string]any)
+			root, _ := file["pieces root"].(string)
+			if hex.EncodeToString([]byte(root)) != tt.piecesRoot {
+				t.Errorf("pieces root: got %x, want %s", root, tt.piecesRoot)
+			}
+
+			layers, _ := top["piece layers"].(map[string]any)
+			if len(tt.pieceLayer) == 0 {
+				if layers != nil {
+					t.Errorf("piece layers: got %d entries, want none", len(layers))
+				}
+				return
+			}
+
+			var want []byte
+			for _, h := range tt.pieceLayer {
+				b, _ := hex.DecodeString(h)
+				want = append(want, b...)
+			}
+			if layer, _ := layers[root].(string); layer != string(want) {
+				t.Errorf("piece layer: got %x, want %x", layer, want)
+			}
+		})
+	}
+}
+
+func TestCreateInvalid(t *testing.T) {
+	content := testContent(100)
+
+	tests := []struct {
+		name string
+		size int64
+		opts Options
+	}{
+		{"no name", 100, Options{}},
+		{"piece length below a block", 100, Options{Name: "a", PieceLength: 8192}},
+		{"piece length not a power of two", 100, Options{Name: "a", PieceLength: 3 * 16384}},
+		{"short read", 200, Options{Name: "a"}},
+	}
+
+	for _, tt := range tests {
+		if _, err := Create(bytes.NewReader(content), tt.size, tt.opts); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
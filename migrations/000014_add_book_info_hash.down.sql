@@ -0,0 +1,41 @@
+DROP INDEX IF EXISTS books_info_hash_idx;
+
+ALTER TABLE books DROP COLUMN IF EXISTS info_hash;
+
+CREATE OR REPLACE FUNCTION update_slug()
+RETURNS TRIGGER AS $$
+DECLARE
+    base_slug text;
+BEGIN
+    SELECT 
+        LOWER(
+            REGEXP_REPLACE(
+                UNACCENT(COALESCE(a.last_name, '')),
+                '[^a-zA-Z0-9]+', '-', 'g'
+            ) || '-' ||
+            REGEXP_REPLACE(
+                UNACCENT(COALESCE(a.name, '')),
+                '[^a-zA-Z0-9]+', '-', 'g'
+            ) || '-' ||
+            REGEXP_REPLACE(
+                UNACCENT(NEW.short_title),
+                '[^a-zA-Z0-9]+', '-', 'g'
+            )
+        ) INTO base_slug
+    FROM authors a
+    WHERE a.id = NEW.auth_id;
+
+    IF base_slug IS NULL THEN
+        base_slug := LOWER(
+            REGEXP_REPLACE(
+                UNACCENT(NEW.short_title),
+                '[^a-zA-Z0-9]+', '-', 'g'
+            )
+        );
+    END IF;
+
+    NEW.slug := generate_unique_slug(base_slug);
+    
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,53 @@
+ALTER TABLE books ADD COLUMN info_hash TEXT;
+
+CREATE INDEX IF NOT EXISTS books_info_hash_idx ON books (info_hash);
+
+-- Keep the current slug when an update doesn't touch the columns it is built
+-- from, so asset bookkeeping (info_hash, etc.) doesn't change book URLs
+CREATE OR REPLACE FUNCTION update_slug()
+RETURNS TRIGGER AS $$
+DECLARE
+    base_slug text;
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND NEW.short_title IS NOT DISTINCT FROM OLD.short_title
+        AND NEW.auth_id IS NOT DISTINCT FROM OLD.auth_id THEN
+        NEW.slug := OLD.slug;
+        RETURN NEW;
+    END IF;
+
+    -- Get author information
+    SELECT 
+        LOWER(
+            REGEXP_REPLACE(
+                UNACCENT(COALESCE(a.last_name, '')),
+                '[^a-zA-Z0-9]+', '-', 'g'
+            ) || '-' ||
+            REGEXP_REPLACE(
+                UNACCENT(COALESCE(a.name, '')),
+                '[^a-zA-Z0-9]+', '-', 'g'
+            ) || '-' ||
+            REGEXP_REPLACE(
+                UNACCENT(NEW.short_title),
+                '[^a-zA-Z0-9]+', '-', 'g'
+            )
+        ) INTO base_slug
+    FROM authors a
+    WHERE a.id = NEW.auth_id;
+
+    -- If no author found, use only the book title
+    IF base_slug IS NULL THEN
+        base_slug := LOWER(
+            REGEXP_REPLACE(
+                UNACCENT(NEW.short_title),
+                '[^a-zA-Z0-9]+', '-', 'g'
+            )
+        );
+    END IF;
+
+    -- Generate unique slug
+    NEW.slug := generate_unique_slug(base_slug);
+    
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS jobs;
+
+DELETE FROM permissions WHERE code = 'jobs:read';
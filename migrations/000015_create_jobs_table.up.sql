@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS jobs (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  kind text NOT NULL,
+  payload jsonb NOT NULL DEFAULT '{}',
+  status text NOT NULL DEFAULT 'queued',
+  stage text NOT NULL DEFAULT '',
+  progress integer NOT NULL DEFAULT 0,
+  attempts integer NOT NULL DEFAULT 0,
+  max_attempts integer NOT NULL DEFAULT 5,
+  run_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  last_error text NOT NULL DEFAULT '',
+  result jsonb,
+  user_id bigint REFERENCES users ON DELETE SET NULL
+);
+
+ALTER TABLE jobs ADD CONSTRAINT jobs_status_check CHECK (status IN ('queued', 'running', 'retrying', 'succeeded', 'failed'));
+ALTER TABLE jobs ADD CONSTRAINT jobs_progress_check CHECK (progress BETWEEN 0 AND 100);
+
+CREATE INDEX IF NOT EXISTS jobs_runnable_idx ON jobs (run_at) WHERE status IN ('queued', 'retrying');
+
+INSERT INTO permissions (code)
+VALUES
+  ('jobs:read');
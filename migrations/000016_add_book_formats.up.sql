@@ -0,0 +1,3 @@
+ALTER TABLE books ADD COLUMN formats text[] NOT NULL DEFAULT '{}';
+
+UPDATE books SET formats = '{pdf}' WHERE filename IS NOT NULL AND filename <> '';
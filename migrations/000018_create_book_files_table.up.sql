@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS book_files (
+  book_id bigint NOT NULL REFERENCES books ON DELETE CASCADE,
+  kind text NOT NULL,
+  name text NOT NULL,
+  size bigint NOT NULL,
+  sha256 text NOT NULL,
+  source_sha256 text,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (book_id, kind)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS book_files_kind_name_idx ON book_files (kind, name);
+CREATE INDEX IF NOT EXISTS book_files_sha256_idx ON book_files (sha256);
+CREATE UNIQUE INDEX IF NOT EXISTS book_files_pdf_source_idx ON book_files (source_sha256) WHERE kind = 'pdfs';
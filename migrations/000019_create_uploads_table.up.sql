@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS uploads (
+  id text PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  upload_length bigint NOT NULL,
+  upload_offset bigint NOT NULL DEFAULT 0,
+  metadata text NOT NULL DEFAULT '',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  expires_at timestamp(0) with time zone NOT NULL
+);
+
+ALTER TABLE uploads ADD CONSTRAINT uploads_offset_check CHECK (upload_offset BETWEEN 0 AND upload_length);
+
+CREATE INDEX IF NOT EXISTS uploads_expires_at_idx ON uploads (expires_at);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS book_covers (
+  book_id bigint NOT NULL REFERENCES books ON DELETE CASCADE,
+  size integer NOT NULL,
+  format text NOT NULL,
+  name text NOT NULL,
+  width integer NOT NULL,
+  height integer NOT NULL,
+  bytes bigint NOT NULL,
+  sha256 text NOT NULL,
+  PRIMARY KEY (book_id, size, format)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS book_covers_name_idx ON book_covers (name);
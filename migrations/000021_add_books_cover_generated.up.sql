@@ -0,0 +1 @@
+ALTER TABLE books ADD COLUMN cover_generated boolean NOT NULL DEFAULT false;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS books_language_idx;
+
+ALTER TABLE books DROP COLUMN IF EXISTS language;
+ALTER TABLE books DROP COLUMN IF EXISTS has_text_layer;
+ALTER TABLE books DROP COLUMN IF EXISTS pages_detected;
@@ -0,0 +1,5 @@
+ALTER TABLE books ADD COLUMN pages_detected integer NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN has_text_layer boolean;
+ALTER TABLE books ADD COLUMN language text NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS books_language_idx ON books (language);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS books_search_document_idx;
+
+ALTER TABLE books DROP COLUMN IF EXISTS search_document;
+
+DROP TABLE IF EXISTS book_texts;
+
+DROP TEXT SEARCH CONFIGURATION IF EXISTS spanish_unaccent;
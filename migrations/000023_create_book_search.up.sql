@@ -0,0 +1,25 @@
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+-- Spanish stemming that ignores accents, so "accion" finds "acción" and
+-- ts_headline still highlights the original accented text
+CREATE TEXT SEARCH CONFIGURATION spanish_unaccent (COPY = spanish);
+ALTER TEXT SEARCH CONFIGURATION spanish_unaccent
+  ALTER MAPPING FOR hword, hword_part, word WITH unaccent, spanish_stem;
+
+CREATE TABLE IF NOT EXISTS book_texts (
+  book_id bigint PRIMARY KEY REFERENCES books ON DELETE CASCADE,
+  body text NOT NULL DEFAULT '',
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE books ADD COLUMN search_document tsvector NOT NULL DEFAULT ''::tsvector;
+
+UPDATE books b
+SET search_document =
+  setweight(to_tsvector('spanish_unaccent', b.title), 'A') ||
+  setweight(to_tsvector('spanish_unaccent', concat_ws(' ',
+    (SELECT concat_ws(' ', a.name, a.last_name) FROM authors a WHERE a.id = b.auth_id),
+    (SELECT concat_ws(' ', a.name, a.last_name) FROM authors a WHERE a.id = b.auth2_id))), 'B') ||
+  setweight(to_tsvector('spanish_unaccent', coalesce(b.description, '')), 'C');
+
+CREATE INDEX IF NOT EXISTS books_search_document_idx ON books USING GIN (search_document);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS publishers_name_trgm_idx;
+DROP INDEX IF EXISTS authors_name_trgm_idx;
+DROP INDEX IF EXISTS books_title_trgm_idx;
+
+DROP FUNCTION IF EXISTS f_unaccent(text);
+
+DROP EXTENSION IF EXISTS pg_trgm;
@@ -0,0 +1,12 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+-- unaccent() is only STABLE because its dictionary can change, so it cannot be
+-- used in an index. Naming the dictionary makes the result fixed.
+CREATE OR REPLACE FUNCTION f_unaccent(text) RETURNS text
+  LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT
+  AS $$ SELECT public.unaccent('public.unaccent'::regdictionary, $1) $$;
+
+CREATE INDEX IF NOT EXISTS books_title_trgm_idx ON books USING GIN (f_unaccent(title) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS authors_name_trgm_idx ON authors USING GIN (f_unaccent(coalesce(name, '') || ' ' || last_name) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS publishers_name_trgm_idx ON publishers USING GIN (f_unaccent(name) gin_trgm_ops);
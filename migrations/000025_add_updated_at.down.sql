@@ -0,0 +1,3 @@
+ALTER TABLE publishers DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE authors DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE books DROP COLUMN IF EXISTS updated_at;
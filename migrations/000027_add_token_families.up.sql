@@ -0,0 +1,9 @@
+CREATE SEQUENCE IF NOT EXISTS token_families_seq;
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family_id bigint;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS used_at timestamp(0) with time zone;
+
+-- Tokens issued before refresh tokens are sessions of their own
+UPDATE tokens SET family_id = nextval('token_families_seq') WHERE scope = 'authentication';
+
+CREATE INDEX IF NOT EXISTS tokens_family_id_idx ON tokens (family_id);
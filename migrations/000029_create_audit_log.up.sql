@@ -0,0 +1,16 @@
+INSERT INTO permissions (code)
+VALUES
+  ('admin:users');
+
+CREATE TABLE IF NOT EXISTS audit_log (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  actor_id bigint REFERENCES users ON DELETE SET NULL,
+  action text NOT NULL,
+  target_user_id bigint REFERENCES users ON DELETE SET NULL,
+  details jsonb NOT NULL DEFAULT '{}',
+  ip text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_target_user_id_idx ON audit_log (target_user_id);
+CREATE INDEX IF NOT EXISTS audit_log_actor_id_idx ON audit_log (actor_id);